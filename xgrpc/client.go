@@ -38,6 +38,12 @@ func DialWithClientCert(ctx context.Context, server string, cert []byte, options
 	return grpc.DialContext(ctx, server, options...)
 }
 
+// DialWithTLSConfig dials server with mTLS config, e.g. created by xsecurity.NewClientTLSConfig with a client certificate
+func DialWithTLSConfig(ctx context.Context, server string, config *tls.Config, options ...grpc.DialOption) (cc *grpc.ClientConn, err error) {
+	options = append(options, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	return grpc.DialContext(ctx, server, options...)
+}
+
 func Dial(ctx context.Context, server string, options ...grpc.DialOption) (cc *grpc.ClientConn, err error) {
 	options = append(options, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	return grpc.DialContext(ctx, server, options...)
@@ -42,6 +42,14 @@ func NewContent(data []byte, typ string) Content {
 	}
 }
 
+func NewReadSeekContent(r io.ReadSeeker, size int64, typ string) Content {
+	return &contentImpl{
+		ReadSeeker: r,
+		typ:        typ,
+		size:       size,
+	}
+}
+
 func NewRangeHandler(f func(req *http.Request) (Content, error)) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		content, err := f(req)
@@ -46,6 +46,11 @@ const (
 	KeyAPIKey    = "X-Api-Key"
 	KeyServiceID = "X-Service-Id"
 	KeyTimestamp = "X-Timestamp"
+
+	KeyKeyID         = "X-Key-Id"
+	KeyNonce         = "X-Nonce"
+	KeySignedHeaders = "X-Signed-Headers"
+	KeySignature     = "X-Signature"
 )
 
 const (
@@ -75,6 +80,11 @@ const (
 	LowerKeyAPIKey    = "x-api-key"
 	LowerKeyServiceID = "x-service-id"
 	LowerKeyTimestamp = "x-timestamp"
+
+	LowerKeyKeyID         = "x-key-id"
+	LowerKeyNonce         = "x-nonce"
+	LowerKeySignedHeaders = "x-signed-headers"
+	LowerKeySignature     = "x-signature"
 )
 
 const (
@@ -2,11 +2,15 @@ package xhttpheader
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.olapie.com/x/xerror"
+	"go.olapie.com/x/xsecurity"
 )
 
 var getCurrentTime = func() time.Time {
@@ -65,7 +69,7 @@ func Verify[T ~map[string][]string | ~map[string]string](h T, leeway time.Durati
 		return xerror.BadRequest("invalid %s: %s", KeyTimestamp, timestamp)
 	}
 
-	if time.Unix(t, 0).Add(leeway).Before(getCurrentTime()) {
+	if time.UnixMilli(t).Add(leeway).Before(getCurrentTime()) {
 		return xerror.BadRequest("expired %s: %s", KeyAPIKey, timestamp)
 	}
 
@@ -80,3 +84,63 @@ func generateAPIKey(clientID, traceID string, timestamp int64) string {
 	var digest = fmt.Sprintf("%s.%s.%d", clientID, traceID, timestamp)
 	return fmt.Sprintf("%x", md5.Sum([]byte(digest)))
 }
+
+// SignRequest signs method, path, body and headers named by signedHeaders with key
+// It sets KeyKeyID, KeyTimestamp, KeyNonce, KeySignedHeaders and KeySignature
+func SignRequest[H HeaderTypes](h H, key *xsecurity.SigningKey, method, path string, body []byte, signedHeaders ...string) error {
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	r := xsecurity.NewCanonicalRequest(method, path, body, getCurrentTime().UnixMilli(), hex.EncodeToString(nonce[:]))
+	names := make([]string, len(signedHeaders))
+	for i, name := range signedHeaders {
+		names[i] = strings.ToLower(name)
+		r.AddHeader(name, Get(h, name))
+	}
+	sign, err := key.Sign(r)
+	if err != nil {
+		return err
+	}
+	Set(h, KeyKeyID, key.ID)
+	Set(h, KeyTimestamp, fmt.Sprint(r.Timestamp))
+	Set(h, KeyNonce, r.Nonce)
+	Set(h, KeySignedHeaders, strings.Join(names, ";"))
+	Set(h, KeySignature, sign)
+	return nil
+}
+
+// VerifyRequest verifies signature set by SignRequest with keys
+// Timestamp must be within leeway of current time. If nonces is not nil, replayed requests are rejected
+func VerifyRequest[H HeaderTypes](h H, keys *xsecurity.SigningKeySet, nonces *xsecurity.NonceCache, method, path string, body []byte, leeway time.Duration) error {
+	for _, key := range []string{KeySignature, KeyKeyID, KeyTimestamp, KeyNonce} {
+		if Get(h, key) == "" {
+			return xerror.BadRequest("missing %s", key)
+		}
+	}
+
+	timestamp := Get(h, KeyTimestamp)
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return xerror.BadRequest("invalid %s: %s", KeyTimestamp, timestamp)
+	}
+	now := getCurrentTime()
+	signedAt := time.UnixMilli(t)
+	if signedAt.Add(leeway).Before(now) || signedAt.Add(-leeway).After(now) {
+		return xerror.BadRequest("expired %s: %s", KeySignature, timestamp)
+	}
+
+	r := xsecurity.NewCanonicalRequest(method, path, body, t, Get(h, KeyNonce))
+	if signedHeaders := Get(h, KeySignedHeaders); signedHeaders != "" {
+		for _, name := range strings.Split(signedHeaders, ";") {
+			r.AddHeader(name, Get(h, name))
+		}
+	}
+	if err = keys.Verify(Get(h, KeyKeyID), r, Get(h, KeySignature)); err != nil {
+		return xerror.Unauthorized("invalid %s: %v", KeySignature, err)
+	}
+
+	// nonce is checked after signature, so that forged requests cannot fill up the cache
+	if nonces != nil && !nonces.Use(r.Nonce, now, signedAt.Add(leeway)) {
+		return xerror.BadRequest("replayed %s: %s", KeyNonce, r.Nonce)
+	}
+	return nil
+}
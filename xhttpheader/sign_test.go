@@ -1,11 +1,15 @@
 package xhttpheader
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
 	"github.com/google/uuid"
 	"net/http"
 	"testing"
 	"time"
+
+	"go.olapie.com/x/xsecurity"
 )
 
 func TestCreateSimpleAPIKey(t *testing.T) {
@@ -23,3 +27,90 @@ func TestCreateSimpleAPIKey(t *testing.T) {
 		t.Fatal("should have expired")
 	}
 }
+
+func TestVerify_Timestamp(t *testing.T) {
+	defer SetCurrentTimeFunc(getCurrentTime)
+	now := time.Now()
+	SetCurrentTimeFunc(func() time.Time {
+		return now
+	})
+	header := http.Header{}
+	SetClientID(header, uuid.NewString())
+	SetTraceID(header, uuid.NewString())
+	Sign(header)
+
+	// timestamp is in milliseconds
+	now = now.Add(4 * time.Second)
+	if err := Verify(header, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Second)
+	if err := Verify(header, 5*time.Second); err == nil {
+		t.Fatal("should have expired")
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey := xsecurity.NewHMACSigningKey("k1", []byte("secret"))
+	newKey := xsecurity.NewEd25519SigningKey("k2", priv)
+	keys := xsecurity.NewSigningKeySet(oldKey, xsecurity.NewEd25519VerifyingKey("k2", priv.Public().(ed25519.PublicKey)))
+	body := []byte(`{"name":"test"}`)
+
+	for _, key := range []*xsecurity.SigningKey{oldKey, newKey} {
+		t.Run(key.ID, func(t *testing.T) {
+			nonces := xsecurity.NewNonceCache(0)
+			header := http.Header{}
+			SetClientID(header, uuid.NewString())
+			SetContentType(header, "application/json")
+			if err := SignRequest(header, key, http.MethodPost, "/items?x=1", body, KeyClientID, KeyContentType); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyRequest(header, keys, nonces, http.MethodPost, "/items?x=1", body, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyRequest(header, keys, nonces, http.MethodPost, "/items?x=1", body, time.Minute); err == nil {
+				t.Fatal("should reject replay")
+			}
+			if err := VerifyRequest(header, keys, nil, http.MethodPost, "/items?x=1", []byte("{}"), time.Minute); err == nil {
+				t.Fatal("should reject modified body")
+			}
+			if err := VerifyRequest(header, keys, nil, http.MethodPut, "/items?x=1", body, time.Minute); err == nil {
+				t.Fatal("should reject modified method")
+			}
+			SetClientID(header, uuid.NewString())
+			if err := VerifyRequest(header, keys, nil, http.MethodPost, "/items?x=1", body, time.Minute); err == nil {
+				t.Fatal("should reject modified header")
+			}
+		})
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		header := http.Header{}
+		if err := SignRequest(header, oldKey, http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+		keys.Remove(oldKey.ID)
+		defer keys.Add(oldKey)
+		if err := VerifyRequest(header, keys, nil, http.MethodGet, "/", nil, time.Minute); err == nil {
+			t.Fatal("should reject removed key")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		header := http.Header{}
+		if err := SignRequest(header, oldKey, http.MethodGet, "/", nil); err != nil {
+			t.Fatal(err)
+		}
+		SetCurrentTimeFunc(func() time.Time {
+			return time.Now().Add(2 * time.Minute)
+		})
+		defer SetCurrentTimeFunc(time.Now)
+		if err := VerifyRequest(header, keys, nil, http.MethodGet, "/", nil, time.Minute); err == nil {
+			t.Fatal("should have expired")
+		}
+	})
+}
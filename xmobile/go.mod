@@ -26,3 +26,9 @@ require (
 	modernc.org/memory v1.11.0 // indirect
 	modernc.org/sqlite v1.40.1 // indirect
 )
+
+// observer.go depends on the observer API of xsqlite which is not tagged yet
+replace (
+	go.olapie.com/x => ../
+	go.olapie.com/x/xsqlite => ../xsqlite
+)
@@ -0,0 +1,52 @@
+package xmobile
+
+import (
+	"go.olapie.com/x/xsqlite"
+)
+
+const (
+	ChangeInserted = int(xsqlite.Inserted)
+	ChangeUpdated  = int(xsqlite.Updated)
+	ChangeDeleted  = int(xsqlite.Deleted)
+)
+
+// ChangeListener receives changes of xsqlite tables. kind is one of ChangeInserted, ChangeUpdated and ChangeDeleted
+type ChangeListener interface {
+	OnChange(kind int, keys *StringList)
+}
+
+// Observation stops delivering changes to its listener after Cancel
+type Observation struct {
+	cancel func()
+}
+
+func (o *Observation) Cancel() {
+	if o != nil && o.cancel != nil {
+		o.cancel()
+	}
+}
+
+// ObserveTable delivers changes of records of keys in table to listener, or all records if keys is empty
+// Apps call it with their xsqlite tables, and return Observation to the mobile side
+func ObserveTable(table xsqlite.Observable, listener ChangeListener, keys *StringList) *Observation {
+	var l []string
+	if keys != nil {
+		l = keys.Elements()
+	}
+	return &Observation{cancel: table.Observe(toChangeFunc(listener), l...)}
+}
+
+// ObserveTablePrefix delivers changes of records whose keys have prefix in table to listener
+func ObserveTablePrefix(table xsqlite.Observable, prefix string, listener ChangeListener) *Observation {
+	return &Observation{cancel: table.ObservePrefix(prefix, toChangeFunc(listener))}
+}
+
+func toChangeFunc(listener ChangeListener) func(e *xsqlite.ChangeEvent) {
+	return func(e *xsqlite.ChangeEvent) {
+		keys := NewStringList()
+		for _, k := range e.Keys {
+			keys.Add(k)
+		}
+		listener.OnChange(int(e.Kind), keys)
+	}
+}
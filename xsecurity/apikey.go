@@ -1,11 +1,19 @@
 package xsecurity
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // GenerateAPIKey generates API key for each request
@@ -33,3 +41,172 @@ func generateRequestDigest(traceID string, timestamp int64) []byte {
 	sum := sha256.Sum256([]byte(msg))
 	return sum[:]
 }
+
+const requestSignatureVersion = "xsecurity-request-v1"
+
+// CanonicalRequest is the signed content of a request
+type CanonicalRequest struct {
+	Method string
+	// Path is the request path with raw query
+	Path string
+	// BodyHash is SHA-256 of request body
+	BodyHash []byte
+	// Headers are selected headers in "name: value" form. Names are lower-cased, and the order matters
+	Headers   []string
+	Timestamp int64
+	Nonce     string
+}
+
+// NewCanonicalRequest creates a request with body hash, selected headers can be added by AddHeader
+func NewCanonicalRequest(method, path string, body []byte, timestamp int64, nonce string) *CanonicalRequest {
+	sum := sha256.Sum256(body)
+	return &CanonicalRequest{
+		Method:    strings.ToUpper(method),
+		Path:      path,
+		BodyHash:  sum[:],
+		Timestamp: timestamp,
+		Nonce:     nonce,
+	}
+}
+
+func (r *CanonicalRequest) AddHeader(name, value string) {
+	r.Headers = append(r.Headers, strings.ToLower(name)+": "+strings.TrimSpace(value))
+}
+
+func (r *CanonicalRequest) bytes(keyID string) []byte {
+	var b strings.Builder
+	for _, s := range []string{
+		requestSignatureVersion,
+		keyID,
+		r.Method,
+		r.Path,
+		strconv.FormatInt(r.Timestamp, 10),
+		r.Nonce,
+		hex.EncodeToString(r.BodyHash),
+	} {
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	for _, h := range r.Headers {
+		b.WriteString(h)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// SigningKey signs requests with HMAC-SHA256 or Ed25519
+// ID is carried with signatures, so several keys can be valid during rotation
+type SigningKey struct {
+	ID         string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+func NewHMACSigningKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, secret: bytes.Clone(secret)}
+}
+
+func NewEd25519SigningKey(id string, privateKey ed25519.PrivateKey) *SigningKey {
+	return &SigningKey{ID: id, privateKey: privateKey, publicKey: privateKey.Public().(ed25519.PublicKey)}
+}
+
+// NewEd25519VerifyingKey creates a key which can only verify signatures, e.g. on server side
+func NewEd25519VerifyingKey(id string, publicKey ed25519.PublicKey) *SigningKey {
+	return &SigningKey{ID: id, publicKey: publicKey}
+}
+
+// Sign returns base64 url encoded signature of r
+func (k *SigningKey) Sign(r *CanonicalRequest) (string, error) {
+	msg := r.bytes(k.ID)
+	switch {
+	case k.secret != nil:
+		return base64.RawURLEncoding.EncodeToString(hmacSHA256(k.secret, msg)), nil
+	case k.privateKey != nil:
+		return base64.RawURLEncoding.EncodeToString(ed25519.Sign(k.privateKey, msg)), nil
+	default:
+		return "", fmt.Errorf("cannot sign with key %s", k.ID)
+	}
+}
+
+func (k *SigningKey) Verify(r *CanonicalRequest, signature string) error {
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrSignature
+	}
+	msg := r.bytes(k.ID)
+	switch {
+	case k.secret != nil:
+		if !hmac.Equal(sig, hmacSHA256(k.secret, msg)) {
+			return ErrSignature
+		}
+	case k.publicKey != nil:
+		if !ed25519.Verify(k.publicKey, msg, sig) {
+			return ErrSignature
+		}
+	default:
+		return ErrSignature
+	}
+	return nil
+}
+
+// SigningKeySet holds keys which are valid to verify signatures
+type SigningKeySet struct {
+	mu   sync.RWMutex
+	keys map[string]*SigningKey
+}
+
+func NewSigningKeySet(keys ...*SigningKey) *SigningKeySet {
+	s := &SigningKeySet{keys: make(map[string]*SigningKey, len(keys))}
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+	return s
+}
+
+// Add adds or replaces the key with the same id
+func (s *SigningKeySet) Add(k *SigningKey) {
+	s.mu.Lock()
+	s.keys[k.ID] = k
+	s.mu.Unlock()
+}
+
+// Remove revokes key, signatures made by it will no longer be valid
+func (s *SigningKeySet) Remove(keyID string) {
+	s.mu.Lock()
+	delete(s.keys, keyID)
+	s.mu.Unlock()
+}
+
+func (s *SigningKeySet) Get(keyID string) *SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[keyID]
+}
+
+// KeyIDs returns sorted ids of all keys
+func (s *SigningKeySet) KeyIDs() []string {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// Verify verifies signature with the key identified by keyID
+func (s *SigningKeySet) Verify(keyID string, r *CanonicalRequest, signature string) error {
+	k := s.Get(keyID)
+	if k == nil {
+		return fmt.Errorf("signing key not found: %s", keyID)
+	}
+	return k.Verify(r, signature)
+}
+
+func hmacSHA256(key, msg []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(msg)
+	return m.Sum(nil)
+}
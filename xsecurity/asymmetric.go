@@ -2,6 +2,7 @@ package xsecurity
 
 import (
 	"bytes"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
@@ -27,6 +28,7 @@ const (
 	EcdsaP256
 	EcdsaP384
 	EcdsaP521
+	X25519
 )
 
 func (a AsymmetricAlgorithm) String() string {
@@ -45,21 +47,23 @@ func (a AsymmetricAlgorithm) String() string {
 		return "EcdsaP384"
 	case EcdsaP521:
 		return "EcdsaP521"
+	case X25519:
+		return "X25519"
 	default:
 		return fmt.Sprint(int(a))
 	}
 }
 
 type PrivateKeySet interface {
-	*rsa.PrivateKey | *ed25519.PrivateKey | *ecdsa.PrivateKey
+	*rsa.PrivateKey | *ed25519.PrivateKey | *ecdsa.PrivateKey | *ecdh.PrivateKey
 }
 
 type PublicKeyTypeSet interface {
-	*rsa.PublicKey | *ed25519.PublicKey | *ecdsa.PublicKey
+	*rsa.PublicKey | *ed25519.PublicKey | *ecdsa.PublicKey | *ecdh.PublicKey
 }
 
 // GeneratePrivateKey generates private key with the type
-// Return type would be *rsa.PrivateKey, *ed25519.PrivateKey or *ecdsa.PrivateKey
+// Return type would be *rsa.PrivateKey, *ed25519.PrivateKey, *ecdsa.PrivateKey or *ecdh.PrivateKey
 func GeneratePrivateKey(a AsymmetricAlgorithm) (any, error) {
 	switch a {
 	case RSA2048:
@@ -77,18 +81,22 @@ func GeneratePrivateKey(a AsymmetricAlgorithm) (any, error) {
 		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 	case EcdsaP521:
 		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case X25519:
+		return ecdh.X25519().GenerateKey(rand.Reader)
 	default:
 		return nil, fmt.Errorf("invalid private key type: %d", int(a))
 	}
 }
 
-// GetPublicKey get public key of private key of types: *rsa.PrivateKey, *ed25519.PrivateKey or *ecdsa.PrivateKey
+// GetPublicKey get public key of private key of types: *rsa.PrivateKey, *ed25519.PrivateKey, *ecdsa.PrivateKey or *ecdh.PrivateKey
 func GetPublicKey(priv any) any {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
 		return &k.PublicKey
 	case *ecdsa.PrivateKey:
 		return &k.PublicKey
+	case *ecdh.PrivateKey:
+		return k.PublicKey()
 	case ed25519.PrivateKey:
 		return k.Public().(ed25519.PublicKey)
 	default:
@@ -0,0 +1,347 @@
+package xsecurity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+)
+
+// CertificateAuthority issues certificates and CRLs signed by its private key
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	PrivateKey  crypto.Signer
+
+	// chain contains DER of the CA certificate and its intermediate issuers, root is excluded
+	chain [][]byte
+}
+
+type CAOptions struct {
+	Algorithm    AsymmetricAlgorithm
+	CommonName   string
+	Organization string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	// MaxPathLen is the max number of intermediate CAs under this CA, negative value means unlimited
+	MaxPathLen int
+}
+
+type IssueOptions struct {
+	Algorithm    AsymmetricAlgorithm
+	CommonName   string
+	Organization string
+	// Hosts are DNS names or IP addresses in SANs
+	Hosts          []string
+	EmailAddresses []string
+	URIs           []*url.URL
+	NotBefore      time.Time
+	NotAfter       time.Time
+	ExtKeyUsage    []x509.ExtKeyUsage
+}
+
+// NewRootCA creates a self-signed root CA
+func NewRootCA(optFns ...func(options *CAOptions)) (*CertificateAuthority, error) {
+	options := newCAOptions("Root CA", 10, optFns)
+	priv, err := generateSigner(options.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newCATemplate(options)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+	}
+	return &CertificateAuthority{Certificate: cert, PrivateKey: priv}, nil
+}
+
+// LoadCertificateAuthority loads CA from PEM encoded certificate chain and private key
+// The first certificate is the CA certificate, the following ones are its issuers
+func LoadCertificateAuthority(certPEM, keyPEM []byte) (*CertificateAuthority, error) {
+	certs, err := parseCertificatesPEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	if !certs[0].IsCA {
+		return nil, errors.New("not a CA certificate")
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKCS8PrivateKey: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key: %T", key)
+	}
+	ca := &CertificateAuthority{Certificate: certs[0], PrivateKey: signer}
+	for _, c := range certs {
+		if !bytes.Equal(c.RawSubject, c.RawIssuer) {
+			ca.chain = append(ca.chain, c.Raw)
+		}
+	}
+	return ca, nil
+}
+
+// NewIntermediateCA creates a CA whose certificate is issued by ca
+func (ca *CertificateAuthority) NewIntermediateCA(optFns ...func(options *CAOptions)) (*CertificateAuthority, error) {
+	options := newCAOptions("Intermediate CA", 5, optFns)
+	if options.NotAfter.After(ca.Certificate.NotAfter) {
+		options.NotAfter = ca.Certificate.NotAfter
+	}
+	priv, err := generateSigner(options.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newCATemplate(options)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, priv.Public(), ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+	}
+	return &CertificateAuthority{
+		Certificate: cert,
+		PrivateKey:  priv,
+		chain:       append([][]byte{der}, ca.chain...),
+	}, nil
+}
+
+// IssueServerCertificate issues certificate for TLS servers, Hosts must be set for SANs
+func (ca *CertificateAuthority) IssueServerCertificate(optFns ...func(options *IssueOptions)) (*tls.Certificate, error) {
+	return ca.IssueCertificate(append([]func(options *IssueOptions){func(options *IssueOptions) {
+		options.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}}, optFns...)...)
+}
+
+// IssueClientCertificate issues certificate for TLS clients, CommonName usually identifies the client
+func (ca *CertificateAuthority) IssueClientCertificate(optFns ...func(options *IssueOptions)) (*tls.Certificate, error) {
+	return ca.IssueCertificate(append([]func(options *IssueOptions){func(options *IssueOptions) {
+		options.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}}, optFns...)...)
+}
+
+// IssueCertificate issues leaf certificate. Returned certificate contains intermediate CAs, and can be used in tls.Config directly
+func (ca *CertificateAuthority) IssueCertificate(optFns ...func(options *IssueOptions)) (*tls.Certificate, error) {
+	options := &IssueOptions{
+		Algorithm:    EcdsaP256,
+		Organization: ca.organization(),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, fn := range optFns {
+		fn(options)
+	}
+	if options.NotAfter.After(ca.Certificate.NotAfter) {
+		options.NotAfter = ca.Certificate.NotAfter
+	}
+
+	priv, err := generateSigner(options.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   options.CommonName,
+			Organization: []string{options.Organization},
+		},
+		NotBefore:             options.NotBefore,
+		NotAfter:              options.NotAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           options.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		EmailAddresses:        options.EmailAddresses,
+		URIs:                  options.URIs,
+	}
+	if _, isRSA := priv.(*rsa.PrivateKey); isRSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, host := range options.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, priv.Public(), ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+	}
+	return &tls.Certificate{
+		Certificate: append([][]byte{der}, ca.chain...),
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
+}
+
+// NewCRL creates DER encoded certificate revocation list. number must increase for each new CRL of the CA
+func (ca *CertificateAuthority) NewCRL(number int64, revoked []x509.RevocationListEntry, nextUpdate time.Time) ([]byte, error) {
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: revoked,
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.Certificate, ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateRevocationList: %w", err)
+	}
+	return der, nil
+}
+
+// CertificatePEM returns PEM encoded CA certificate followed by its intermediate issuers
+func (ca *CertificateAuthority) CertificatePEM() []byte {
+	if len(ca.chain) == 0 {
+		return encodeCertificatesPEM([][]byte{ca.Certificate.Raw})
+	}
+	return encodeCertificatesPEM(ca.chain)
+}
+
+func (ca *CertificateAuthority) PrivateKeyPEM() ([]byte, error) {
+	return encodePrivateKeyPEM(ca.PrivateKey)
+}
+
+func (ca *CertificateAuthority) organization() string {
+	if len(ca.Certificate.Subject.Organization) > 0 {
+		return ca.Certificate.Subject.Organization[0]
+	}
+	return ""
+}
+
+// EncodeCertificatePEM encodes certificate chain and private key, which can be loaded by tls.X509KeyPair or CertificateReloader
+func EncodeCertificatePEM(cert *tls.Certificate) (certPEM, keyPEM []byte, err error) {
+	keyPEM, err = encodePrivateKeyPEM(cert.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCertificatesPEM(cert.Certificate), keyPEM, nil
+}
+
+func newCAOptions(commonName string, years int, optFns []func(options *CAOptions)) *CAOptions {
+	options := &CAOptions{
+		Algorithm:    EcdsaP256,
+		CommonName:   commonName,
+		Organization: "olapie",
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().AddDate(years, 0, 0),
+		MaxPathLen:   -1,
+	}
+	for _, fn := range optFns {
+		fn(options)
+	}
+	return options
+}
+
+func newCATemplate(options *CAOptions) (*x509.Certificate, error) {
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   options.CommonName,
+			Organization: []string{options.Organization},
+		},
+		NotBefore:             options.NotBefore,
+		NotAfter:              options.NotAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            max(options.MaxPathLen, -1),
+		MaxPathLenZero:        options.MaxPathLen == 0,
+	}, nil
+}
+
+func generateSigner(a AsymmetricAlgorithm) (crypto.Signer, error) {
+	priv, err := GeneratePrivateKey(a)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("cannot sign certificates with %v", a)
+	}
+	return signer, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serialNumber, nil
+}
+
+func encodeCertificatesPEM(ders [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, der := range ders {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return buf.Bytes()
+}
+
+func encodePrivateKeyPEM(priv any) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("x509.MarshalPKCS8PrivateKey: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseCertificatesPEM(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+		}
+		certs = append(certs, c)
+	}
+}
@@ -0,0 +1,157 @@
+package xsecurity
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.olapie.com/x/xtest"
+)
+
+func tlsHandshake(t *testing.T, serverConfig, clientConfig *tls.Config) (serverErr, clientErr error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	xtest.NoError(t, err)
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		server := tls.Server(conn, serverConfig)
+		if err = server.Handshake(); err == nil {
+			_, err = server.Read(make([]byte, 4))
+		}
+		done <- err
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	xtest.NoError(t, err)
+	client := tls.Client(conn, clientConfig)
+	if clientErr = client.Handshake(); clientErr == nil {
+		// client handshake finishes before server verifies client certificate in TLS 1.3
+		_, clientErr = client.Write([]byte("ping"))
+	}
+	serverErr = <-done
+	_ = conn.Close()
+	return serverErr, clientErr
+}
+
+func TestCertificateAuthority(t *testing.T) {
+	root, err := NewRootCA()
+	xtest.NoError(t, err)
+	intermediate, err := root.NewIntermediateCA(func(options *CAOptions) {
+		options.MaxPathLen = 0
+	})
+	xtest.NoError(t, err)
+	xtest.True(t, intermediate.Certificate.MaxPathLenZero)
+
+	serverCert, err := intermediate.IssueServerCertificate(func(options *IssueOptions) {
+		options.Hosts = []string{"svc.local", "127.0.0.1"}
+	})
+	xtest.NoError(t, err)
+	xtest.Equal(t, []string{"svc.local"}, serverCert.Leaf.DNSNames)
+	xtest.Equal(t, 2, len(serverCert.Certificate))
+	clientCert, err := intermediate.IssueClientCertificate(func(options *IssueOptions) {
+		options.CommonName = "client"
+	})
+	xtest.NoError(t, err)
+
+	serverConfig, err := NewServerTLSConfig(root.CertificatePEM(), func(options *TLSOptions) {
+		options.Certificates = []tls.Certificate{*serverCert}
+	})
+	xtest.NoError(t, err)
+	clientConfig, err := NewClientTLSConfig(root.CertificatePEM(), func(options *TLSOptions) {
+		options.Certificates = []tls.Certificate{*clientCert}
+		options.ServerName = "svc.local"
+	})
+	xtest.NoError(t, err)
+	serverErr, clientErr := tlsHandshake(t, serverConfig, clientConfig)
+	xtest.NoError(t, serverErr)
+	xtest.NoError(t, clientErr)
+
+	// client certificate cannot be used as server certificate
+	serverConfig.Certificates = []tls.Certificate{*clientCert}
+	_, clientErr = tlsHandshake(t, serverConfig, clientConfig)
+	xtest.Error(t, clientErr)
+	serverConfig.Certificates = []tls.Certificate{*serverCert}
+
+	crl, err := intermediate.NewCRL(1, []x509.RevocationListEntry{{
+		SerialNumber:   clientCert.Leaf.SerialNumber,
+		RevocationTime: time.Now(),
+	}}, time.Now().AddDate(0, 0, 7))
+	xtest.NoError(t, err)
+	serverConfig, err = NewServerTLSConfig(root.CertificatePEM(), func(options *TLSOptions) {
+		options.Certificates = []tls.Certificate{*serverCert}
+		options.CRLs = [][]byte{crl}
+	})
+	xtest.NoError(t, err)
+	serverErr, _ = tlsHandshake(t, serverConfig, clientConfig)
+	xtest.Error(t, serverErr)
+}
+
+func TestLoadCertificateAuthority(t *testing.T) {
+	root, err := NewRootCA()
+	xtest.NoError(t, err)
+	intermediate, err := root.NewIntermediateCA()
+	xtest.NoError(t, err)
+	keyPEM, err := intermediate.PrivateKeyPEM()
+	xtest.NoError(t, err)
+
+	loaded, err := LoadCertificateAuthority(intermediate.CertificatePEM(), keyPEM)
+	xtest.NoError(t, err)
+	cert, err := loaded.IssueServerCertificate(func(options *IssueOptions) {
+		options.Hosts = []string{"localhost"}
+	})
+	xtest.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Certificate)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate.Certificate)
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{
+		DNSName:       "localhost",
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	xtest.NoError(t, err)
+}
+
+func TestCertificateReloader(t *testing.T) {
+	root, err := NewRootCA()
+	xtest.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	issue := func(modTime time.Time) *tls.Certificate {
+		cert, err := root.IssueServerCertificate()
+		xtest.NoError(t, err)
+		certPEM, keyPEM, err := EncodeCertificatePEM(cert)
+		xtest.NoError(t, err)
+		xtest.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+		xtest.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+		xtest.NoError(t, os.Chtimes(certFile, modTime, modTime))
+		xtest.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+		return cert
+	}
+
+	cert1 := issue(time.Now().Add(-time.Hour))
+	r, err := NewCertificateReloader(certFile, keyFile)
+	xtest.NoError(t, err)
+	xtest.Equal(t, cert1.Certificate[0], r.Certificate().Certificate[0])
+
+	cert2 := issue(time.Now())
+	xtest.Equal(t, cert1.Certificate[0], r.Certificate().Certificate[0])
+	r.CheckInterval = 0
+	got, err := r.GetCertificate(nil)
+	xtest.NoError(t, err)
+	xtest.Equal(t, cert2.Certificate[0], got.Certificate[0])
+
+	// broken files are ignored
+	xtest.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	xtest.Equal(t, cert2.Certificate[0], r.Certificate().Certificate[0])
+}
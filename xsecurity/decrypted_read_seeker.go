@@ -0,0 +1,277 @@
+package xsecurity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+)
+
+var (
+	_ io.ReadSeeker = (*DecryptedReadSeeker)(nil)
+	_ io.ReaderAt   = (*DecryptedReadSeeker)(nil)
+)
+
+// DecryptedReadSeeker provides random access to plaintext of encrypted data
+// Both MagicNumberV1 and MagicNumberV2 formats are supported.
+// ReadAt is safe for concurrent use, Read and Seek are not
+type DecryptedReadSeeker struct {
+	r      plainReaderAt
+	size   int64
+	offset int64
+}
+
+type plainReaderAt interface {
+	// readAt reads plaintext at off, p doesn't go beyond plaintext size
+	readAt(p []byte, off int64) error
+}
+
+// NewDecryptedReadSeeker creates DecryptedReadSeeker over rs
+// Encrypted data must not be modified while it's in use
+func NewDecryptedReadSeeker(rs io.ReadSeeker, password string) (*DecryptedReadSeeker, error) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+	if ra, ok := rs.(io.ReaderAt); ok {
+		return NewDecryptedReaderAt(ra, size, password)
+	}
+	return NewDecryptedReaderAt(&seekerReaderAt{rs: rs}, size, password)
+}
+
+// NewDecryptedReaderAt creates DecryptedReadSeeker over r whose size is the size of encrypted data
+func NewDecryptedReaderAt(r io.ReaderAt, size int64, password string) (*DecryptedReadSeeker, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	var magic [MagicNumberSize]byte
+	if _, err := io.ReadFull(sr, magic[:]); err != nil {
+		return nil, headerError(err)
+	}
+
+	switch string(magic[:]) {
+	case MagicNumberV1:
+		var header [HeaderSize]byte
+		copy(header[:], magic[:])
+		if _, err := io.ReadFull(sr, header[MagicNumberSize:]); err != nil {
+			return nil, headerError(err)
+		}
+		if !getCipherStream(password).ValidatePassword(header[:]) {
+			return nil, ErrKey
+		}
+		return &DecryptedReadSeeker{
+			r:    newV1ReaderAt(r, password),
+			size: size - int64(HeaderSize),
+		}, nil
+	case MagicNumberV2:
+		h, err := readHeaderV2(sr)
+		if err != nil {
+			return nil, err
+		}
+		key, err := h.passwordKey(password)
+		if err != nil {
+			return nil, err
+		}
+		return newV2ReadSeeker(r, size, h, key)
+	default:
+		return nil, ErrKey
+	}
+}
+
+func newV2ReadSeeker(r io.ReaderAt, size int64, h *headerV2, key []byte) (*DecryptedReadSeeker, error) {
+	aead, err := newAEAD(h.cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	plainSize, err := plainSizeV2(size - int64(h.size()))
+	if err != nil {
+		return nil, err
+	}
+	ra := &v2ReaderAt{
+		r:           r,
+		aead:        aead,
+		noncePrefix: h.noncePrefix,
+		ad:          h.additionalData(),
+		dataOffset:  int64(h.size()),
+		lastChunk:   plainSize / ChunkSize,
+		chunkIndex:  -1,
+	}
+	// the empty last chunk is never read, so verify it in advance
+	if plainSize%ChunkSize == 0 {
+		if err = ra.loadChunk(ra.lastChunk); err != nil {
+			return nil, err
+		}
+	}
+	return &DecryptedReadSeeker{
+		r:    ra,
+		size: plainSize,
+	}, nil
+}
+
+// Size returns plaintext size
+func (r *DecryptedReadSeeker) Size() int64 {
+	return r.size
+}
+
+func (r *DecryptedReadSeeker) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), r.size-off))
+	if err := r.r.readAt(p[:n], off); err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *DecryptedReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	n, err := r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *DecryptedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// v1ReaderAt decrypts MagicNumberV1 data by moving AES-CTR counter to the offset
+type v1ReaderAt struct {
+	r     io.ReaderAt
+	block cipher.Block
+	iv    []byte
+}
+
+func newV1ReaderAt(r io.ReaderAt, password string) *v1ReaderAt {
+	key := DeriveKey(password, nil)
+	block, err := aes.NewCipher(key[:KeySize/2])
+	if err != nil {
+		panic(err)
+	}
+	return &v1ReaderAt{
+		r:     r,
+		block: block,
+		iv:    key[KeySize/2:],
+	}
+}
+
+func (r *v1ReaderAt) readAt(p []byte, off int64) error {
+	if _, err := r.r.ReadAt(p, int64(HeaderSize)+off); err != nil && err != io.EOF {
+		return err
+	}
+
+	// counter is a 128-bit big endian integer starting from iv
+	var iv [aes.BlockSize]byte
+	hi := binary.BigEndian.Uint64(r.iv[:8])
+	lo, carry := bits.Add64(binary.BigEndian.Uint64(r.iv[8:]), uint64(off/aes.BlockSize), 0)
+	binary.BigEndian.PutUint64(iv[:8], hi+carry)
+	binary.BigEndian.PutUint64(iv[8:], lo)
+	stream := cipher.NewCTR(r.block, iv[:])
+	var skip [aes.BlockSize]byte
+	stream.XORKeyStream(skip[:off%aes.BlockSize], skip[:off%aes.BlockSize])
+	stream.XORKeyStream(p, p)
+	return nil
+}
+
+// v2ReaderAt decrypts MagicNumberV2 data chunk by chunk, the latest chunk is cached for sequential reading
+type v2ReaderAt struct {
+	r           io.ReaderAt
+	aead        cipher.AEAD
+	noncePrefix [noncePrefixSize]byte
+	ad          []byte
+	dataOffset  int64
+	lastChunk   int64
+
+	mu         sync.Mutex
+	chunkIndex int64
+	chunk      []byte
+	buf        []byte
+}
+
+func (r *v2ReaderAt) readAt(p []byte, off int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(p) > 0 {
+		index := off / ChunkSize
+		if err := r.loadChunk(index); err != nil {
+			return err
+		}
+		n := copy(p, r.chunk[off%ChunkSize:])
+		if n == 0 {
+			return ErrTruncated
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+	return nil
+}
+
+func (r *v2ReaderAt) loadChunk(index int64) error {
+	if index == r.chunkIndex {
+		return nil
+	}
+	if r.buf == nil {
+		r.buf = make([]byte, encryptedChunkSize)
+	}
+	r.chunkIndex = -1
+	n, err := r.r.ReadAt(r.buf, r.dataOffset+index*encryptedChunkSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	last := index == r.lastChunk
+	if (!last && n != encryptedChunkSize) || n < tagSize {
+		return ErrTruncated
+	}
+	nonce := chunkNonce(r.noncePrefix, uint32(index), last)
+	r.chunk, err = r.aead.Open(r.buf[:0], nonce, r.buf[:n], r.ad)
+	if err != nil {
+		return ErrAuth
+	}
+	r.chunkIndex = index
+	return nil
+}
+
+// seekerReaderAt adapts io.ReadSeeker to io.ReaderAt
+type seekerReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (r *seekerReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,104 @@
+package xsecurity
+
+import (
+	"bytes"
+	"io"
+	mathRand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.olapie.com/x/xtest"
+)
+
+// readSeeker hides io.ReaderAt of bytes.Reader
+type readSeeker struct {
+	io.ReadSeeker
+}
+
+func TestDecryptedReadSeeker(t *testing.T) {
+	for _, size := range []int{0, 1, 100, ChunkSize, 3*ChunkSize + 100} {
+		raw := xtest.RandomBytes(size)
+		enc, err := Encrypt(raw, "123")
+		xtest.NoError(t, err)
+		testDecryptedReadSeeker(t, raw, enc)
+		testDecryptedReadSeeker(t, raw, encryptV1(raw, "123"))
+	}
+}
+
+func testDecryptedReadSeeker(t *testing.T, raw, enc []byte) {
+	for _, rs := range []io.ReadSeeker{bytes.NewReader(enc), readSeeker{bytes.NewReader(enc)}} {
+		r, err := NewDecryptedReadSeeker(rs, "123")
+		xtest.NoError(t, err)
+		xtest.Equal(t, int64(len(raw)), r.Size())
+
+		dec, err := io.ReadAll(r)
+		xtest.NoError(t, err)
+		xtest.True(t, bytes.Equal(raw, dec))
+
+		for i := 0; i < 20 && len(raw) > 0; i++ {
+			off := mathRand.Intn(len(raw))
+			n := mathRand.Intn(2*ChunkSize + 1)
+			p := make([]byte, n)
+			m, err := r.ReadAt(p, int64(off))
+			if off+n > len(raw) {
+				xtest.Equal(t, io.EOF, err)
+			} else {
+				xtest.NoError(t, err)
+			}
+			xtest.True(t, bytes.Equal(raw[off:off+m], p[:m]))
+
+			pos, err := r.Seek(int64(off), io.SeekStart)
+			xtest.NoError(t, err)
+			xtest.Equal(t, int64(off), pos)
+			m, err = io.ReadFull(r, p[:min(n, len(raw)-off)])
+			xtest.NoError(t, err)
+			xtest.True(t, bytes.Equal(raw[off:off+m], p[:m]))
+		}
+	}
+
+	_, err := NewDecryptedReadSeeker(bytes.NewReader(enc), "1234")
+	xtest.Equal(t, ErrKey, err)
+}
+
+func TestDecryptedReadSeeker_Tampered(t *testing.T) {
+	raw := xtest.RandomBytes(2*ChunkSize + 10)
+	enc, err := Encrypt(raw, "123")
+	xtest.NoError(t, err)
+	tampered := bytes.Clone(enc)
+	tampered[len(enc)-ChunkSize] ^= 1
+	r, err := NewDecryptedReadSeeker(bytes.NewReader(tampered), "123")
+	xtest.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 10), 0)
+	xtest.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 10), ChunkSize+10)
+	xtest.Equal(t, ErrAuth, err)
+
+	// drop the last chunk
+	_, err = NewDecryptedReadSeeker(bytes.NewReader(enc[:len(enc)-tagSize-10]), "123")
+	xtest.Equal(t, ErrTruncated, err)
+	r, err = NewDecryptedReadSeeker(bytes.NewReader(enc[:len(enc)-5]), "123")
+	xtest.NoError(t, err)
+	_, err = r.ReadAt(make([]byte, 10), ChunkSize+10)
+	xtest.NoError(t, err)
+	_, err = io.ReadAll(r)
+	xtest.Equal(t, ErrAuth, err)
+}
+
+func TestDecryptedReadSeeker_ServeContent(t *testing.T) {
+	raw := xtest.RandomBytes(ChunkSize + 1000)
+	enc, err := Encrypt(raw, "123")
+	xtest.NoError(t, err)
+	r, err := NewDecryptedReadSeeker(bytes.NewReader(enc), "123")
+	xtest.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set("Range", "bytes=65000-66000")
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, "video", time.Time{}, r)
+	xtest.Equal(t, http.StatusPartialContent, rec.Code)
+	xtest.True(t, strings.HasPrefix(rec.Header().Get("Content-Range"), "bytes 65000-66000/"))
+	xtest.Equal(t, raw[65000:66001], rec.Body.Bytes())
+}
@@ -1,42 +1,83 @@
 package xsecurity
 
 import (
+	"crypto/cipher"
 	"io"
 )
 
 var _ io.Reader = (*DecryptedReader)(nil)
 
 // DecryptedReader reads and decrypt data from original reader
+// Both MagicNumberV1 and MagicNumberV2 formats are supported
 type DecryptedReader struct {
-	r          io.Reader
-	stream     *cipherStream
-	readHeader bool
+	r        io.Reader
+	password string
+	// headerKey returns key of MagicNumberV2 data
+	headerKey func(h *headerV2) ([]byte, error)
+	plain     io.Reader
 }
 
 func NewDecryptedReader(r io.Reader, password string) *DecryptedReader {
 	return &DecryptedReader{
-		r:      r,
-		stream: getCipherStream(password),
+		r:        r,
+		password: password,
+		headerKey: func(h *headerV2) ([]byte, error) {
+			return h.passwordKey(password)
+		},
 	}
 }
 
 func (r *DecryptedReader) Read(p []byte) (n int, err error) {
-	if !r.readHeader {
-		r.readHeader = true
-		var header [HeaderSize]byte
-		_, err = r.r.Read(header[:])
+	if r.plain == nil {
+		r.plain, err = r.readHeader()
 		if err != nil {
+			r.plain = errReader{err: err}
 			return 0, err
 		}
-		if !r.stream.ValidatePassword(header[:]) {
-			return 0, ErrKey
-		}
 	}
+	return r.plain.Read(p)
+}
 
-	n, err = r.r.Read(p)
-	if err != nil {
-		return n, err
+func (r *DecryptedReader) readHeader() (io.Reader, error) {
+	var magic [MagicNumberSize]byte
+	if _, err := io.ReadFull(r.r, magic[:]); err != nil {
+		return nil, headerError(err)
 	}
-	r.stream.XORKeyStream(p, p)
-	return n, nil
+
+	switch string(magic[:]) {
+	case MagicNumberV1:
+		if r.password == "" {
+			return nil, ErrKey
+		}
+		var header [HeaderSize]byte
+		copy(header[:], magic[:])
+		if _, err := io.ReadFull(r.r, header[MagicNumberSize:]); err != nil {
+			return nil, headerError(err)
+		}
+		stream := getCipherStream(r.password)
+		if !stream.ValidatePassword(header[:]) {
+			return nil, ErrKey
+		}
+		return &cipher.StreamReader{S: stream, R: r.r}, nil
+	case MagicNumberV2:
+		h, err := readHeaderV2(r.r)
+		if err != nil {
+			return nil, err
+		}
+		key, err := r.headerKey(h)
+		if err != nil {
+			return nil, err
+		}
+		return newChunkOpener(r.r, h, key)
+	default:
+		return nil, ErrKey
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
 }
@@ -2,51 +2,147 @@ package xsecurity
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"fmt"
 	"io"
 )
 
-var _ io.Writer = (*DecryptedWriter)(nil)
+var _ io.WriteCloser = (*DecryptedWriter)(nil)
 
 // DecryptedWriter decrypts and writes data into original WrapResponseWriter
+// Both MagicNumberV1 and MagicNumberV2 formats are supported.
+// Close must be called to verify and write the last chunk of MagicNumberV2 data
 type DecryptedWriter struct {
-	w               io.Writer
-	stream          *cipherStream
-	buf             bytes.Buffer
-	headerDecrypted bool
+	w        io.Writer
+	password string
+	buf      bytes.Buffer
+
+	// stream is used by MagicNumberV1
+	stream *cipherStream
+	// aead, nonces and ad are used by MagicNumberV2
+	aead   cipher.AEAD
+	nonces *nonceSequence
+	ad     []byte
+	err    error
 }
 
 func NewDecryptedWriter(w io.Writer, password string) *DecryptedWriter {
 	return &DecryptedWriter{
-		w:      w,
-		stream: getCipherStream(password),
+		w:        w,
+		password: password,
 	}
 }
 
 func (w *DecryptedWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
 	n, err = w.buf.Write(p)
 	if err != nil {
 		return 0, err
 	}
 
-	if !w.headerDecrypted {
-		if w.buf.Len() < HeaderSize {
+	if w.stream == nil && w.aead == nil {
+		ok, err := w.readHeader()
+		if err != nil {
+			w.err = err
+			return 0, err
+		}
+		if !ok {
 			return len(p), nil
 		}
+	}
 
-		w.headerDecrypted = true
-		header := w.buf.Next(HeaderSize)
-		if !w.stream.ValidatePassword(header) {
-			return 0, ErrKey
+	if w.stream != nil {
+		offset := n - w.buf.Len()
+		w.stream.XORKeyStream(w.buf.Bytes(), w.buf.Bytes())
+		n, err = w.w.Write(w.buf.Bytes())
+		w.buf.Reset()
+		if err != nil {
+			w.err = fmt.Errorf("cannot write: %w", err)
+			return n + offset, w.err
 		}
+		return len(p), nil
 	}
 
-	offset := n - w.buf.Len()
-	w.stream.XORKeyStream(w.buf.Bytes(), w.buf.Bytes())
-	n, err = w.w.Write(w.buf.Bytes())
-	w.buf.Reset()
-	if err != nil {
-		return n + offset, fmt.Errorf("cannot write: %w", err)
+	// The last chunk is shorter than encryptedChunkSize, so full chunks can be opened immediately
+	for w.buf.Len() >= encryptedChunkSize {
+		if err = w.writeChunk(w.buf.Next(encryptedChunkSize), false); err != nil {
+			w.err = err
+			return 0, err
+		}
 	}
 	return len(p), nil
 }
+
+// Close verifies and writes the last chunk. It doesn't close original writer
+func (w *DecryptedWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errClosed
+
+	if w.stream != nil {
+		return nil
+	}
+
+	if w.aead == nil || w.buf.Len() < tagSize {
+		return ErrTruncated
+	}
+	return w.writeChunk(w.buf.Bytes(), true)
+}
+
+// readHeader consumes header in buf and initializes decryption. It returns false if header is incomplete
+func (w *DecryptedWriter) readHeader() (bool, error) {
+	data := w.buf.Bytes()
+	if len(data) < MagicNumberSize {
+		return false, nil
+	}
+
+	switch string(data[:MagicNumberSize]) {
+	case MagicNumberV1:
+		if len(data) < HeaderSize {
+			return false, nil
+		}
+		stream := getCipherStream(w.password)
+		if !stream.ValidatePassword(w.buf.Next(HeaderSize)) {
+			return false, ErrKey
+		}
+		w.stream = stream
+		return true, nil
+	case MagicNumberV2:
+		h, err := parseHeaderV2(data)
+		if err == ErrTruncated {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		key, err := h.passwordKey(w.password)
+		if err != nil {
+			return false, err
+		}
+		w.aead, err = newAEAD(h.cipher, key)
+		if err != nil {
+			return false, err
+		}
+		w.nonces = newNonceSequence(h.noncePrefix)
+		w.ad = h.additionalData()
+		w.buf.Next(h.size())
+		return true, nil
+	default:
+		return false, ErrKey
+	}
+}
+
+func (w *DecryptedWriter) writeChunk(chunk []byte, last bool) error {
+	plain, err := openChunk(w.aead, w.nonces, w.ad, chunk, last)
+	if err != nil {
+		return err
+	}
+	if _, err = w.w.Write(plain); err != nil {
+		return fmt.Errorf("cannot write: %w", err)
+	}
+	return nil
+}
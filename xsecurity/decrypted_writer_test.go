@@ -18,5 +18,6 @@ func TestDecryptedWriter(t *testing.T) {
 	n, err := io.Copy(w, bytes.NewReader(enc))
 	t.Log(n)
 	xtest.NoError(t, err)
+	xtest.NoError(t, w.Close())
 	xtest.Equal(t, raw, dec.Bytes())
 }
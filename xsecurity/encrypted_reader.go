@@ -1,6 +1,7 @@
 package xsecurity
 
 import (
+	"bytes"
 	"io"
 )
 
@@ -9,37 +10,52 @@ var _ io.Reader = (*EncryptedReader)(nil)
 // EncryptedReader reads and encrypts data from original reader
 type EncryptedReader struct {
 	r      io.Reader
-	stream *cipherStream
-	header []byte
+	sealer *chunkSealer
+	chunk  []byte
+	buf    bytes.Buffer
+	err    error
 }
 
-func NewEncryptedReader(r io.Reader, password string) *EncryptedReader {
+func NewEncryptedReader(r io.Reader, password string, optFns ...func(options *EncryptOptions)) *EncryptedReader {
 	reader := &EncryptedReader{
-		r:      r,
-		stream: getCipherStream(password),
+		r:     r,
+		chunk: make([]byte, ChunkSize),
 	}
-
-	reader.header = []byte(MagicNumberV1)
-	reader.header = append(reader.header, reader.stream.keyHash[:]...)
+	options := newEncryptOptions(optFns)
+	h, key, err := newPasswordHeader(password, options)
+	if err != nil {
+		reader.err = err
+		return reader
+	}
+	reader.sealer, reader.err = newChunkSealer(&reader.buf, h, key)
 	return reader
 }
 
 func (r *EncryptedReader) Read(p []byte) (int, error) {
-	offset := 0
-	data := p
-	if len(r.header) > 0 {
-		n := copy(p, r.header)
-		r.header = r.header[n:]
-		if n == len(p) {
-			return n, nil
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
 		}
-		data = p[n:]
-		offset = n
+		r.err = r.sealNext()
 	}
+	return r.buf.Read(p)
+}
 
-	n, err := r.r.Read(data)
-	if n > 0 {
-		r.stream.XORKeyStream(data[:n], data[:n])
+func (r *EncryptedReader) sealNext() error {
+	n, err := io.ReadFull(r.r, r.chunk)
+	switch err {
+	case nil:
+		_, err = r.sealer.Write(r.chunk)
+		return err
+	case io.EOF, io.ErrUnexpectedEOF:
+		if _, err = r.sealer.Write(r.chunk[:n]); err != nil {
+			return err
+		}
+		if err = r.sealer.Close(); err != nil {
+			return err
+		}
+		return io.EOF
+	default:
+		return err
 	}
-	return offset + n, err
 }
@@ -10,17 +10,19 @@ import (
 )
 
 func TestEncryptedReader(t *testing.T) {
-	raw := []byte(SHA1(time.Now().String()))
-	enc := &bytes.Buffer{}
-	{
+	for _, raw := range [][]byte{
+		[]byte(SHA1(time.Now().String())),
+		{},
+		xtest.RandomBytes(ChunkSize),
+		xtest.RandomBytes(3*ChunkSize + 7),
+	} {
+		enc := &bytes.Buffer{}
 		r := NewEncryptedReader(bytes.NewReader(raw), "123")
 		n, err := io.Copy(enc, r)
 		xtest.NoError(t, err)
 		t.Log(n)
-	}
-	{
-		data, err := Encrypt(raw, "123")
+		dec, err := Decrypt(enc.Bytes(), "123")
 		xtest.NoError(t, err)
-		xtest.Equal(t, enc.Bytes(), data)
+		xtest.True(t, bytes.Equal(raw, dec))
 	}
 }
@@ -1,47 +1,52 @@
 package xsecurity
 
 import (
-	"bytes"
-	"fmt"
 	"io"
 )
 
-var _ io.Writer = (*EncryptedWriter)(nil)
+var _ io.WriteCloser = (*EncryptedWriter)(nil)
 
 // EncryptedWriter encrypts and write data into original writer
+// Close must be called to write the last chunk, otherwise encrypted data is truncated
 type EncryptedWriter struct {
-	w             io.Writer
-	stream        *cipherStream
-	buf           bytes.Buffer
-	headerWritten bool
+	sealer *chunkSealer
+	err    error
 }
 
-func NewEncryptedWriter(w io.Writer, password string) *EncryptedWriter {
+// NewEncryptedWriter returns a writer which encrypts data into w with a key derived from password
+// Close must be called after the last Write. Data is sealed in chunks, and the last chunk is written by Close,
+// so data written without Close is truncated and fails to be decrypted with ErrTruncated
+func NewEncryptedWriter(w io.Writer, password string, optFns ...func(options *EncryptOptions)) *EncryptedWriter {
+	options := newEncryptOptions(optFns)
+	h, key, err := newPasswordHeader(password, options)
+	if err != nil {
+		return &EncryptedWriter{err: err}
+	}
+	sealer, err := newChunkSealer(w, h, key)
 	return &EncryptedWriter{
-		w:      w,
-		stream: getCipherStream(password),
+		sealer: sealer,
+		err:    err,
 	}
 }
 
 func (w *EncryptedWriter) Write(p []byte) (int, error) {
-	if !w.headerWritten {
-		_, err := w.w.Write([]byte(MagicNumberV1))
-		if err != nil {
-			return 0, err
-		}
-		_, err = w.w.Write(w.stream.keyHash[:])
-		if err != nil {
-			return 0, err
-		}
-		w.headerWritten = true
+	if w.err != nil {
+		return 0, w.err
 	}
+	n, err := w.sealer.Write(p)
+	w.err = err
+	return n, err
+}
 
-	w.buf.Write(p)
-	w.stream.XORKeyStream(w.buf.Bytes(), w.buf.Bytes())
-	n, err := w.w.Write(w.buf.Bytes())
-	w.buf.Reset()
-	if err != nil {
-		return n, fmt.Errorf("cannot write: %w", err)
+// Close writes the last chunk. It doesn't close original writer
+func (w *EncryptedWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.sealer.Close()
+	if w.err != nil {
+		return w.err
 	}
-	return n, nil
+	w.err = errClosed
+	return nil
 }
@@ -16,7 +16,19 @@ func TestEncryptedWriter(t *testing.T) {
 	n, err := io.Copy(w, bytes.NewReader(raw))
 	xtest.NoError(t, err)
 	t.Log(n)
-	data, err := Encrypt(raw, "123")
+	xtest.NoError(t, w.Close())
+	xtest.True(t, IsEncrypted(enc.Bytes()))
+	dec, err := Decrypt(enc.Bytes(), "123")
 	xtest.NoError(t, err)
-	xtest.Equal(t, enc.Bytes(), data)
+	xtest.Equal(t, raw, dec)
+}
+
+func TestEncryptedWriter_Unclosed(t *testing.T) {
+	raw := xtest.RandomBytes(ChunkSize + 10)
+	enc := &bytes.Buffer{}
+	w := NewEncryptedWriter(enc, "123")
+	_, err := w.Write(raw)
+	xtest.NoError(t, err)
+	_, err = Decrypt(enc.Bytes(), "123")
+	xtest.Equal(t, ErrTruncated, err)
 }
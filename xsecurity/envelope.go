@@ -0,0 +1,222 @@
+package xsecurity
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// KeyProvider wraps data keys with key encryption keys (KEK) for envelope encryption
+// KEK never leaves the provider, e.g. a KMS or LocalKeyProvider
+type KeyProvider interface {
+	// WrapKey wraps dataKey with the current KEK, and returns id of the KEK
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+	// UnwrapKey unwraps wrappedKey with the KEK identified by keyID
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+// keyTypeEnvelope key block: key id size | key id | wrapped key size | wrapped key
+const keyTypeEnvelope keyType = 3
+
+// NewEnvelopeEncryptedWriter encrypts data with a random data key which is wrapped by provider
+func NewEnvelopeEncryptedWriter(ctx context.Context, w io.Writer, provider KeyProvider, optFns ...func(options *EncryptOptions)) (*EncryptedWriter, error) {
+	options := newEncryptOptions(optFns)
+	dataKey := make([]byte, KeySize)
+	_, _ = rand.Read(dataKey)
+	h := &headerV2{
+		cipher:  options.Cipher,
+		keyType: keyTypeEnvelope,
+	}
+	if err := h.wrapDataKey(ctx, provider, dataKey); err != nil {
+		return nil, err
+	}
+	_, _ = rand.Read(h.noncePrefix[:])
+	sealer, err := newChunkSealer(w, h, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedWriter{sealer: sealer}, nil
+}
+
+// NewEnvelopeDecryptedReader decrypts data whose data key is unwrapped by provider
+func NewEnvelopeDecryptedReader(ctx context.Context, r io.Reader, provider KeyProvider) *DecryptedReader {
+	return &DecryptedReader{
+		r: r,
+		headerKey: func(h *headerV2) ([]byte, error) {
+			return h.unwrapDataKey(ctx, provider)
+		},
+	}
+}
+
+func EnvelopeEncrypt(ctx context.Context, raw []byte, provider KeyProvider, optFns ...func(options *EncryptOptions)) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	w, err := NewEnvelopeEncryptedWriter(ctx, buf, provider, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func EnvelopeDecrypt(ctx context.Context, data []byte, provider KeyProvider) ([]byte, error) {
+	r := NewEnvelopeDecryptedReader(ctx, bytes.NewReader(data), provider)
+	w := bytes.NewBuffer(nil)
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// EnvelopeKeyID returns id of the KEK which wraps data key of data
+func EnvelopeKeyID(data []byte) (string, error) {
+	h, err := parseHeaderV2(data)
+	if err != nil {
+		return "", err
+	}
+	keyID, _, err := h.envelopeKeyBlock()
+	return keyID, err
+}
+
+// RewrapKey unwraps data key of data and wraps it with the current KEK of provider
+// Payload is copied as it is without decryption
+func RewrapKey(ctx context.Context, data []byte, provider KeyProvider) ([]byte, error) {
+	return rewriteHeader(data, func(h *headerV2) error {
+		return h.rewrap(ctx, provider)
+	})
+}
+
+// RewrapFileKey is the file version of RewrapKey. File is replaced after its key is rewrapped
+func RewrapFileKey(ctx context.Context, filename string, provider KeyProvider) error {
+	return rewriteFileHeader(filename, func(h *headerV2) error {
+		return h.rewrap(ctx, provider)
+	})
+}
+
+// rewriteHeader updates MagicNumberV2 header with fn, and copies payload as it is
+func rewriteHeader(data []byte, fn func(h *headerV2) error) ([]byte, error) {
+	h, err := parseHeaderV2(data)
+	if err != nil {
+		return nil, err
+	}
+	payload := data[h.size():]
+	if err = fn(h); err != nil {
+		return nil, err
+	}
+	return append(h.encode(), payload...), nil
+}
+
+// rewriteFileHeader is the file version of rewriteHeader
+func rewriteFileHeader(filename string, fn func(h *headerV2) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("os.Open: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	var magic [MagicNumberSize]byte
+	if _, err = io.ReadFull(f, magic[:]); err != nil {
+		return headerError(err)
+	}
+	if string(magic[:]) != MagicNumberV2 {
+		return ErrKey
+	}
+	h, err := readHeaderV2(f)
+	if err != nil {
+		return err
+	}
+	if err = fn(h); err != nil {
+		return err
+	}
+
+	tmp := filename + ".rewriting"
+	err = writeFile(tmp, h.encode(), f)
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func writeFile(filename string, header []byte, payload io.Reader) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile: %s, %w", filename, err)
+	}
+	if _, err = f.Write(header); err == nil {
+		_, err = io.Copy(f, payload)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (h *headerV2) rewrap(ctx context.Context, provider KeyProvider) error {
+	dataKey, err := h.unwrapDataKey(ctx, provider)
+	if err != nil {
+		return err
+	}
+	return h.wrapDataKey(ctx, provider, dataKey)
+}
+
+func (h *headerV2) wrapDataKey(ctx context.Context, provider KeyProvider, dataKey []byte) error {
+	keyID, wrappedKey, err := provider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return fmt.Errorf("wrap key: %w", err)
+	}
+	if len(keyID) > math.MaxUint8 {
+		return fmt.Errorf("key id is too long: %d", len(keyID))
+	}
+	if len(wrappedKey) > math.MaxUint16-len(keyID)-3 {
+		return fmt.Errorf("wrapped key is too long: %d", len(wrappedKey))
+	}
+	b := append([]byte{byte(len(keyID))}, keyID...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(wrappedKey)))
+	h.keyBlock = append(b, wrappedKey...)
+	return nil
+}
+
+func (h *headerV2) unwrapDataKey(ctx context.Context, provider KeyProvider) ([]byte, error) {
+	keyID, wrappedKey, err := h.envelopeKeyBlock()
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := provider.UnwrapKey(ctx, keyID, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key: %w", err)
+	}
+	if len(dataKey) != KeySize {
+		return nil, ErrKey
+	}
+	return dataKey, nil
+}
+
+func (h *headerV2) envelopeKeyBlock() (keyID string, wrappedKey []byte, err error) {
+	if h.keyType != keyTypeEnvelope {
+		return "", nil, errors.New("not envelope encrypted")
+	}
+	b := h.keyBlock
+	if len(b) < 1 || len(b) < 1+int(b[0])+2 {
+		return "", nil, ErrKey
+	}
+	keyID = string(b[1 : 1+b[0]])
+	b = b[1+b[0]:]
+	if len(b) != 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil, ErrKey
+	}
+	return keyID, b[2:], nil
+}
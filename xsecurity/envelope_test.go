@@ -0,0 +1,99 @@
+package xsecurity
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.olapie.com/x/xtest"
+)
+
+func TestEnvelopeEncrypt(t *testing.T) {
+	ctx := context.Background()
+	provider := NewLocalKeyProvider()
+	oldKeyID := provider.RotateKey()
+
+	raw := xtest.RandomBytes(ChunkSize + 100)
+	enc, err := EnvelopeEncrypt(ctx, raw, provider)
+	xtest.NoError(t, err)
+	xtest.True(t, IsEncrypted(enc))
+	keyID, err := EnvelopeKeyID(enc)
+	xtest.NoError(t, err)
+	xtest.Equal(t, oldKeyID, keyID)
+
+	dec, err := EnvelopeDecrypt(ctx, enc, provider)
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, dec)
+
+	_, err = Decrypt(enc, "123")
+	xtest.Error(t, err)
+	_, err = EnvelopeDecrypt(ctx, enc, NewLocalKeyProvider())
+	xtest.Error(t, err)
+
+	newKeyID := provider.RotateKey()
+	rewrapped, err := RewrapKey(ctx, enc, provider)
+	xtest.NoError(t, err)
+	keyID, err = EnvelopeKeyID(rewrapped)
+	xtest.NoError(t, err)
+	xtest.Equal(t, newKeyID, keyID)
+	// payload is not re-encrypted
+	xtest.True(t, bytes.HasSuffix(rewrapped, enc[len(enc)-encryptedChunkSize:]))
+
+	xtest.NoError(t, provider.RemoveKey(oldKeyID))
+	_, err = EnvelopeDecrypt(ctx, enc, provider)
+	xtest.Error(t, err)
+	dec, err = EnvelopeDecrypt(ctx, rewrapped, provider)
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, dec)
+}
+
+func TestRewrapFileKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	provider := NewLocalKeyProvider()
+	provider.RotateKey()
+
+	raw := xtest.RandomBytes(100)
+	enc, err := EnvelopeEncrypt(ctx, raw, provider, func(options *EncryptOptions) {
+		options.Cipher = ChaCha20Poly1305
+	})
+	xtest.NoError(t, err)
+	filename := filepath.Join(dir, "data")
+	xtest.NoError(t, os.WriteFile(filename, enc, 0644))
+
+	keyringFile := filepath.Join(dir, "keyring")
+	newKeyID := provider.RotateKey()
+	xtest.NoError(t, provider.SaveFile(keyringFile, "123"))
+	_, err = LoadLocalKeyProvider(keyringFile, "1234")
+	xtest.Error(t, err)
+	loaded, err := LoadLocalKeyProvider(keyringFile, "123")
+	xtest.NoError(t, err)
+	xtest.Equal(t, provider.KeyIDs(), loaded.KeyIDs())
+	xtest.Equal(t, newKeyID, loaded.CurrentKeyID())
+	data, err := os.ReadFile(keyringFile)
+	xtest.NoError(t, err)
+	xtest.False(t, NeedsUpgrade(data, func(options *EncryptOptions) {
+		options.KDF = StrongKDF
+	}))
+
+	// keyring without keys
+	emptyFile := filepath.Join(dir, "empty_keyring")
+	xtest.NoError(t, NewLocalKeyProvider().SaveFile(emptyFile, "123", func(options *EncryptOptions) {
+		options.KDF = DefaultKDF
+	}))
+	empty, err := LoadLocalKeyProvider(emptyFile, "123")
+	xtest.NoError(t, err)
+	xtest.Equal(t, "", empty.CurrentKeyID())
+
+	xtest.NoError(t, RewrapFileKey(ctx, filename, loaded))
+	rewrapped, err := os.ReadFile(filename)
+	xtest.NoError(t, err)
+	keyID, err := EnvelopeKeyID(rewrapped)
+	xtest.NoError(t, err)
+	xtest.Equal(t, newKeyID, keyID)
+	dec, err := EnvelopeDecrypt(ctx, rewrapped, loaded)
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, dec)
+}
@@ -0,0 +1,199 @@
+package xsecurity
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// keyTypePublicKey key block is a recipient stanza:
+//
+//	curve | ephemeral public key size | ephemeral public key | wrapped file key
+//
+// File key is wrapped by a key derived with HKDF-SHA256 from ECDH shared secret of ephemeral key and recipient key
+const keyTypePublicKey keyType = 4
+
+const wrappedFileKeySize = KeySize + tagSize
+
+type curveID byte
+
+const (
+	_ curveID = iota
+	curveX25519
+	curveP256
+	curveP384
+	curveP521
+)
+
+var curves = map[curveID]ecdh.Curve{
+	curveX25519: ecdh.X25519(),
+	curveP256:   ecdh.P256(),
+	curveP384:   ecdh.P384(),
+	curveP521:   ecdh.P521(),
+}
+
+func getCurveID(c ecdh.Curve) (curveID, error) {
+	for id, curve := range curves {
+		if curve == c {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported curve: %v", c)
+}
+
+// SealTo encrypts data to recipient's public key which is *ecdh.PublicKey or *ecdsa.PublicKey
+// Only owner of the private key can open sealed data
+func SealTo(pub any, data []byte, optFns ...func(options *EncryptOptions)) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	w, err := NewSealedWriter(buf, pub, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Open decrypts data sealed by SealTo or SealToRecipients with private key which is *ecdh.PrivateKey or *ecdsa.PrivateKey
+func Open(priv any, sealed []byte) ([]byte, error) {
+	r := NewOpenedReader(bytes.NewReader(sealed), priv)
+	w := bytes.NewBuffer(nil)
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// NewSealedWriter is the streaming version of SealTo
+func NewSealedWriter(w io.Writer, pub any, optFns ...func(options *EncryptOptions)) (*EncryptedWriter, error) {
+	recipient, err := toECDHPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	options := newEncryptOptions(optFns)
+	fileKey := make([]byte, KeySize)
+	_, _ = rand.Read(fileKey)
+	stanza, err := wrapFileKeyTo(recipient, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	h := &headerV2{
+		cipher:   options.Cipher,
+		keyType:  keyTypePublicKey,
+		keyBlock: stanza,
+	}
+	_, _ = rand.Read(h.noncePrefix[:])
+	sealer, err := newChunkSealer(w, h, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedWriter{sealer: sealer}, nil
+}
+
+// NewOpenedReader is the streaming version of Open
+func NewOpenedReader(r io.Reader, priv any) *DecryptedReader {
+	return &DecryptedReader{
+		r: r,
+		headerKey: func(h *headerV2) ([]byte, error) {
+			if h.keyType != keyTypePublicKey && h.keyType != keyTypeRecipients {
+				return nil, ErrKey
+			}
+			identity, err := toECDHPrivateKey(priv)
+			if err != nil {
+				return nil, err
+			}
+			return h.recipientKey(identity)
+		},
+	}
+}
+
+func toECDHPublicKey(pub any) (*ecdh.PublicKey, error) {
+	switch k := pub.(type) {
+	case *ecdh.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		return k.ECDH()
+	default:
+		return nil, fmt.Errorf("unsupported public key: %T", pub)
+	}
+}
+
+func toECDHPrivateKey(priv any) (*ecdh.PrivateKey, error) {
+	switch k := priv.(type) {
+	case *ecdh.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k.ECDH()
+	default:
+		return nil, fmt.Errorf("unsupported private key: %T", priv)
+	}
+}
+
+func wrapFileKeyTo(recipient *ecdh.PublicKey, fileKey []byte) ([]byte, error) {
+	id, err := getCurveID(recipient.Curve())
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate ephemeral key: %w", err)
+	}
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newWrapAEAD(secret, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	stanza := append([]byte{byte(id), byte(len(ephemeralPub))}, ephemeralPub...)
+	return aead.Seal(stanza, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+func unwrapFileKey(identity *ecdh.PrivateKey, stanza []byte) ([]byte, error) {
+	if len(stanza) < 2 || len(stanza) != 2+int(stanza[1])+wrappedFileKeySize {
+		return nil, ErrKey
+	}
+	if curves[curveID(stanza[0])] != identity.Curve() {
+		return nil, ErrKey
+	}
+	ephemeral, err := identity.Curve().NewPublicKey(stanza[2 : 2+stanza[1]])
+	if err != nil {
+		return nil, ErrKey
+	}
+	secret, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrKey
+	}
+	aead, err := newWrapAEAD(secret, ephemeral, identity.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), stanza[2+stanza[1]:], nil)
+	if err != nil {
+		return nil, ErrKey
+	}
+	return fileKey, nil
+}
+
+// newWrapAEAD creates AEAD with key derived from ECDH shared secret
+// Wrap key is unique for each ephemeral key, so zero nonce is safe
+func newWrapAEAD(secret []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, secret, salt, "xsecurity hybrid wrap key", KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(AES256GCM, key)
+}
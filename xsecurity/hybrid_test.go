@@ -0,0 +1,72 @@
+package xsecurity
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"go.olapie.com/x/xtest"
+)
+
+func TestSealTo(t *testing.T) {
+	raw := xtest.RandomBytes(100)
+	for _, a := range []AsymmetricAlgorithm{X25519, EcdsaP256, EcdsaP384, EcdsaP521} {
+		t.Run(a.String(), func(t *testing.T) {
+			priv, err := GeneratePrivateKey(a)
+			xtest.NoError(t, err)
+
+			// recipient's public key is usually shared in encoded form
+			encoded, err := EncodePublicKey(GetPublicKey(priv))
+			xtest.NoError(t, err)
+			pub, err := DecodePublicKey(encoded)
+			xtest.NoError(t, err)
+
+			sealed, err := SealTo(pub, raw)
+			xtest.NoError(t, err)
+			xtest.True(t, IsEncrypted(sealed))
+			opened, err := Open(priv, sealed)
+			xtest.NoError(t, err)
+			xtest.Equal(t, raw, opened)
+
+			other, err := GeneratePrivateKey(a)
+			xtest.NoError(t, err)
+			_, err = Open(other, sealed)
+			xtest.Equal(t, ErrKey, err)
+
+			sealed[len(sealed)-1] ^= 1
+			_, err = Open(priv, sealed)
+			xtest.Equal(t, ErrAuth, err)
+		})
+	}
+}
+
+func TestSealedWriter(t *testing.T) {
+	priv, err := GeneratePrivateKey(X25519)
+	xtest.NoError(t, err)
+	raw := xtest.RandomBytes(3*ChunkSize + 10)
+
+	var buf bytes.Buffer
+	w, err := NewSealedWriter(&buf, GetPublicKey(priv), func(options *EncryptOptions) {
+		options.Cipher = ChaCha20Poly1305
+	})
+	xtest.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(raw))
+	xtest.NoError(t, err)
+	xtest.NoError(t, w.Close())
+
+	_, err = Decrypt(buf.Bytes(), "123")
+	xtest.Equal(t, ErrKey, err)
+
+	opened, err := io.ReadAll(NewOpenedReader(&buf, priv))
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, opened)
+
+	_, err = SealTo(GetPublicKey(mustGenerateKey(t, Ed25519)), raw)
+	xtest.Error(t, err)
+}
+
+func mustGenerateKey(t *testing.T, a AsymmetricAlgorithm) any {
+	priv, err := GeneratePrivateKey(a)
+	xtest.NoError(t, err)
+	return priv
+}
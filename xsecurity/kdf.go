@@ -0,0 +1,186 @@
+package xsecurity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDFID identifies a key derivation function in encrypted header
+type KDFID byte
+
+const (
+	_ KDFID = iota
+	KDFArgon2id
+	KDFScrypt
+)
+
+func (id KDFID) String() string {
+	switch id {
+	case KDFArgon2id:
+		return "Argon2id"
+	case KDFScrypt:
+		return "Scrypt"
+	default:
+		return fmt.Sprint(int(id))
+	}
+}
+
+// KDF derives key from password. Its ID and Params are stored in encrypted header,
+// so data can be decrypted after default KDF or its cost is changed
+type KDF interface {
+	ID() KDFID
+	Params() []byte
+	DeriveKey(password, salt []byte, keyLen int) ([]byte, error)
+}
+
+// DefaultKDF has the same cost as the key derivation of MagicNumberV1
+// It's cheap enough to encrypt small records, e.g. rows in xsqlite tables
+var DefaultKDF KDF = &Argon2idKDF{
+	Time:    1,
+	Memory:  128,
+	Threads: 1,
+}
+
+// StrongKDF has the argon2id cost recommended by RFC 9106 when memory is constrained
+// It's used to protect long-lived secrets, e.g. keyring files of LocalKeyProvider
+var StrongKDF KDF = &Argon2idKDF{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+var (
+	kdfMu      sync.RWMutex
+	kdfDecoder = map[KDFID]func(params []byte) (KDF, error){
+		KDFArgon2id: decodeArgon2idKDF,
+		KDFScrypt:   decodeScryptKDF,
+	}
+)
+
+// RegisterKDF registers decoder which creates KDF from its params stored in encrypted header
+func RegisterKDF(id KDFID, decode func(params []byte) (KDF, error)) {
+	kdfMu.Lock()
+	defer kdfMu.Unlock()
+	if decode == nil {
+		panic("xsecurity: RegisterKDF decode is nil")
+	}
+	if _, dup := kdfDecoder[id]; dup {
+		panic(fmt.Sprintf("xsecurity: RegisterKDF called twice for %v", id))
+	}
+	kdfDecoder[id] = decode
+}
+
+func decodeKDF(id KDFID, params []byte) (KDF, error) {
+	kdfMu.RLock()
+	decode := kdfDecoder[id]
+	kdfMu.RUnlock()
+	if decode == nil {
+		return nil, fmt.Errorf("unsupported kdf: %v", id)
+	}
+	return decode(params)
+}
+
+func equalKDF(a, b KDF) bool {
+	return a.ID() == b.ID() && string(a.Params()) == string(b.Params())
+}
+
+// Argon2idKDF derives key with argon2.IDKey
+// Memory is in KiB
+type Argon2idKDF struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var _ KDF = (*Argon2idKDF)(nil)
+
+func (k *Argon2idKDF) ID() KDFID {
+	return KDFArgon2id
+}
+
+func (k *Argon2idKDF) Params() []byte {
+	b := binary.BigEndian.AppendUint32(nil, k.Time)
+	b = binary.BigEndian.AppendUint32(b, k.Memory)
+	return append(b, k.Threads)
+}
+
+func (k *Argon2idKDF) DeriveKey(password, salt []byte, keyLen int) ([]byte, error) {
+	if k.Time == 0 || k.Threads == 0 || k.Memory < 8*uint32(k.Threads) {
+		return nil, fmt.Errorf("invalid argon2id params: %+v", *k)
+	}
+	return argon2.IDKey(password, salt, k.Time, k.Memory, k.Threads, uint32(keyLen)), nil
+}
+
+// Limits of KDF params decoded from encrypted header, which stop crafted data from exhausting memory or CPU
+// They're a few times of StrongKDF, so that a header costs at most 1 GiB memory
+const (
+	maxArgon2idTime    = 8
+	maxArgon2idMemory  = 1 << 20 // 1 GiB
+	maxArgon2idThreads = 16
+	maxScryptNR        = 1 << 23 // 128*N*R bytes = 1 GiB
+	maxScryptP         = 16
+)
+
+func decodeArgon2idKDF(params []byte) (KDF, error) {
+	if len(params) != 9 {
+		return nil, fmt.Errorf("invalid argon2id params size: %d", len(params))
+	}
+	k := &Argon2idKDF{
+		Time:    binary.BigEndian.Uint32(params),
+		Memory:  binary.BigEndian.Uint32(params[4:]),
+		Threads: params[8],
+	}
+	if k.Time > maxArgon2idTime || k.Memory > maxArgon2idMemory || k.Threads > maxArgon2idThreads {
+		return nil, fmt.Errorf("argon2id params exceed limits: %+v", *k)
+	}
+	return k, nil
+}
+
+// ScryptKDF derives key with scrypt.Key, N is 1<<LogN
+type ScryptKDF struct {
+	LogN uint8
+	R    uint32
+	P    uint32
+}
+
+var _ KDF = (*ScryptKDF)(nil)
+
+func (k *ScryptKDF) ID() KDFID {
+	return KDFScrypt
+}
+
+func (k *ScryptKDF) Params() []byte {
+	b := []byte{k.LogN}
+	b = binary.BigEndian.AppendUint32(b, k.R)
+	return binary.BigEndian.AppendUint32(b, k.P)
+}
+
+func (k *ScryptKDF) DeriveKey(password, salt []byte, keyLen int) ([]byte, error) {
+	if k.LogN == 0 || k.LogN > 30 {
+		return nil, fmt.Errorf("invalid scrypt LogN: %d", k.LogN)
+	}
+	key, err := scrypt.Key(password, salt, 1<<k.LogN, int(k.R), int(k.P), keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt.Key: %w", err)
+	}
+	return key, nil
+}
+
+func decodeScryptKDF(params []byte) (KDF, error) {
+	if len(params) != 9 {
+		return nil, fmt.Errorf("invalid scrypt params size: %d", len(params))
+	}
+	k := &ScryptKDF{
+		LogN: params[0],
+		R:    binary.BigEndian.Uint32(params[1:]),
+		P:    binary.BigEndian.Uint32(params[5:]),
+	}
+	if k.LogN > 30 || uint64(1)<<k.LogN*uint64(k.R) > maxScryptNR || k.P > maxScryptP {
+		return nil, fmt.Errorf("scrypt params exceed limits: %+v", *k)
+	}
+	return k, nil
+}
@@ -0,0 +1,175 @@
+package xsecurity
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.olapie.com/x/xtest"
+)
+
+type sha256KDF struct{}
+
+func (k sha256KDF) ID() KDFID {
+	return 200
+}
+
+func (k sha256KDF) Params() []byte {
+	return nil
+}
+
+func (k sha256KDF) DeriveKey(password, salt []byte, keyLen int) ([]byte, error) {
+	sum := sha256.Sum256(append(salt, password...))
+	return sum[:keyLen], nil
+}
+
+func TestKDF(t *testing.T) {
+	RegisterKDF(sha256KDF{}.ID(), func(params []byte) (KDF, error) {
+		return sha256KDF{}, nil
+	})
+
+	raw := xtest.RandomBytes(100)
+	for _, kdf := range []KDF{
+		&Argon2idKDF{Time: 2, Memory: 1024, Threads: 2},
+		&ScryptKDF{LogN: 10, R: 8, P: 1},
+		sha256KDF{},
+	} {
+		t.Run(kdf.ID().String(), func(t *testing.T) {
+			enc, err := Encrypt(raw, "123", func(options *EncryptOptions) {
+				options.KDF = kdf
+			})
+			xtest.NoError(t, err)
+			h, err := parseHeaderV2(enc)
+			xtest.NoError(t, err)
+			decoded, _, _, err := h.passwordKDF()
+			xtest.NoError(t, err)
+			xtest.Equal(t, kdf, decoded)
+
+			xtest.True(t, NeedsUpgrade(enc))
+			xtest.False(t, NeedsUpgrade(enc, func(options *EncryptOptions) {
+				options.KDF = kdf
+			}))
+
+			// decryption doesn't depend on DefaultKDF
+			dec, err := Decrypt(enc, "123")
+			xtest.NoError(t, err)
+			xtest.Equal(t, raw, dec)
+			_, err = Decrypt(enc, "1234")
+			xtest.Equal(t, ErrKey, err)
+		})
+	}
+
+	_, err := Encrypt(raw, "123", func(options *EncryptOptions) {
+		options.KDF = &ScryptKDF{LogN: 0, R: 8, P: 1}
+	})
+	xtest.Error(t, err)
+}
+
+func TestDecodeKDF_Limits(t *testing.T) {
+	for _, kdf := range []KDF{
+		&Argon2idKDF{Time: 8, Memory: 1 << 20, Threads: 16},
+		&ScryptKDF{LogN: 20, R: 8, P: 16},
+	} {
+		decoded, err := decodeKDF(kdf.ID(), kdf.Params())
+		xtest.NoError(t, err)
+		xtest.Equal(t, kdf, decoded)
+	}
+
+	for _, kdf := range []KDF{
+		&Argon2idKDF{Time: 9, Memory: 1024, Threads: 1},
+		&Argon2idKDF{Time: 1, Memory: 1<<20 + 1, Threads: 1},
+		&Argon2idKDF{Time: 1, Memory: 1024, Threads: 17},
+		&ScryptKDF{LogN: 20, R: 9, P: 1},
+		&ScryptKDF{LogN: 10, R: 8, P: 17},
+		&ScryptKDF{LogN: 31, R: 1, P: 1},
+	} {
+		_, err := decodeKDF(kdf.ID(), kdf.Params())
+		xtest.Error(t, err)
+	}
+
+	_, err := Encrypt([]byte("a"), "123", func(options *EncryptOptions) {
+		options.KDF = &ScryptKDF{LogN: 10, R: 8, P: 17}
+	})
+	xtest.Error(t, err)
+}
+
+func TestUpgrade(t *testing.T) {
+	raw := xtest.RandomBytes(100)
+	kdf := &ScryptKDF{LogN: 10, R: 8, P: 1}
+	setKDF := func(options *EncryptOptions) {
+		options.KDF = kdf
+	}
+
+	// legacy key type written before KDF is stored in header
+	legacy := func() []byte {
+		var salt [saltSize]byte
+		_, _ = rand.Read(salt[:])
+		key := deriveKey([]byte("123"), salt[:], KeySize)
+		h := &headerV2{
+			cipher:   AES256GCM,
+			keyType:  keyTypePassword,
+			keyBlock: append(salt[:], keyCheck(key)...),
+		}
+		var buf bytes.Buffer
+		sealer, err := newChunkSealer(&buf, h, key)
+		xtest.NoError(t, err)
+		_, err = sealer.Write(raw)
+		xtest.NoError(t, err)
+		xtest.NoError(t, sealer.Close())
+		return buf.Bytes()
+	}()
+	dec, err := Decrypt(legacy, "123")
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, dec)
+
+	for _, enc := range [][]byte{encryptV1(raw, "123"), legacy} {
+		xtest.True(t, NeedsUpgrade(enc, setKDF))
+		_, err = Upgrade(enc, "1234", setKDF)
+		xtest.Equal(t, ErrKey, err)
+		upgraded, err := Upgrade(enc, "123", setKDF)
+		xtest.NoError(t, err)
+		xtest.False(t, NeedsUpgrade(upgraded, setKDF))
+		dec, err := Decrypt(upgraded, "123")
+		xtest.NoError(t, err)
+		xtest.Equal(t, raw, dec)
+
+		again, err := Upgrade(upgraded, "123", setKDF)
+		xtest.NoError(t, err)
+		xtest.Equal(t, upgraded, again)
+	}
+}
+
+func TestUpgradeFile(t *testing.T) {
+	raw := xtest.RandomBytes(ChunkSize + 100)
+	filename := filepath.Join(t.TempDir(), "data")
+	xtest.NoError(t, os.WriteFile(filename, encryptV1(raw, "123"), 0644))
+	setKDF := func(options *EncryptOptions) {
+		options.KDF = &Argon2idKDF{Time: 1, Memory: 1024, Threads: 1}
+		options.Cipher = ChaCha20Poly1305
+	}
+
+	needed, err := NeedsFileUpgrade(filename, setKDF)
+	xtest.NoError(t, err)
+	xtest.True(t, needed)
+
+	upgraded, err := UpgradeFile(filename, "123", setKDF)
+	xtest.NoError(t, err)
+	xtest.True(t, upgraded)
+
+	needed, err = NeedsFileUpgrade(filename, setKDF)
+	xtest.NoError(t, err)
+	xtest.False(t, needed)
+
+	upgraded, err = UpgradeFile(filename, "123", setKDF)
+	xtest.NoError(t, err)
+	xtest.False(t, upgraded)
+
+	enc, err := os.ReadFile(filename)
+	xtest.NoError(t, err)
+	dec, err := Decrypt(enc, "123")
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, dec)
+}
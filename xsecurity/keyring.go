@@ -0,0 +1,203 @@
+package xsecurity
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+var _ KeyProvider = (*LocalKeyProvider)(nil)
+
+// LocalKeyProvider is a KeyProvider which keeps KEKs in memory
+// KEKs can be saved into a keyring file protected by password
+type LocalKeyProvider struct {
+	mu           sync.RWMutex
+	currentKeyID string
+	keys         map[string]Key
+}
+
+type localKeyring struct {
+	CurrentKeyID string            `json:"current_key_id"`
+	Keys         map[string][]byte `json:"keys"`
+}
+
+func NewLocalKeyProvider() *LocalKeyProvider {
+	return &LocalKeyProvider{
+		keys: make(map[string]Key),
+	}
+}
+
+// LoadLocalKeyProvider loads KEKs from keyring file which is saved by SaveFile
+func LoadLocalKeyProvider(filename, password string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %s, %w", filename, err)
+	}
+	data, err = Decrypt(data, password)
+	if err != nil {
+		return nil, err
+	}
+	var keyring localKeyring
+	if err = json.Unmarshal(data, &keyring); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	p := NewLocalKeyProvider()
+	for id, k := range keyring.Keys {
+		if len(k) != KeySize {
+			return nil, fmt.Errorf("invalid key size of %s: %d", id, len(k))
+		}
+		p.keys[id] = Key(k)
+	}
+	if keyring.CurrentKeyID != "" {
+		if err = p.SetCurrentKey(keyring.CurrentKeyID); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// SaveFile saves KEKs into keyring file encrypted with password
+// Key of the file is derived by StrongKDF unless optFns sets another KDF
+func (p *LocalKeyProvider) SaveFile(filename, password string, optFns ...func(options *EncryptOptions)) error {
+	p.mu.RLock()
+	keyring := localKeyring{
+		CurrentKeyID: p.currentKeyID,
+		Keys:         make(map[string][]byte, len(p.keys)),
+	}
+	for id, k := range p.keys {
+		keyring.Keys[id] = k[:]
+	}
+	data, err := json.Marshal(keyring)
+	p.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	optFns = append([]func(options *EncryptOptions){func(options *EncryptOptions) {
+		options.KDF = StrongKDF
+	}}, optFns...)
+	data, err = Encrypt(data, password, optFns...)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// AddKey adds KEK. The first added key becomes the current key
+func (p *LocalKeyProvider) AddKey(keyID string, kek Key) {
+	p.mu.Lock()
+	p.keys[keyID] = kek
+	if p.currentKeyID == "" {
+		p.currentKeyID = keyID
+	}
+	p.mu.Unlock()
+}
+
+// RotateKey generates a new KEK and makes it the current key
+// Old keys are kept to unwrap existing data keys until they are removed
+func (p *LocalKeyProvider) RotateKey() string {
+	var kek Key
+	_, _ = rand.Read(kek[:])
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	keyID := hex.EncodeToString(id[:])
+	p.mu.Lock()
+	p.keys[keyID] = kek
+	p.currentKeyID = keyID
+	p.mu.Unlock()
+	return keyID
+}
+
+func (p *LocalKeyProvider) SetCurrentKey(keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.keys[keyID]; !ok {
+		return fmt.Errorf("key not found: %s", keyID)
+	}
+	p.currentKeyID = keyID
+	return nil
+}
+
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.currentKeyID
+}
+
+// KeyIDs returns sorted ids of all KEKs
+func (p *LocalKeyProvider) KeyIDs() []string {
+	p.mu.RLock()
+	ids := make([]string, 0, len(p.keys))
+	for id := range p.keys {
+		ids = append(ids, id)
+	}
+	p.mu.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// RemoveKey removes KEK, data keys wrapped by it can no longer be unwrapped
+func (p *LocalKeyProvider) RemoveKey(keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if keyID == p.currentKeyID {
+		return fmt.Errorf("cannot remove the current key: %s", keyID)
+	}
+	delete(p.keys, keyID)
+	return nil
+}
+
+// WrapKey seals dataKey with AES-256-GCM, wrapped key layout: nonce | sealed data key
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	p.mu.RLock()
+	keyID, kek := p.currentKeyID, p.keys[p.currentKeyID]
+	p.mu.RUnlock()
+	if keyID == "" {
+		return "", nil, fmt.Errorf("no key")
+	}
+	aead, err := newKeyWrapAEAD(kek)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	_, _ = rand.Read(nonce)
+	return keyID, aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	p.mu.RLock()
+	kek, ok := p.keys[keyID]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("key not found: %s", keyID)
+	}
+	aead, err := newKeyWrapAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, ErrKey
+	}
+	nonce, sealed := wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, ErrKey
+	}
+	return dataKey, nil
+}
+
+func newKeyWrapAEAD(kek Key) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek[:])
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
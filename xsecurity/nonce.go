@@ -0,0 +1,60 @@
+package xsecurity
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultNonceCacheSize is the default max number of unexpired nonces kept by NonceCache
+const DefaultNonceCacheSize = 100_000
+
+// NonceCache remembers nonces until they expire, so that replayed requests can be rejected
+type NonceCache struct {
+	mu        sync.Mutex
+	maxSize   int
+	nonces    map[string]time.Time
+	lastPrune time.Time
+}
+
+func NewNonceCache(maxSize int) *NonceCache {
+	if maxSize <= 0 {
+		maxSize = DefaultNonceCacheSize
+	}
+	return &NonceCache{
+		maxSize: maxSize,
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+// Use records nonce which is valid until expiresAt
+// It returns false if nonce has been used and not expired yet, or the cache is full of unexpired nonces
+func (c *NonceCache) Use(nonce string, now, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.nonces[nonce]; ok && t.After(now) {
+		return false
+	}
+	if len(c.nonces) >= c.maxSize || now.Sub(c.lastPrune) > time.Minute {
+		c.prune(now)
+		if len(c.nonces) >= c.maxSize {
+			return false
+		}
+	}
+	c.nonces[nonce] = expiresAt
+	return true
+}
+
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.nonces)
+}
+
+func (c *NonceCache) prune(now time.Time) {
+	for nonce, t := range c.nonces {
+		if !t.After(now) {
+			delete(c.nonces, nonce)
+		}
+	}
+	c.lastPrune = now
+}
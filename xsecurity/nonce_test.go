@@ -0,0 +1,23 @@
+package xsecurity
+
+import (
+	"testing"
+	"time"
+
+	"go.olapie.com/x/xtest"
+)
+
+func TestNonceCache(t *testing.T) {
+	c := NewNonceCache(2)
+	now := time.Now()
+	xtest.True(t, c.Use("a", now, now.Add(time.Minute)))
+	xtest.False(t, c.Use("a", now, now.Add(time.Minute)))
+	xtest.True(t, c.Use("b", now, now.Add(time.Second)))
+	// full of unexpired nonces
+	xtest.False(t, c.Use("c", now, now.Add(time.Minute)))
+
+	now = now.Add(2 * time.Second)
+	xtest.True(t, c.Use("c", now, now.Add(time.Minute)))
+	xtest.Equal(t, 2, c.Len())
+	xtest.False(t, c.Use("a", now, now.Add(time.Minute)))
+}
@@ -0,0 +1,643 @@
+package xsecurity
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"go.olapie.com/x/xbase62"
+)
+
+// MaxSkippedChatMessages limits message keys derived for skipped messages in one chain
+const MaxSkippedChatMessages = 1000
+
+const (
+	chatMessageVersion = 1
+	chatKeySize        = 32
+
+	// chat message layout: version | flags | [initial header] | ratchet header | ciphertext
+	// initial header: sender identity key | ephemeral key | signed pre key | one-time pre key id
+	// ratchet header: ratchet key | previous chain length | message number
+	chatFlagInitial      = 1
+	chatInitialSize      = 3*chatKeySize + 4
+	chatRatchetHeaderLen = chatKeySize + 4 + 4
+)
+
+const (
+	ErrChatSignature errorString = "invalid pre key signature"
+	ErrChatPreKey    errorString = "pre key not found"
+)
+
+// ChatIdentity is the long-term identity of chat user with its pre keys
+// It must be persisted after creating pre key bundles or accepting sessions, as one-time pre keys are consumed
+type ChatIdentity struct {
+	mu                   sync.Mutex
+	signingKey           ed25519.PrivateKey
+	identityKey          *ecdh.PrivateKey
+	signedPreKey         *ecdh.PrivateKey
+	previousSignedPreKey *ecdh.PrivateKey
+	oneTimePreKeys       map[uint32]*ecdh.PrivateKey
+	nextPreKeyID         uint32
+}
+
+// ChatPreKeyBundle is published by a user, so that others can start sessions with the user asynchronously
+type ChatPreKeyBundle struct {
+	SigningKey      ed25519.PublicKey `json:"signing_key"`
+	IdentityKey     []byte            `json:"identity_key"`
+	SignedPreKey    []byte            `json:"signed_pre_key"`
+	Signature       []byte            `json:"signature"`
+	OneTimePreKeyID uint32            `json:"one_time_pre_key_id,omitempty"`
+	OneTimePreKey   []byte            `json:"one_time_pre_key,omitempty"`
+}
+
+type chatIdentityState struct {
+	SigningKey           []byte            `json:"signing_key"`
+	IdentityKey          []byte            `json:"identity_key"`
+	SignedPreKey         []byte            `json:"signed_pre_key"`
+	PreviousSignedPreKey []byte            `json:"previous_signed_pre_key,omitempty"`
+	OneTimePreKeys       map[uint32][]byte `json:"one_time_pre_keys,omitempty"`
+	NextPreKeyID         uint32            `json:"next_pre_key_id"`
+}
+
+func GenerateChatIdentity() (*ChatIdentity, error) {
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	identityKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signedPreKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatIdentity{
+		signingKey:     signingKey,
+		identityKey:    identityKey,
+		signedPreKey:   signedPreKey,
+		oneTimePreKeys: make(map[uint32]*ecdh.PrivateKey),
+		nextPreKeyID:   1,
+	}, nil
+}
+
+// SigningKey returns public key which is used to verify pre key bundles of the identity
+func (c *ChatIdentity) SigningKey() ed25519.PublicKey {
+	return c.signingKey.Public().(ed25519.PublicKey)
+}
+
+// IdentityKey returns public identity key which is returned by ChatSession.RemoteIdentityKey of peers
+func (c *ChatIdentity) IdentityKey() []byte {
+	return c.identityKey.PublicKey().Bytes()
+}
+
+// NewPreKeyBundle creates a bundle for publishing. One-time pre key provides better forward secrecy for the first messages
+func (c *ChatIdentity) NewPreKeyBundle(withOneTimePreKey bool) (*ChatPreKeyBundle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := &ChatPreKeyBundle{
+		SigningKey:   c.signingKey.Public().(ed25519.PublicKey),
+		IdentityKey:  c.identityKey.PublicKey().Bytes(),
+		SignedPreKey: c.signedPreKey.PublicKey().Bytes(),
+	}
+	b.Signature = ed25519.Sign(c.signingKey, b.signedData())
+	if withOneTimePreKey {
+		k, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		b.OneTimePreKeyID = c.nextPreKeyID
+		b.OneTimePreKey = k.PublicKey().Bytes()
+		c.oneTimePreKeys[c.nextPreKeyID] = k
+		c.nextPreKeyID++
+	}
+	return b, nil
+}
+
+// RotateSignedPreKey replaces signed pre key. The previous one is kept for sessions initiated with old bundles
+func (c *ChatIdentity) RotateSignedPreKey() error {
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.previousSignedPreKey, c.signedPreKey = c.signedPreKey, k
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ChatIdentity) MarshalBinary() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := chatIdentityState{
+		SigningKey:     c.signingKey,
+		IdentityKey:    c.identityKey.Bytes(),
+		SignedPreKey:   c.signedPreKey.Bytes(),
+		OneTimePreKeys: make(map[uint32][]byte, len(c.oneTimePreKeys)),
+		NextPreKeyID:   c.nextPreKeyID,
+	}
+	if c.previousSignedPreKey != nil {
+		s.PreviousSignedPreKey = c.previousSignedPreKey.Bytes()
+	}
+	for id, k := range c.oneTimePreKeys {
+		s.OneTimePreKeys[id] = k.Bytes()
+	}
+	return json.Marshal(s)
+}
+
+func (c *ChatIdentity) UnmarshalBinary(data []byte) error {
+	var s chatIdentityState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if len(s.SigningKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid signing key")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	c.signingKey = s.SigningKey
+	if c.identityKey, err = ecdh.X25519().NewPrivateKey(s.IdentityKey); err != nil {
+		return err
+	}
+	if c.signedPreKey, err = ecdh.X25519().NewPrivateKey(s.SignedPreKey); err != nil {
+		return err
+	}
+	c.previousSignedPreKey = nil
+	if s.PreviousSignedPreKey != nil {
+		if c.previousSignedPreKey, err = ecdh.X25519().NewPrivateKey(s.PreviousSignedPreKey); err != nil {
+			return err
+		}
+	}
+	c.oneTimePreKeys = make(map[uint32]*ecdh.PrivateKey, len(s.OneTimePreKeys))
+	for id, b := range s.OneTimePreKeys {
+		if c.oneTimePreKeys[id], err = ecdh.X25519().NewPrivateKey(b); err != nil {
+			return err
+		}
+	}
+	c.nextPreKeyID = s.NextPreKeyID
+	return nil
+}
+
+func (c *ChatIdentity) MarshalJSON() ([]byte, error) {
+	data, err := c.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func (c *ChatIdentity) UnmarshalJSON(data []byte) error {
+	var b []byte
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	return c.UnmarshalBinary(b)
+}
+
+func (b *ChatPreKeyBundle) signedData() []byte {
+	return append(bytes.Clone(b.IdentityKey), b.SignedPreKey...)
+}
+
+// Verify verifies signature of signed pre key with signing key which should be trusted by other means
+func (b *ChatPreKeyBundle) Verify() error {
+	if len(b.SigningKey) != ed25519.PublicKeySize || !ed25519.Verify(b.SigningKey, b.signedData(), b.Signature) {
+		return ErrChatSignature
+	}
+	return nil
+}
+
+// ChatSession is an end-to-end encrypted session with double ratchet
+// It must be persisted after each Encrypt or Decrypt, e.g. in xsqlite.KVTable with MarshalBinary or SaveObject
+type ChatSession struct {
+	mu    sync.Mutex
+	state chatState
+}
+
+type chatState struct {
+	AD             []byte            `json:"ad"`
+	RemoteIdentity []byte            `json:"remote_identity"`
+	RootKey        []byte            `json:"root_key"`
+	SendingKey     []byte            `json:"sending_key"`
+	RemoteKey      []byte            `json:"remote_key,omitempty"`
+	SendingChain   []byte            `json:"sending_chain,omitempty"`
+	ReceivingChain []byte            `json:"receiving_chain,omitempty"`
+	Ns             uint32            `json:"ns"`
+	Nr             uint32            `json:"nr"`
+	PN             uint32            `json:"pn"`
+	Skipped        map[string][]byte `json:"skipped,omitempty"`
+	// Initial is X3DH header attached to messages until the first message from remote is received
+	Initial []byte `json:"initial,omitempty"`
+}
+
+type chatHeader struct {
+	initial    []byte
+	ratchetKey []byte
+	pn         uint32
+	n          uint32
+	// raw is encoded header which is authenticated as associated data
+	raw        []byte
+	ciphertext []byte
+}
+
+// NewChatSession starts a session with remote user whose pre key bundle is obtained from server
+func NewChatSession(identity *ChatIdentity, remote *ChatPreKeyBundle) (*ChatSession, error) {
+	if err := remote.Verify(); err != nil {
+		return nil, err
+	}
+	curve := ecdh.X25519()
+	remoteIdentity, err := curve.NewPublicKey(remote.IdentityKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity key: %w", err)
+	}
+	signedPreKey, err := curve.NewPublicKey(remote.SignedPreKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed pre key: %w", err)
+	}
+	ephemeral, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	identity.mu.Lock()
+	identityKey := identity.identityKey
+	identity.mu.Unlock()
+
+	dhs := [][2]any{
+		{identityKey, signedPreKey},
+		{ephemeral, remoteIdentity},
+		{ephemeral, signedPreKey},
+	}
+	if remote.OneTimePreKey != nil {
+		oneTimePreKey, err := curve.NewPublicKey(remote.OneTimePreKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid one-time pre key: %w", err)
+		}
+		dhs = append(dhs, [2]any{ephemeral, oneTimePreKey})
+	}
+	sk, err := x3dh(dhs)
+	if err != nil {
+		return nil, err
+	}
+
+	ratchetKey, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := ratchetKey.ECDH(signedPreKey)
+	if err != nil {
+		return nil, err
+	}
+	rootKey, sendingChain := kdfRootKey(sk, dh)
+
+	initial := append(identityKey.PublicKey().Bytes(), ephemeral.PublicKey().Bytes()...)
+	initial = append(initial, remote.SignedPreKey...)
+	initial = binary.BigEndian.AppendUint32(initial, remote.OneTimePreKeyID)
+	return &ChatSession{
+		state: chatState{
+			AD:             append(identityKey.PublicKey().Bytes(), remote.IdentityKey...),
+			RemoteIdentity: bytes.Clone(remote.IdentityKey),
+			RootKey:        rootKey,
+			SendingKey:     ratchetKey.Bytes(),
+			RemoteKey:      bytes.Clone(remote.SignedPreKey),
+			SendingChain:   sendingChain,
+			Initial:        initial,
+		},
+	}, nil
+}
+
+// AcceptChatSession creates session from the first message sent by remote user, and returns the decrypted message
+// Used one-time pre key is removed from identity
+func AcceptChatSession(identity *ChatIdentity, message []byte) (*ChatSession, []byte, error) {
+	h, err := parseChatHeader(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.initial == nil {
+		return nil, nil, errors.New("not an initial message")
+	}
+	curve := ecdh.X25519()
+	remoteIdentity, err := curve.NewPublicKey(h.initial[:chatKeySize])
+	if err != nil {
+		return nil, nil, ErrKey
+	}
+	ephemeral, err := curve.NewPublicKey(h.initial[chatKeySize : 2*chatKeySize])
+	if err != nil {
+		return nil, nil, ErrKey
+	}
+	signedPreKeyPub := h.initial[2*chatKeySize : 3*chatKeySize]
+	oneTimePreKeyID := binary.BigEndian.Uint32(h.initial[3*chatKeySize:])
+
+	identity.mu.Lock()
+	defer identity.mu.Unlock()
+	var signedPreKey *ecdh.PrivateKey
+	for _, k := range []*ecdh.PrivateKey{identity.signedPreKey, identity.previousSignedPreKey} {
+		if k != nil && bytes.Equal(k.PublicKey().Bytes(), signedPreKeyPub) {
+			signedPreKey = k
+		}
+	}
+	if signedPreKey == nil {
+		return nil, nil, ErrChatPreKey
+	}
+
+	dhs := [][2]any{
+		{signedPreKey, remoteIdentity},
+		{identity.identityKey, ephemeral},
+		{signedPreKey, ephemeral},
+	}
+	if oneTimePreKeyID != 0 {
+		oneTimePreKey := identity.oneTimePreKeys[oneTimePreKeyID]
+		if oneTimePreKey == nil {
+			return nil, nil, ErrChatPreKey
+		}
+		dhs = append(dhs, [2]any{oneTimePreKey, ephemeral})
+	}
+	sk, err := x3dh(dhs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &ChatSession{
+		state: chatState{
+			AD:             append(remoteIdentity.Bytes(), identity.identityKey.PublicKey().Bytes()...),
+			RemoteIdentity: remoteIdentity.Bytes(),
+			RootKey:        sk,
+			SendingKey:     signedPreKey.Bytes(),
+		},
+	}
+	plaintext, err := s.Decrypt(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	delete(identity.oneTimePreKeys, oneTimePreKeyID)
+	return s, plaintext, nil
+}
+
+// IsInitialChatMessage reports whether message should be accepted by AcceptChatSession if there is no session with the sender
+func IsInitialChatMessage(message []byte) bool {
+	h, err := parseChatHeader(message)
+	return err == nil && h.initial != nil
+}
+
+// RemoteIdentityKey returns identity key of remote user
+func (s *ChatSession) RemoteIdentityKey() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return bytes.Clone(s.state.RemoteIdentity)
+}
+
+func (s *ChatSession) Encrypt(plaintext []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &s.state
+	if st.SendingChain == nil {
+		return nil, errors.New("sending chain is not ready")
+	}
+	sendingKey, err := ecdh.X25519().NewPrivateKey(st.SendingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var mk []byte
+	st.SendingChain, mk = kdfChainKey(st.SendingChain)
+	header := []byte{chatMessageVersion, 0}
+	if st.Initial != nil {
+		header[1] = chatFlagInitial
+		header = append(header, st.Initial...)
+	}
+	header = append(header, sendingKey.PublicKey().Bytes()...)
+	header = binary.BigEndian.AppendUint32(header, st.PN)
+	header = binary.BigEndian.AppendUint32(header, st.Ns)
+	st.Ns++
+	return sealChatMessage(mk, st.AD, header, plaintext)
+}
+
+// Decrypt decrypts message which might arrive out of order. Session state is not changed if it fails
+func (s *ChatSession) Decrypt(message []byte) ([]byte, error) {
+	h, err := parseChatHeader(message)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state
+	st.Skipped = maps.Clone(s.state.Skipped)
+	plaintext, err := st.decrypt(h)
+	if err != nil {
+		return nil, err
+	}
+	st.Initial = nil
+	s.state = st
+	return plaintext, nil
+}
+
+func (s *ChatSession) EncryptMessage(message string) (string, error) {
+	data, err := s.Encrypt([]byte(message))
+	if err != nil {
+		return "", err
+	}
+	return xbase62.EncodeToString(data), nil
+}
+
+func (s *ChatSession) DecryptMessage(message string) (string, error) {
+	data, err := xbase62.DecodeString(message)
+	if err != nil {
+		return "", fmt.Errorf("xbase62.DecodeString: %w", err)
+	}
+	data, err = s.Decrypt(data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s *ChatSession) MarshalBinary() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s.state)
+}
+
+func (s *ChatSession) UnmarshalBinary(data []byte) error {
+	var st chatState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.state = st
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *ChatSession) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s.state)
+}
+
+func (s *ChatSession) UnmarshalJSON(data []byte) error {
+	return s.UnmarshalBinary(data)
+}
+
+func (st *chatState) decrypt(h *chatHeader) ([]byte, error) {
+	skippedKey := chatSkippedKey(h.ratchetKey, h.n)
+	if mk, ok := st.Skipped[skippedKey]; ok {
+		delete(st.Skipped, skippedKey)
+		return openChatMessage(mk, st.AD, h)
+	}
+
+	if !bytes.Equal(h.ratchetKey, st.RemoteKey) {
+		if err := st.skipMessageKeys(h.pn); err != nil {
+			return nil, err
+		}
+		if err := st.ratchet(h.ratchetKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := st.skipMessageKeys(h.n); err != nil {
+		return nil, err
+	}
+	var mk []byte
+	st.ReceivingChain, mk = kdfChainKey(st.ReceivingChain)
+	st.Nr++
+	return openChatMessage(mk, st.AD, h)
+}
+
+func (st *chatState) skipMessageKeys(until uint32) error {
+	if st.ReceivingChain == nil || until <= st.Nr {
+		return nil
+	}
+	if until-st.Nr > MaxSkippedChatMessages {
+		return fmt.Errorf("too many skipped messages: %d", until-st.Nr)
+	}
+	if st.Skipped == nil {
+		st.Skipped = make(map[string][]byte)
+	}
+	for st.Nr < until {
+		var mk []byte
+		st.ReceivingChain, mk = kdfChainKey(st.ReceivingChain)
+		st.Skipped[chatSkippedKey(st.RemoteKey, st.Nr)] = mk
+		st.Nr++
+	}
+	return nil
+}
+
+func (st *chatState) ratchet(remoteKey []byte) error {
+	curve := ecdh.X25519()
+	remote, err := curve.NewPublicKey(remoteKey)
+	if err != nil {
+		return ErrKey
+	}
+	sendingKey, err := curve.NewPrivateKey(st.SendingKey)
+	if err != nil {
+		return err
+	}
+	dh, err := sendingKey.ECDH(remote)
+	if err != nil {
+		return ErrKey
+	}
+	st.PN, st.Ns, st.Nr = st.Ns, 0, 0
+	st.RemoteKey = bytes.Clone(remoteKey)
+	st.RootKey, st.ReceivingChain = kdfRootKey(st.RootKey, dh)
+
+	sendingKey, err = curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	dh, err = sendingKey.ECDH(remote)
+	if err != nil {
+		return ErrKey
+	}
+	st.SendingKey = sendingKey.Bytes()
+	st.RootKey, st.SendingChain = kdfRootKey(st.RootKey, dh)
+	return nil
+}
+
+func chatSkippedKey(ratchetKey []byte, n uint32) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(ratchetKey), n)
+}
+
+func parseChatHeader(message []byte) (*chatHeader, error) {
+	if len(message) < 2 || message[0] != chatMessageVersion {
+		return nil, errors.New("invalid chat message")
+	}
+	h := &chatHeader{}
+	b := message[2:]
+	if message[1]&chatFlagInitial != 0 {
+		if len(b) < chatInitialSize {
+			return nil, ErrTruncated
+		}
+		h.initial, b = b[:chatInitialSize], b[chatInitialSize:]
+	}
+	if len(b) < chatRatchetHeaderLen+tagSize {
+		return nil, ErrTruncated
+	}
+	h.ratchetKey = b[:chatKeySize]
+	h.pn = binary.BigEndian.Uint32(b[chatKeySize:])
+	h.n = binary.BigEndian.Uint32(b[chatKeySize+4:])
+	headerSize := len(message) - len(b) + chatRatchetHeaderLen
+	h.raw, h.ciphertext = message[:headerSize], message[headerSize:]
+	return h, nil
+}
+
+// sealChatMessage encrypts plaintext with message key which is used only once, so zero nonce is safe
+func sealChatMessage(mk, ad, header, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(AES256GCM, mk)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, make([]byte, aead.NonceSize()), plaintext, append(bytes.Clone(ad), header...)), nil
+}
+
+func openChatMessage(mk, ad []byte, h *chatHeader) ([]byte, error) {
+	aead, err := newAEAD(AES256GCM, mk)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, make([]byte, aead.NonceSize()), h.ciphertext, append(bytes.Clone(ad), h.raw...))
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return plaintext, nil
+}
+
+// x3dh computes shared secret from DH outputs of key pairs
+func x3dh(pairs [][2]any) ([]byte, error) {
+	ikm := bytes.Repeat([]byte{0xFF}, chatKeySize)
+	for _, pair := range pairs {
+		dh, err := pair[0].(*ecdh.PrivateKey).ECDH(pair[1].(*ecdh.PublicKey))
+		if err != nil {
+			return nil, ErrKey
+		}
+		ikm = append(ikm, dh...)
+	}
+	return hkdf.Key(sha256.New, ikm, make([]byte, chatKeySize), "xsecurity x3dh", chatKeySize)
+}
+
+func kdfRootKey(rootKey, dh []byte) (newRootKey, chainKey []byte) {
+	b, err := hkdf.Key(sha256.New, dh, rootKey, "xsecurity ratchet", 2*chatKeySize)
+	if err != nil {
+		panic(err)
+	}
+	return b[:chatKeySize], b[chatKeySize:]
+}
+
+func kdfChainKey(chainKey []byte) (newChainKey, messageKey []byte) {
+	m := hmac.New(sha256.New, chainKey)
+	m.Write([]byte{1})
+	messageKey = m.Sum(nil)
+	m = hmac.New(sha256.New, chainKey)
+	m.Write([]byte{2})
+	return m.Sum(nil), messageKey
+}
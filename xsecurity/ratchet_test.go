@@ -0,0 +1,165 @@
+package xsecurity
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.olapie.com/x/xtest"
+)
+
+func newChatSessions(t *testing.T, withOneTimePreKey bool) (alice, bob *ChatSession) {
+	aliceID, err := GenerateChatIdentity()
+	xtest.NoError(t, err)
+	bobID, err := GenerateChatIdentity()
+	xtest.NoError(t, err)
+	bundle, err := bobID.NewPreKeyBundle(withOneTimePreKey)
+	xtest.NoError(t, err)
+
+	alice, err = NewChatSession(aliceID, bundle)
+	xtest.NoError(t, err)
+	msg, err := alice.Encrypt([]byte("hello"))
+	xtest.NoError(t, err)
+	xtest.True(t, IsInitialChatMessage(msg))
+
+	bob, plain, err := AcceptChatSession(bobID, msg)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "hello", string(plain))
+	xtest.Equal(t, aliceID.IdentityKey(), bob.RemoteIdentityKey())
+	xtest.Equal(t, bobID.IdentityKey(), alice.RemoteIdentityKey())
+
+	if withOneTimePreKey {
+		_, _, err = AcceptChatSession(bobID, msg)
+		xtest.Equal(t, ErrChatPreKey, err)
+	}
+	return alice, bob
+}
+
+func TestChatSession(t *testing.T) {
+	for _, withOneTimePreKey := range []bool{true, false} {
+		alice, bob := newChatSessions(t, withOneTimePreKey)
+		for i := 0; i < 3; i++ {
+			s, err := bob.EncryptMessage("ping")
+			xtest.NoError(t, err)
+			s, err = alice.DecryptMessage(s)
+			xtest.NoError(t, err)
+			xtest.Equal(t, "ping", s)
+
+			s, err = alice.EncryptMessage("pong")
+			xtest.NoError(t, err)
+			s, err = bob.DecryptMessage(s)
+			xtest.NoError(t, err)
+			xtest.Equal(t, "pong", s)
+		}
+	}
+}
+
+func TestChatSession_OutOfOrder(t *testing.T) {
+	alice, bob := newChatSessions(t, true)
+	var messages [][]byte
+	for i := 0; i < 5; i++ {
+		msg, err := alice.Encrypt([]byte{byte(i)})
+		xtest.NoError(t, err)
+		messages = append(messages, msg)
+	}
+	reply, err := bob.Encrypt([]byte("reply"))
+	xtest.NoError(t, err)
+	// new sending chain after alice receives bob's ratchet key
+	plain, err := alice.Decrypt(reply)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "reply", string(plain))
+	msg, err := alice.Encrypt([]byte{5})
+	xtest.NoError(t, err)
+	xtest.False(t, IsInitialChatMessage(msg))
+	messages = append(messages, msg)
+
+	for _, i := range []int{5, 3, 0, 4, 2, 1} {
+		plain, err := bob.Decrypt(messages[i])
+		xtest.NoError(t, err)
+		xtest.Equal(t, []byte{byte(i)}, plain)
+	}
+
+	// replayed message key has been deleted
+	_, err = bob.Decrypt(messages[2])
+	xtest.Error(t, err)
+}
+
+func TestChatSession_Tampered(t *testing.T) {
+	alice, bob := newChatSessions(t, false)
+	msg, err := alice.Encrypt([]byte("hi"))
+	xtest.NoError(t, err)
+	tampered := append([]byte(nil), msg...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = bob.Decrypt(tampered)
+	xtest.Equal(t, ErrAuth, err)
+
+	// state is unchanged by failed decryption
+	plain, err := bob.Decrypt(msg)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "hi", string(plain))
+}
+
+func TestChatSession_TooManySkipped(t *testing.T) {
+	alice, bob := newChatSessions(t, false)
+	var msg []byte
+	var err error
+	for i := 0; i < MaxSkippedChatMessages+2; i++ {
+		msg, err = alice.Encrypt([]byte("hi"))
+		xtest.NoError(t, err)
+	}
+	_, err = bob.Decrypt(msg)
+	xtest.Error(t, err)
+}
+
+func TestChatSession_Marshal(t *testing.T) {
+	alice, bob := newChatSessions(t, true)
+	msg, err := alice.Encrypt([]byte("skipped"))
+	xtest.NoError(t, err)
+
+	data, err := bob.MarshalBinary()
+	xtest.NoError(t, err)
+	bob = new(ChatSession)
+	xtest.NoError(t, bob.UnmarshalBinary(data))
+
+	data, err = json.Marshal(alice)
+	xtest.NoError(t, err)
+	alice = new(ChatSession)
+	xtest.NoError(t, json.Unmarshal(data, alice))
+
+	msg2, err := alice.Encrypt([]byte("hi"))
+	xtest.NoError(t, err)
+	plain, err := bob.Decrypt(msg2)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "hi", string(plain))
+	plain, err = bob.Decrypt(msg)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "skipped", string(plain))
+}
+
+func TestChatIdentity_Marshal(t *testing.T) {
+	id, err := GenerateChatIdentity()
+	xtest.NoError(t, err)
+	bundle, err := id.NewPreKeyBundle(true)
+	xtest.NoError(t, err)
+	xtest.NoError(t, id.RotateSignedPreKey())
+
+	data, err := json.Marshal(id)
+	xtest.NoError(t, err)
+	restored := new(ChatIdentity)
+	xtest.NoError(t, json.Unmarshal(data, restored))
+	xtest.Equal(t, id.IdentityKey(), restored.IdentityKey())
+
+	other, err := GenerateChatIdentity()
+	xtest.NoError(t, err)
+	// initiated with bundle of the previous signed pre key
+	s, err := NewChatSession(other, bundle)
+	xtest.NoError(t, err)
+	msg, err := s.Encrypt([]byte("hello"))
+	xtest.NoError(t, err)
+	_, plain, err := AcceptChatSession(restored, msg)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "hello", string(plain))
+
+	bundle.Signature[0] ^= 1
+	_, err = NewChatSession(other, bundle)
+	xtest.Equal(t, ErrChatSignature, err)
+}
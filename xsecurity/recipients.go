@@ -0,0 +1,286 @@
+package xsecurity
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+)
+
+// keyTypeRecipients key block: recipient count | recipient...
+// recipient: recipient id | stanza size | stanza, see keyTypePublicKey for stanza layout
+const keyTypeRecipients keyType = 5
+
+const recipientIDSize = 8
+
+type recipient struct {
+	id     [recipientIDSize]byte
+	stanza []byte
+}
+
+// RecipientID returns fingerprint of public key which identifies recipient in encrypted header
+func RecipientID(pub any) (string, error) {
+	k, err := toECDHPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	id, err := recipientID(k)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+func recipientID(pub *ecdh.PublicKey) ([recipientIDSize]byte, error) {
+	var id [recipientIDSize]byte
+	c, err := getCurveID(pub.Curve())
+	if err != nil {
+		return id, err
+	}
+	sum := sha256.Sum256(append([]byte{byte(c)}, pub.Bytes()...))
+	copy(id[:], sum[:])
+	return id, nil
+}
+
+// SealToRecipients encrypts data once, and each recipient can open it with its own private key
+func SealToRecipients(recipients []any, data []byte, optFns ...func(options *EncryptOptions)) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	w, err := NewRecipientsSealedWriter(buf, recipients, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NewRecipientsSealedWriter is the streaming version of SealToRecipients
+// Sealed data can be opened by Open or NewOpenedReader
+func NewRecipientsSealedWriter(w io.Writer, recipients []any, optFns ...func(options *EncryptOptions)) (*EncryptedWriter, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+	options := newEncryptOptions(optFns)
+	fileKey := make([]byte, KeySize)
+	_, _ = rand.Read(fileKey)
+	h := &headerV2{
+		cipher:  options.Cipher,
+		keyType: keyTypeRecipients,
+	}
+	var list []recipient
+	for _, pub := range recipients {
+		r, err := newRecipient(pub, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		list = addRecipient(list, r)
+	}
+	if err := h.setRecipients(list); err != nil {
+		return nil, err
+	}
+	_, _ = rand.Read(h.noncePrefix[:])
+	sealer, err := newChunkSealer(w, h, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedWriter{sealer: sealer}, nil
+}
+
+// Recipients returns ids of recipients who can open data
+func Recipients(data []byte) ([]string, error) {
+	h, err := parseHeaderV2(data)
+	if err != nil {
+		return nil, err
+	}
+	list, err := h.recipients()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(list))
+	for i, r := range list {
+		ids[i] = hex.EncodeToString(r.id[:])
+	}
+	return ids, nil
+}
+
+// AddRecipient grants pub access to data. priv is private key of an existing recipient to unwrap file key
+// Payload is copied as it is without decryption
+func AddRecipient(data []byte, priv, pub any) ([]byte, error) {
+	return rewriteHeader(data, func(h *headerV2) error {
+		return h.addRecipient(priv, pub)
+	})
+}
+
+// RevokeRecipient removes pub from recipients of data. Payload is not re-encrypted,
+// so the revoked recipient who has kept file key or an old copy of data can still decrypt it
+func RevokeRecipient(data []byte, pub any) ([]byte, error) {
+	return rewriteHeader(data, func(h *headerV2) error {
+		return h.revokeRecipient(pub)
+	})
+}
+
+// AddFileRecipient is the file version of AddRecipient
+func AddFileRecipient(filename string, priv, pub any) error {
+	return rewriteFileHeader(filename, func(h *headerV2) error {
+		return h.addRecipient(priv, pub)
+	})
+}
+
+// RevokeFileRecipient is the file version of RevokeRecipient
+func RevokeFileRecipient(filename string, pub any) error {
+	return rewriteFileHeader(filename, func(h *headerV2) error {
+		return h.revokeRecipient(pub)
+	})
+}
+
+func newRecipient(pub any, fileKey []byte) (recipient, error) {
+	k, err := toECDHPublicKey(pub)
+	if err != nil {
+		return recipient{}, err
+	}
+	id, err := recipientID(k)
+	if err != nil {
+		return recipient{}, err
+	}
+	stanza, err := wrapFileKeyTo(k, fileKey)
+	if err != nil {
+		return recipient{}, err
+	}
+	return recipient{id: id, stanza: stanza}, nil
+}
+
+// addRecipient appends r, or replaces the existing one with the same id
+func addRecipient(list []recipient, r recipient) []recipient {
+	for i := range list {
+		if list[i].id == r.id {
+			list[i] = r
+			return list
+		}
+	}
+	return append(list, r)
+}
+
+// recipientKey unwraps file key with identity which is one of recipients
+func (h *headerV2) recipientKey(identity *ecdh.PrivateKey) ([]byte, error) {
+	if h.keyType == keyTypePublicKey {
+		return unwrapFileKey(identity, h.keyBlock)
+	}
+	list, err := h.recipients()
+	if err != nil {
+		return nil, err
+	}
+	id, err := recipientID(identity.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range list {
+		if r.id == id {
+			return unwrapFileKey(identity, r.stanza)
+		}
+	}
+	return nil, ErrKey
+}
+
+func (h *headerV2) addRecipient(priv, pub any) error {
+	identity, err := toECDHPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	fileKey, err := h.recipientKey(identity)
+	if err != nil {
+		return err
+	}
+
+	var list []recipient
+	if h.keyType == keyTypePublicKey {
+		// single recipient stanza doesn't contain recipient id
+		id, err := recipientID(identity.PublicKey())
+		if err != nil {
+			return err
+		}
+		list = []recipient{{id: id, stanza: h.keyBlock}}
+	} else if list, err = h.recipients(); err != nil {
+		return err
+	}
+
+	r, err := newRecipient(pub, fileKey)
+	if err != nil {
+		return err
+	}
+	h.keyType = keyTypeRecipients
+	return h.setRecipients(addRecipient(list, r))
+}
+
+func (h *headerV2) revokeRecipient(pub any) error {
+	k, err := toECDHPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	id, err := recipientID(k)
+	if err != nil {
+		return err
+	}
+	list, err := h.recipients()
+	if err != nil {
+		return err
+	}
+	for i, r := range list {
+		if r.id == id {
+			if len(list) == 1 {
+				return fmt.Errorf("cannot revoke the last recipient")
+			}
+			return h.setRecipients(append(list[:i], list[i+1:]...))
+		}
+	}
+	return fmt.Errorf("recipient not found: %s", hex.EncodeToString(id[:]))
+}
+
+func (h *headerV2) recipients() ([]recipient, error) {
+	if h.keyType != keyTypeRecipients {
+		return nil, fmt.Errorf("not sealed to recipients")
+	}
+	b := h.keyBlock
+	if len(b) == 0 {
+		return nil, ErrKey
+	}
+	list := make([]recipient, b[0])
+	b = b[1:]
+	for i := range list {
+		if len(b) < recipientIDSize+1 || len(b) < recipientIDSize+1+int(b[recipientIDSize]) {
+			return nil, ErrKey
+		}
+		copy(list[i].id[:], b)
+		size := int(b[recipientIDSize])
+		list[i].stanza = b[recipientIDSize+1 : recipientIDSize+1+size]
+		b = b[recipientIDSize+1+size:]
+	}
+	if len(b) != 0 {
+		return nil, ErrKey
+	}
+	return list, nil
+}
+
+func (h *headerV2) setRecipients(list []recipient) error {
+	if len(list) > math.MaxUint8 {
+		return fmt.Errorf("too many recipients: %d", len(list))
+	}
+	b := []byte{byte(len(list))}
+	for _, r := range list {
+		b = append(b, r.id[:]...)
+		b = append(b, byte(len(r.stanza)))
+		b = append(b, r.stanza...)
+	}
+	if len(b) > math.MaxUint16 {
+		return fmt.Errorf("too many recipients: %d", len(list))
+	}
+	h.keyBlock = b
+	return nil
+}
@@ -0,0 +1,86 @@
+package xsecurity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.olapie.com/x/xtest"
+)
+
+func TestSealToRecipients(t *testing.T) {
+	alice := mustGenerateKey(t, X25519)
+	bob := mustGenerateKey(t, EcdsaP256)
+	carol := mustGenerateKey(t, X25519)
+	raw := xtest.RandomBytes(ChunkSize + 10)
+
+	sealed, err := SealToRecipients([]any{GetPublicKey(alice), GetPublicKey(bob)}, raw)
+	xtest.NoError(t, err)
+	ids, err := Recipients(sealed)
+	xtest.NoError(t, err)
+	aliceID, err := RecipientID(GetPublicKey(alice))
+	xtest.NoError(t, err)
+	bobID, err := RecipientID(GetPublicKey(bob))
+	xtest.NoError(t, err)
+	carolID, err := RecipientID(GetPublicKey(carol))
+	xtest.NoError(t, err)
+	xtest.Equal(t, []string{aliceID, bobID}, ids)
+
+	for _, priv := range []any{alice, bob} {
+		opened, err := Open(priv, sealed)
+		xtest.NoError(t, err)
+		xtest.Equal(t, raw, opened)
+	}
+	_, err = Open(carol, sealed)
+	xtest.Equal(t, ErrKey, err)
+
+	// carol cannot grant access to herself
+	_, err = AddRecipient(sealed, carol, GetPublicKey(carol))
+	xtest.Equal(t, ErrKey, err)
+
+	added, err := AddRecipient(sealed, bob, GetPublicKey(carol))
+	xtest.NoError(t, err)
+	xtest.Equal(t, sealed[len(sealed)-100:], added[len(added)-100:])
+	opened, err := Open(carol, added)
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, opened)
+
+	revoked, err := RevokeRecipient(added, GetPublicKey(alice))
+	xtest.NoError(t, err)
+	ids, err = Recipients(revoked)
+	xtest.NoError(t, err)
+	xtest.Equal(t, []string{bobID, carolID}, ids)
+	_, err = Open(alice, revoked)
+	xtest.Equal(t, ErrKey, err)
+	opened, err = Open(carol, revoked)
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, opened)
+
+	_, err = RevokeRecipient(revoked, GetPublicKey(alice))
+	xtest.Error(t, err)
+}
+
+func TestAddFileRecipient(t *testing.T) {
+	alice := mustGenerateKey(t, X25519)
+	bob := mustGenerateKey(t, X25519)
+	raw := xtest.RandomBytes(100)
+
+	// single recipient data is converted to multi-recipient data
+	sealed, err := SealTo(GetPublicKey(alice), raw)
+	xtest.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "shared")
+	xtest.NoError(t, os.WriteFile(filename, sealed, 0644))
+
+	xtest.NoError(t, AddFileRecipient(filename, alice, GetPublicKey(bob)))
+	xtest.Error(t, RevokeFileRecipient(filename, GetPublicKey(mustGenerateKey(t, X25519))))
+	xtest.NoError(t, RevokeFileRecipient(filename, GetPublicKey(alice)))
+	xtest.Error(t, RevokeFileRecipient(filename, GetPublicKey(bob)))
+
+	data, err := os.ReadFile(filename)
+	xtest.NoError(t, err)
+	_, err = Open(alice, data)
+	xtest.Equal(t, ErrKey, err)
+	opened, err := Open(bob, data)
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, opened)
+}
@@ -11,15 +11,22 @@ import (
 // MagicNumberV1 is a defined 4-byte number to identify file type
 // refer to https://en.wikipedia.org/wiki/List_of_file_signatures
 // Header layout: magic number | key checksum
+// MagicNumberV2 is used by authenticated format, see stream.go for its layout
 const (
 	MagicNumberV1 = "\xFE\xF1\xFD\x01"
+	MagicNumberV2 = "\xFE\xF1\xFD\x02"
 
 	MagicNumberSize = len(MagicNumberV1)
 	KeySize         = 32
 	KeyHashSize     = 16
 	HeaderSize      = MagicNumberSize + KeyHashSize
 
-	ErrKey errorString = "invalid key"
+	ErrKey       errorString = "invalid key"
+	ErrAuth      errorString = "message authentication failed"
+	ErrTruncated errorString = "truncated data"
+	ErrSignature errorString = "invalid signature"
+
+	errClosed errorString = "closed"
 )
 
 type errorString string
@@ -49,10 +56,17 @@ func DeriveKey(password string, salt []byte) Key {
 }
 
 func IsEncrypted(data []byte) bool {
-	if len(data) < HeaderSize {
+	if len(data) < MagicNumberSize {
+		return false
+	}
+	switch string(data[:MagicNumberSize]) {
+	case MagicNumberV1:
+		return len(data) >= HeaderSize
+	case MagicNumberV2:
+		return len(data) >= minHeaderV2Size
+	default:
 		return false
 	}
-	return string(data[:MagicNumberSize]) == MagicNumberV1
 }
 
 func IsEncryptedFile(filename string) bool {
@@ -62,15 +76,23 @@ func IsEncryptedFile(filename string) bool {
 	}
 	defer f.Close()
 
-	var header [HeaderSize]byte
-	_, err = io.ReadFull(f, header[:])
-	if err != nil {
-		return false
-	}
-	return string(header[:MagicNumberSize]) == MagicNumberV1
+	var header [max(HeaderSize, minHeaderV2Size)]byte
+	n, _ := io.ReadFull(f, header[:])
+	return IsEncrypted(header[:n])
 }
 
 func ValidatePassword(data []byte, password string) bool {
+	if len(data) < MagicNumberSize {
+		return false
+	}
+	if string(data[:MagicNumberSize]) == MagicNumberV2 {
+		h, err := parseHeaderV2(data)
+		if err != nil {
+			return false
+		}
+		_, err = h.passwordKey(password)
+		return err == nil
+	}
 	if len(data) < HeaderSize {
 		return false
 	}
@@ -84,21 +106,44 @@ func ValidateFilePassword(filename, password string) bool {
 	}
 	defer f.Close()
 
-	var header [HeaderSize]byte
-	_, err = io.ReadFull(f, header[:])
+	var magic [MagicNumberSize]byte
+	_, err = io.ReadFull(f, magic[:])
 	if err != nil {
 		return false
 	}
-	return getCipherStream(password).ValidatePassword(header[:])
+
+	switch string(magic[:]) {
+	case MagicNumberV1:
+		var header [HeaderSize]byte
+		copy(header[:], magic[:])
+		_, err = io.ReadFull(f, header[MagicNumberSize:])
+		if err != nil {
+			return false
+		}
+		return getCipherStream(password).ValidatePassword(header[:])
+	case MagicNumberV2:
+		h, err := readHeaderV2(f)
+		if err != nil {
+			return false
+		}
+		_, err = h.passwordKey(password)
+		return err == nil
+	default:
+		return false
+	}
 }
 
-func Encrypt(raw []byte, password string) ([]byte, error) {
+// Encrypt encrypts raw data into MagicNumberV2 format
+func Encrypt(raw []byte, password string, optFns ...func(options *EncryptOptions)) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
-	w := NewEncryptedWriter(buf, password)
+	w := NewEncryptedWriter(buf, password, optFns...)
 	_, err := io.Copy(w, bytes.NewReader(raw))
 	if err != nil {
 		return nil, err
 	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -115,6 +160,22 @@ func Decrypt(data []byte, password string) ([]byte, error) {
 // DecryptInPlace will write decrypted data into parameter data
 // input data parameter will be modified after decrypting
 func DecryptInPlace(data []byte, password string) ([]byte, error) {
+	if len(data) >= MagicNumberSize && string(data[:MagicNumberSize]) == MagicNumberV2 {
+		h, err := parseHeaderV2(data)
+		if err != nil {
+			return nil, err
+		}
+		key, err := h.passwordKey(password)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(h.cipher, key)
+		if err != nil {
+			return nil, err
+		}
+		return openChunksInPlace(aead, newNonceSequence(h.noncePrefix), h.additionalData(), data[h.size():])
+	}
+
 	if len(data) < HeaderSize {
 		return nil, ErrKey
 	}
@@ -126,29 +187,33 @@ func DecryptInPlace(data []byte, password string) ([]byte, error) {
 	return data[HeaderSize:], nil
 }
 
-func ReEncrypt(data []byte, oldPassword, newPassword string) ([]byte, error) {
+// ReEncrypt decrypts data with oldPassword, and encrypts it with newPassword and options
+func ReEncrypt(data []byte, oldPassword, newPassword string, optFns ...func(options *EncryptOptions)) ([]byte, error) {
 	raw, err := Decrypt(data, oldPassword)
 	if err != nil {
 		return nil, err
 	}
-	return Encrypt(raw, newPassword)
+	return Encrypt(raw, newPassword, optFns...)
 }
 
-func EncryptFile(src SourceFile, dst DestFile, password string) error {
+func EncryptFile(src SourceFile, dst DestFile, password string, optFns ...func(options *EncryptOptions)) error {
 	sf, err := os.Open(string(src))
 	if err != nil {
 		return fmt.Errorf("os.Open: %s, %w", src, err)
 	}
 	defer sf.Close()
 
-	df, err := os.OpenFile(string(dst), os.O_CREATE|os.O_WRONLY, 0644)
+	df, err := os.OpenFile(string(dst), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("os.OpenFile: %s, %w", dst, err)
 	}
 	defer df.Close()
-	w := NewEncryptedWriter(df, password)
+	w := NewEncryptedWriter(df, password, optFns...)
 	_, err = io.Copy(w, sf)
-	return err
+	if err != nil {
+		return err
+	}
+	return w.Close()
 }
 
 func DecryptFile(src SourceFile, dst DestFile, password string) error {
@@ -158,7 +223,7 @@ func DecryptFile(src SourceFile, dst DestFile, password string) error {
 	}
 	defer sf.Close()
 	r := NewDecryptedReader(sf, password)
-	df, err := os.OpenFile(string(dst), os.O_CREATE|os.O_WRONLY, 0644)
+	df, err := os.OpenFile(string(dst), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("os.OpenFile: %s, %w", dst, err)
 	}
@@ -204,7 +269,7 @@ func DecryptFileChunks(chunks []SourceFile, dst DestFile, password string) error
 	return nil
 }
 
-func ReEncryptFile(src SourceFile, dst DestFile, srcPassword, dstPassword string) error {
+func ReEncryptFile(src SourceFile, dst DestFile, srcPassword, dstPassword string, optFns ...func(options *EncryptOptions)) error {
 	if !ValidateFilePassword(string(src), srcPassword) {
 		return ErrKey
 	}
@@ -215,16 +280,19 @@ func ReEncryptFile(src SourceFile, dst DestFile, srcPassword, dstPassword string
 	}
 	defer sf.Close()
 
-	df, err := os.OpenFile(string(dst), os.O_CREATE|os.O_WRONLY, 0644)
+	df, err := os.OpenFile(string(dst), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("cannot open file %s: %w", dst, err)
 	}
 	defer df.Close()
 
 	dr := NewDecryptedReader(sf, srcPassword)
-	ew := NewEncryptedWriter(df, dstPassword)
+	ew := NewEncryptedWriter(df, dstPassword, optFns...)
 	_, err = io.Copy(ew, dr)
-	return err
+	if err != nil {
+		return err
+	}
+	return ew.Close()
 }
 
 func EncryptText(text, password string) (string, error) {
@@ -0,0 +1,462 @@
+package xsecurity
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// MagicNumberV2 container layout:
+//
+//	magic number | cipher | key type | key block size | key block | nonce prefix | chunk...
+//
+// Plaintext is split into chunks of ChunkSize bytes, and each chunk is sealed with AEAD.
+// Nonce of each chunk is: nonce prefix | chunk counter | last chunk flag
+// Additional data of each chunk is: magic number | cipher | nonce prefix
+// The last chunk is always shorter than ChunkSize, it might be empty
+const (
+	ChunkSize = 64 << 10
+
+	noncePrefixSize    = 7
+	nonceSize          = noncePrefixSize + 4 + 1
+	tagSize            = 16
+	saltSize           = 16
+	encryptedChunkSize = ChunkSize + tagSize
+
+	// headerV2FixedSize is size of magic number, cipher, key type and key block size
+	headerV2FixedSize = MagicNumberSize + 1 + 1 + 2
+	minHeaderV2Size   = headerV2FixedSize + noncePrefixSize
+)
+
+// Cipher is AEAD algorithm used by MagicNumberV2 container
+type Cipher byte
+
+const (
+	_ Cipher = iota
+	AES256GCM
+	ChaCha20Poly1305
+)
+
+func (c Cipher) String() string {
+	switch c {
+	case AES256GCM:
+		return "AES256GCM"
+	case ChaCha20Poly1305:
+		return "ChaCha20Poly1305"
+	default:
+		return fmt.Sprint(int(c))
+	}
+}
+
+type keyType byte
+
+const (
+	_ keyType = iota
+	// keyTypePassword key block: salt | key check
+	// key is derived with the same params of DefaultKDF
+	keyTypePassword
+	// keyTypePasswordKDF key block: kdf id | kdf params size | kdf params | salt | key check
+	keyTypePasswordKDF
+)
+
+type EncryptOptions struct {
+	Cipher Cipher
+	KDF    KDF
+}
+
+func newEncryptOptions(optFns []func(options *EncryptOptions)) *EncryptOptions {
+	options := &EncryptOptions{
+		Cipher: AES256GCM,
+		KDF:    DefaultKDF,
+	}
+	for _, fn := range optFns {
+		fn(options)
+	}
+	return options
+}
+
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("aes.NewCipher: %w", err)
+		}
+		return cipher.NewGCM(block)
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher: %v", c)
+	}
+}
+
+type headerV2 struct {
+	cipher      Cipher
+	keyType     keyType
+	keyBlock    []byte
+	noncePrefix [noncePrefixSize]byte
+}
+
+func (h *headerV2) encode() []byte {
+	b := make([]byte, 0, minHeaderV2Size+len(h.keyBlock))
+	b = append(b, MagicNumberV2...)
+	b = append(b, byte(h.cipher), byte(h.keyType))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.keyBlock)))
+	b = append(b, h.keyBlock...)
+	b = append(b, h.noncePrefix[:]...)
+	return b
+}
+
+func (h *headerV2) size() int {
+	return minHeaderV2Size + len(h.keyBlock)
+}
+
+// additionalData returns header fields authenticated by every chunk
+// Key type and key block are excluded as they're rewritten by RewrapKey and recipient changes,
+// and a modified key block fails to produce the key anyway
+func (h *headerV2) additionalData() []byte {
+	b := make([]byte, 0, MagicNumberSize+1+noncePrefixSize)
+	b = append(b, MagicNumberV2...)
+	b = append(b, byte(h.cipher))
+	return append(b, h.noncePrefix[:]...)
+}
+
+// readHeaderV2 reads header from r whose magic number has been consumed
+func readHeaderV2(r io.Reader) (*headerV2, error) {
+	var fixed [headerV2FixedSize - MagicNumberSize]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, headerError(err)
+	}
+	h := &headerV2{
+		cipher:   Cipher(fixed[0]),
+		keyType:  keyType(fixed[1]),
+		keyBlock: make([]byte, binary.BigEndian.Uint16(fixed[2:])),
+	}
+	if _, err := io.ReadFull(r, h.keyBlock); err != nil {
+		return nil, headerError(err)
+	}
+	if _, err := io.ReadFull(r, h.noncePrefix[:]); err != nil {
+		return nil, headerError(err)
+	}
+	return h, nil
+}
+
+// parseHeaderV2 parses header from data which starts with magic number
+func parseHeaderV2(data []byte) (*headerV2, error) {
+	if len(data) < minHeaderV2Size || string(data[:MagicNumberSize]) != MagicNumberV2 {
+		return nil, ErrTruncated
+	}
+	return readHeaderV2(bytes.NewReader(data[MagicNumberSize:]))
+}
+
+func headerError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// newPasswordHeader creates header whose key is derived from password and a random salt
+func newPasswordHeader(password string, options *EncryptOptions) (*headerV2, []byte, error) {
+	if password == "" {
+		return nil, nil, errors.New("password is empty")
+	}
+	var salt [saltSize]byte
+	_, _ = rand.Read(salt[:])
+	key, err := options.KDF.DeriveKey([]byte(password), salt[:], KeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	params := options.KDF.Params()
+	if len(params) > math.MaxUint8 {
+		return nil, nil, fmt.Errorf("kdf params are too long: %d", len(params))
+	}
+	// data is not written if it cannot be decrypted because of limits of params
+	if _, err = decodeKDF(options.KDF.ID(), params); err != nil {
+		return nil, nil, err
+	}
+	block := []byte{byte(options.KDF.ID()), byte(len(params))}
+	block = append(block, params...)
+	block = append(block, salt[:]...)
+	h := &headerV2{
+		cipher:   options.Cipher,
+		keyType:  keyTypePasswordKDF,
+		keyBlock: append(block, keyCheck(key)...),
+	}
+	_, _ = rand.Read(h.noncePrefix[:])
+	return h, key, nil
+}
+
+// passwordKDF parses password key block
+func (h *headerV2) passwordKDF() (kdf KDF, salt, check []byte, err error) {
+	b := h.keyBlock
+	switch h.keyType {
+	case keyTypePassword:
+		kdf = &Argon2idKDF{Time: 1, Memory: 128, Threads: 1}
+	case keyTypePasswordKDF:
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil, nil, nil, ErrKey
+		}
+		kdf, err = decodeKDF(KDFID(b[0]), b[2:2+b[1]])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		b = b[2+b[1]:]
+	default:
+		return nil, nil, nil, ErrKey
+	}
+	if len(b) != saltSize+KeyHashSize {
+		return nil, nil, nil, ErrKey
+	}
+	return kdf, b[:saltSize:saltSize], b[saltSize:], nil
+}
+
+func (h *headerV2) passwordKey(password string) ([]byte, error) {
+	if password == "" {
+		return nil, ErrKey
+	}
+	kdf, salt, check, err := h.passwordKDF()
+	if err != nil {
+		return nil, err
+	}
+	key, err := kdf.DeriveKey([]byte(password), salt, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(keyCheck(key), check) {
+		return nil, ErrKey
+	}
+	return key, nil
+}
+
+func keyCheck(key []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte("xsecurity key check"))
+	return m.Sum(nil)[:KeyHashSize]
+}
+
+type nonceSequence struct {
+	nonce   [nonceSize]byte
+	counter uint32
+	done    bool
+}
+
+func newNonceSequence(prefix [noncePrefixSize]byte) *nonceSequence {
+	s := &nonceSequence{}
+	copy(s.nonce[:], prefix[:])
+	return s
+}
+
+func (s *nonceSequence) next(last bool) ([]byte, error) {
+	if s.done {
+		return nil, fmt.Errorf("stream is finished")
+	}
+	binary.BigEndian.PutUint32(s.nonce[noncePrefixSize:], s.counter)
+	if last {
+		s.nonce[nonceSize-1] = 1
+		s.done = true
+	} else {
+		if s.counter == math.MaxUint32 {
+			return nil, fmt.Errorf("too many chunks")
+		}
+		s.counter++
+	}
+	return s.nonce[:], nil
+}
+
+// chunkNonce returns nonce of chunk at index, it's used for random access
+func chunkNonce(prefix [noncePrefixSize]byte, index uint32, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, prefix[:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+// plainSizeV2 returns plaintext size of payload which doesn't contain header
+func plainSizeV2(payloadSize int64) (int64, error) {
+	chunks := payloadSize / encryptedChunkSize
+	rem := payloadSize % encryptedChunkSize
+	if rem < tagSize {
+		return 0, ErrTruncated
+	}
+	return chunks*ChunkSize + rem - tagSize, nil
+}
+
+// chunkSealer seals plaintext chunks and writes them into w
+type chunkSealer struct {
+	w      io.Writer
+	header []byte
+	ad     []byte
+	aead   cipher.AEAD
+	nonces *nonceSequence
+	buf    []byte
+}
+
+func newChunkSealer(w io.Writer, h *headerV2, key []byte) (*chunkSealer, error) {
+	aead, err := newAEAD(h.cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkSealer{
+		w:      w,
+		header: h.encode(),
+		ad:     h.additionalData(),
+		aead:   aead,
+		nonces: newNonceSequence(h.noncePrefix),
+		buf:    make([]byte, 0, encryptedChunkSize),
+	}, nil
+}
+
+func (s *chunkSealer) Write(p []byte) (int, error) {
+	if err := s.writeHeader(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for len(p) > 0 {
+		m := min(ChunkSize-len(s.buf), len(p))
+		s.buf = append(s.buf, p[:m]...)
+		p = p[m:]
+		if len(s.buf) == ChunkSize {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// Close seals the last chunk
+func (s *chunkSealer) Close() error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	return s.flush(true)
+}
+
+func (s *chunkSealer) writeHeader() error {
+	if len(s.header) == 0 {
+		return nil
+	}
+	if _, err := s.w.Write(s.header); err != nil {
+		return fmt.Errorf("cannot write header: %w", err)
+	}
+	s.header = nil
+	return nil
+}
+
+func (s *chunkSealer) flush(last bool) error {
+	nonce, err := s.nonces.next(last)
+	if err != nil {
+		return err
+	}
+	s.buf = s.aead.Seal(s.buf[:0], nonce, s.buf, s.ad)
+	_, err = s.w.Write(s.buf)
+	s.buf = s.buf[:0]
+	if err != nil {
+		return fmt.Errorf("cannot write: %w", err)
+	}
+	return nil
+}
+
+// chunkOpener reads chunks from r and opens them
+type chunkOpener struct {
+	r      io.Reader
+	ad     []byte
+	aead   cipher.AEAD
+	nonces *nonceSequence
+	buf    []byte
+	plain  []byte
+	err    error
+}
+
+func newChunkOpener(r io.Reader, h *headerV2, key []byte) (*chunkOpener, error) {
+	aead, err := newAEAD(h.cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkOpener{
+		r:      r,
+		ad:     h.additionalData(),
+		aead:   aead,
+		nonces: newNonceSequence(h.noncePrefix),
+		buf:    make([]byte, encryptedChunkSize),
+	}, nil
+}
+
+func (o *chunkOpener) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.err != nil {
+			return 0, o.err
+		}
+		o.plain, o.err = o.next()
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+func (o *chunkOpener) next() ([]byte, error) {
+	n, err := io.ReadFull(o.r, o.buf)
+	switch err {
+	case nil:
+		plain, err := openChunk(o.aead, o.nonces, o.ad, o.buf, false)
+		return plain, err
+	case io.EOF, io.ErrUnexpectedEOF:
+		if n < tagSize {
+			return nil, ErrTruncated
+		}
+		plain, err := openChunk(o.aead, o.nonces, o.ad, o.buf[:n], true)
+		if err != nil {
+			return nil, err
+		}
+		return plain, io.EOF
+	default:
+		return nil, err
+	}
+}
+
+func openChunk(aead cipher.AEAD, nonces *nonceSequence, ad, chunk []byte, last bool) ([]byte, error) {
+	nonce, err := nonces.next(last)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(chunk[:0], nonce, chunk, ad)
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return plain, nil
+}
+
+// openChunksInPlace opens all chunks in data and compacts plaintext into the front of data
+func openChunksInPlace(aead cipher.AEAD, nonces *nonceSequence, ad, data []byte) ([]byte, error) {
+	offset := 0
+	for i := 0; ; i += encryptedChunkSize {
+		last := len(data)-i < encryptedChunkSize
+		if last && len(data)-i < tagSize {
+			return nil, ErrTruncated
+		}
+		end := min(i+encryptedChunkSize, len(data))
+		plain, err := openChunk(aead, nonces, ad, data[i:end], last)
+		if err != nil {
+			return nil, err
+		}
+		offset += copy(data[offset:], plain)
+		if last {
+			return data[:offset], nil
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package xsecurity
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"go.olapie.com/x/xtest"
+)
+
+// encryptV1 encrypts data in legacy MagicNumberV1 format
+func encryptV1(raw []byte, password string) []byte {
+	stream := getCipherStream(password)
+	data := append([]byte(MagicNumberV1), stream.keyHash[:]...)
+	enc := make([]byte, len(raw))
+	stream.XORKeyStream(enc, raw)
+	return append(data, enc...)
+}
+
+func TestEncrypt_V2(t *testing.T) {
+	for _, c := range []Cipher{AES256GCM, ChaCha20Poly1305} {
+		t.Run(c.String(), func(t *testing.T) {
+			for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, 2*ChunkSize + 5} {
+				raw := xtest.RandomBytes(size)
+				enc, err := Encrypt(raw, "123", func(options *EncryptOptions) {
+					options.Cipher = c
+				})
+				xtest.NoError(t, err)
+				xtest.Equal(t, MagicNumberV2, string(enc[:MagicNumberSize]))
+				xtest.True(t, IsEncrypted(enc))
+				xtest.True(t, ValidatePassword(enc, "123"))
+				xtest.False(t, ValidatePassword(enc, "1234"))
+
+				dec, err := Decrypt(enc, "123")
+				xtest.NoError(t, err)
+				xtest.True(t, bytes.Equal(raw, dec))
+
+				_, err = Decrypt(enc, "1234")
+				xtest.Equal(t, ErrKey, err)
+
+				dec, err = DecryptInPlace(bytes.Clone(enc), "123")
+				xtest.NoError(t, err)
+				xtest.True(t, bytes.Equal(raw, dec))
+			}
+		})
+	}
+}
+
+func TestEncrypt_UniqueCiphertext(t *testing.T) {
+	raw := xtest.RandomBytes(100)
+	enc1, err := Encrypt(raw, "123")
+	xtest.NoError(t, err)
+	enc2, err := Encrypt(raw, "123")
+	xtest.NoError(t, err)
+	xtest.NotEqual(t, enc1, enc2)
+}
+
+func TestDecrypt_Tampered(t *testing.T) {
+	raw := xtest.RandomBytes(2*ChunkSize + 100)
+	enc, err := Encrypt(raw, "123")
+	xtest.NoError(t, err)
+
+	for _, i := range []int{len(enc) - 1, len(enc) - ChunkSize, len(enc) / 2} {
+		tampered := bytes.Clone(enc)
+		tampered[i] ^= 1
+		_, err = Decrypt(tampered, "123")
+		xtest.Equal(t, ErrAuth, err)
+		_, err = DecryptInPlace(tampered, "123")
+		xtest.Equal(t, ErrAuth, err)
+	}
+}
+
+func TestChunkSealer_AdditionalData(t *testing.T) {
+	h, key, err := newPasswordHeader("123", newEncryptOptions(nil))
+	xtest.NoError(t, err)
+	enc := &bytes.Buffer{}
+	sealer, err := newChunkSealer(enc, h, key)
+	xtest.NoError(t, err)
+	_, err = sealer.Write([]byte("hello"))
+	xtest.NoError(t, err)
+	xtest.NoError(t, sealer.Close())
+
+	aead, err := newAEAD(h.cipher, key)
+	xtest.NoError(t, err)
+	chunk := enc.Bytes()[h.size():]
+	_, err = openChunk(aead, newNonceSequence(h.noncePrefix), nil, bytes.Clone(chunk), true)
+	xtest.Equal(t, ErrAuth, err)
+	plain, err := openChunk(aead, newNonceSequence(h.noncePrefix), h.additionalData(), chunk, true)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "hello", string(plain))
+}
+
+func TestDecrypt_Truncated(t *testing.T) {
+	raw := xtest.RandomBytes(2 * ChunkSize)
+	enc, err := Encrypt(raw, "123")
+	xtest.NoError(t, err)
+
+	headerSize := len(enc) - 2*encryptedChunkSize - tagSize
+	for _, size := range []int{headerSize - 1, headerSize, headerSize + encryptedChunkSize, len(enc) - 1} {
+		_, err = Decrypt(enc[:size], "123")
+		xtest.Error(t, err)
+		_, err = DecryptInPlace(bytes.Clone(enc[:size]), "123")
+		xtest.Error(t, err)
+	}
+
+	// drop the last chunk
+	_, err = Decrypt(enc[:len(enc)-tagSize], "123")
+	xtest.Equal(t, ErrTruncated, err)
+}
+
+func TestDecrypt_V1(t *testing.T) {
+	raw := xtest.RandomBytes(ChunkSize + 10)
+	enc := encryptV1(raw, "123")
+	xtest.True(t, IsEncrypted(enc))
+	xtest.True(t, ValidatePassword(enc, "123"))
+
+	dec, err := Decrypt(enc, "123")
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, dec)
+
+	_, err = Decrypt(enc, "1234")
+	xtest.Equal(t, ErrKey, err)
+
+	w := &bytes.Buffer{}
+	dw := NewDecryptedWriter(w, "123")
+	_, err = io.Copy(dw, bytes.NewReader(enc))
+	xtest.NoError(t, err)
+	xtest.NoError(t, dw.Close())
+	xtest.Equal(t, raw, w.Bytes())
+
+	reEnc, err := ReEncrypt(enc, "123", "456")
+	xtest.NoError(t, err)
+	xtest.Equal(t, MagicNumberV2, string(reEnc[:MagicNumberSize]))
+	dec, err = Decrypt(reEnc, "456")
+	xtest.NoError(t, err)
+	xtest.Equal(t, raw, dec)
+}
+
+func TestDecryptedWriter_V2(t *testing.T) {
+	raw := xtest.RandomBytes(3*ChunkSize + 1)
+	enc, err := Encrypt(raw, "123")
+	xtest.NoError(t, err)
+
+	for _, step := range []int{1 << 10, 7, encryptedChunkSize} {
+		w := &bytes.Buffer{}
+		dw := NewDecryptedWriter(w, "123")
+		for i := 0; i < len(enc); i += step {
+			_, err = dw.Write(enc[i:min(i+step, len(enc))])
+			xtest.NoError(t, err)
+		}
+		xtest.NoError(t, dw.Close())
+		xtest.Equal(t, raw, w.Bytes())
+	}
+
+	dw := NewDecryptedWriter(&bytes.Buffer{}, "123")
+	_, err = dw.Write(enc[:len(enc)-1])
+	xtest.NoError(t, err)
+	xtest.Equal(t, ErrAuth, dw.Close())
+}
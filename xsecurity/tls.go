@@ -1,8 +1,14 @@
 package xsecurity
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 )
 
 func NewTLSConfig(optFns ...func(options *CertificateOptions)) (*tls.Config, error) {
@@ -18,3 +24,193 @@ func NewTLSConfig(optFns ...func(options *CertificateOptions)) (*tls.Config, err
 
 	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
 }
+
+type TLSOptions struct {
+	Certificates []tls.Certificate
+	// Reloader provides certificate which is reloaded after its files are changed, it takes precedence over Certificates
+	Reloader *CertificateReloader
+	// CRLs are DER encoded revocation lists issued by CAs in the bundle. Peers with revoked certificates are rejected
+	CRLs       [][]byte
+	ServerName string
+}
+
+// NewServerTLSConfig creates config for mTLS servers which verifies client certificates with clientCABundle
+func NewServerTLSConfig(clientCABundle []byte, optFns ...func(options *TLSOptions)) (*tls.Config, error) {
+	options, pool, err := newTLSOptions(clientCABundle, optFns)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: options.Certificates,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	if options.Reloader != nil {
+		config.GetCertificate = options.Reloader.GetCertificate
+	}
+	if config.VerifyPeerCertificate, err = newCRLVerifier(options.CRLs); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// NewClientTLSConfig creates config for clients which verifies server certificates with rootCABundle
+// Client certificate in options is presented to servers which require mTLS
+func NewClientTLSConfig(rootCABundle []byte, optFns ...func(options *TLSOptions)) (*tls.Config, error) {
+	options, pool, err := newTLSOptions(rootCABundle, optFns)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: options.Certificates,
+		RootCAs:      pool,
+		ServerName:   options.ServerName,
+	}
+	if options.Reloader != nil {
+		config.GetClientCertificate = options.Reloader.GetClientCertificate
+	}
+	if config.VerifyPeerCertificate, err = newCRLVerifier(options.CRLs); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func newTLSOptions(caBundle []byte, optFns []func(options *TLSOptions)) (*TLSOptions, *x509.CertPool, error) {
+	options := &TLSOptions{}
+	for _, fn := range optFns {
+		fn(options)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return nil, nil, errors.New("no certificate in CA bundle")
+	}
+	return options, pool, nil
+}
+
+// newCRLVerifier returns func for tls.Config.VerifyPeerCertificate which rejects revoked certificates in verified chains
+func newCRLVerifier(crls [][]byte) (func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error, error) {
+	if len(crls) == 0 {
+		return nil, nil
+	}
+	lists := make([]*x509.RevocationList, len(crls))
+	for i, der := range crls {
+		l, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParseRevocationList: %w", err)
+		}
+		lists[i] = l
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for i := 0; i < len(chain)-1; i++ {
+				if isRevoked(lists, chain[i], chain[i+1]) {
+					return fmt.Errorf("certificate is revoked: %s", chain[i].SerialNumber)
+				}
+			}
+		}
+		return nil
+	}, nil
+}
+
+func isRevoked(lists []*x509.RevocationList, cert, issuer *x509.Certificate) bool {
+	for _, l := range lists {
+		if !bytes.Equal(l.RawIssuer, cert.RawIssuer) || l.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, e := range l.RevokedCertificateEntries {
+			if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CertificateReloader loads certificate from PEM files, and reloads it after files are changed
+// Files are checked at most once per CheckInterval when certificate is requested by TLS handshakes
+type CertificateReloader struct {
+	CheckInterval time.Duration
+
+	certFile  string
+	keyFile   string
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		CheckInterval: 10 * time.Second,
+		certFile:      certFile,
+		keyFile:       keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads certificate files immediately
+func (r *CertificateReloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls.LoadX509KeyPair: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// Certificate returns the current certificate. Files are reloaded if they are changed, and the old certificate is kept if reload fails
+func (r *CertificateReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	cert, modTime, checkedAt := r.cert, r.modTime, r.checkedAt
+	r.mu.RUnlock()
+	if time.Since(checkedAt) < r.CheckInterval {
+		return cert
+	}
+
+	r.mu.Lock()
+	r.checkedAt = time.Now()
+	r.mu.Unlock()
+	if t, err := r.latestModTime(); err == nil && !t.Equal(modTime) {
+		if err = r.Reload(); err == nil {
+			r.mu.RLock()
+			cert = r.cert
+			r.mu.RUnlock()
+		}
+	}
+	return cert
+}
+
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+func (r *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("os.Stat: %w", err)
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
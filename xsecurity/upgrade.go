@@ -0,0 +1,94 @@
+package xsecurity
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// NeedsUpgrade reports whether data is not encrypted in MagicNumberV2 format with the cipher and KDF of options
+func NeedsUpgrade(data []byte, optFns ...func(options *EncryptOptions)) bool {
+	if len(data) < MagicNumberSize || string(data[:MagicNumberSize]) != MagicNumberV2 {
+		return true
+	}
+	h, err := parseHeaderV2(data)
+	if err != nil {
+		return true
+	}
+	return h.needsUpgrade(newEncryptOptions(optFns))
+}
+
+// NeedsFileUpgrade is the file version of NeedsUpgrade
+func NeedsFileUpgrade(filename string, optFns ...func(options *EncryptOptions)) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, fmt.Errorf("os.Open: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	var magic [MagicNumberSize]byte
+	if _, err = io.ReadFull(f, magic[:]); err != nil {
+		return false, headerError(err)
+	}
+	switch string(magic[:]) {
+	case MagicNumberV1:
+		return true, nil
+	case MagicNumberV2:
+		h, err := readHeaderV2(f)
+		if err != nil {
+			return false, err
+		}
+		return h.needsUpgrade(newEncryptOptions(optFns)), nil
+	default:
+		return false, ErrKey
+	}
+}
+
+// Upgrade re-encrypts data with the cipher and KDF of options if it's necessary
+func Upgrade(data []byte, password string, optFns ...func(options *EncryptOptions)) ([]byte, error) {
+	if !NeedsUpgrade(data, optFns...) {
+		if !ValidatePassword(data, password) {
+			return nil, ErrKey
+		}
+		return data, nil
+	}
+	return ReEncrypt(data, password, password, optFns...)
+}
+
+// UpgradeFile re-encrypts file with the cipher and KDF of options if it's necessary
+// It returns true if file is rewritten
+func UpgradeFile(filename, password string, optFns ...func(options *EncryptOptions)) (bool, error) {
+	needed, err := NeedsFileUpgrade(filename, optFns...)
+	if err != nil {
+		return false, err
+	}
+	if !needed {
+		if !ValidateFilePassword(filename, password) {
+			return false, ErrKey
+		}
+		return false, nil
+	}
+
+	tmp := filename + ".upgrading"
+	err = ReEncryptFile(SF(filename), DF(tmp), password, password, optFns...)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	if err = os.Rename(tmp, filename); err != nil {
+		_ = os.Remove(tmp)
+		return false, fmt.Errorf("os.Rename: %w", err)
+	}
+	return true, nil
+}
+
+func (h *headerV2) needsUpgrade(options *EncryptOptions) bool {
+	if h.cipher != options.Cipher {
+		return true
+	}
+	kdf, _, _, err := h.passwordKDF()
+	if err != nil {
+		return true
+	}
+	return h.keyType != keyTypePasswordKDF || !equalKDF(kdf, options.KDF)
+}
@@ -0,0 +1,340 @@
+package xsql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var _regexpColumn = regexp.MustCompile(`^[_a-zA-Z]\w*(\.[_a-zA-Z]\w*)?$`)
+
+// Cond is a condition in WHERE clause, it's composed by Eq, In, Between, And, Or etc.
+type Cond interface {
+	writeTo(b *strings.Builder, args []any) []any
+}
+
+type compareCond struct {
+	column string
+	op     string
+	value  any
+}
+
+func (c *compareCond) writeTo(b *strings.Builder, args []any) []any {
+	b.WriteString(c.column)
+	b.WriteString(c.op)
+	b.WriteString("?")
+	return append(args, c.value)
+}
+
+func newCompareCond(column, op string, value any) Cond {
+	mustColumn(column)
+	return &compareCond{column: column, op: op, value: value}
+}
+
+func Eq(column string, value any) Cond {
+	return newCompareCond(column, " = ", value)
+}
+
+func Ne(column string, value any) Cond {
+	return newCompareCond(column, " <> ", value)
+}
+
+func Gt(column string, value any) Cond {
+	return newCompareCond(column, " > ", value)
+}
+
+func Gte(column string, value any) Cond {
+	return newCompareCond(column, " >= ", value)
+}
+
+func Lt(column string, value any) Cond {
+	return newCompareCond(column, " < ", value)
+}
+
+func Lte(column string, value any) Cond {
+	return newCompareCond(column, " <= ", value)
+}
+
+func Like(column string, pattern string) Cond {
+	return newCompareCond(column, " LIKE ", pattern)
+}
+
+type inCond struct {
+	column string
+	not    bool
+	values []any
+}
+
+func (c *inCond) writeTo(b *strings.Builder, args []any) []any {
+	if len(c.values) == 0 {
+		// IN () is invalid syntax
+		if c.not {
+			b.WriteString("1 = 1")
+		} else {
+			b.WriteString("1 = 0")
+		}
+		return args
+	}
+	b.WriteString(c.column)
+	if c.not {
+		b.WriteString(" NOT IN (")
+	} else {
+		b.WriteString(" IN (")
+	}
+	b.WriteString(strings.Repeat("?, ", len(c.values)-1))
+	b.WriteString("?)")
+	return append(args, c.values...)
+}
+
+// In matches column with any of values. No row is matched if values is empty
+func In(column string, values ...any) Cond {
+	mustColumn(column)
+	return &inCond{column: column, values: values}
+}
+
+func NotIn(column string, values ...any) Cond {
+	mustColumn(column)
+	return &inCond{column: column, not: true, values: values}
+}
+
+type betweenCond struct {
+	column   string
+	from, to any
+}
+
+func (c *betweenCond) writeTo(b *strings.Builder, args []any) []any {
+	b.WriteString(c.column)
+	b.WriteString(" BETWEEN ? AND ?")
+	return append(args, c.from, c.to)
+}
+
+// Between matches column in range [from, to]
+func Between(column string, from, to any) Cond {
+	mustColumn(column)
+	return &betweenCond{column: column, from: from, to: to}
+}
+
+type nullCond struct {
+	column string
+	not    bool
+}
+
+func (c *nullCond) writeTo(b *strings.Builder, args []any) []any {
+	b.WriteString(c.column)
+	if c.not {
+		b.WriteString(" IS NOT NULL")
+	} else {
+		b.WriteString(" IS NULL")
+	}
+	return args
+}
+
+func IsNull(column string) Cond {
+	mustColumn(column)
+	return &nullCond{column: column}
+}
+
+func IsNotNull(column string) Cond {
+	mustColumn(column)
+	return &nullCond{column: column, not: true}
+}
+
+type logicCond struct {
+	op    string
+	conds []Cond
+}
+
+func (c *logicCond) writeTo(b *strings.Builder, args []any) []any {
+	if len(c.conds) == 0 {
+		if c.op == " OR " {
+			b.WriteString("1 = 0")
+		} else {
+			b.WriteString("1 = 1")
+		}
+		return args
+	}
+	if len(c.conds) == 1 {
+		return c.conds[0].writeTo(b, args)
+	}
+	b.WriteString("(")
+	for i, cond := range c.conds {
+		if i > 0 {
+			b.WriteString(c.op)
+		}
+		args = cond.writeTo(b, args)
+	}
+	b.WriteString(")")
+	return args
+}
+
+func newLogicCond(op string, conds []Cond) Cond {
+	l := &logicCond{op: op}
+	for _, c := range conds {
+		if c != nil {
+			l.conds = append(l.conds, c)
+		}
+	}
+	return l
+}
+
+// And matches if all conditions are matched. Nil conditions are ignored
+func And(conds ...Cond) Cond {
+	return newLogicCond(" AND ", conds)
+}
+
+// Or matches if any condition is matched. Nil conditions are ignored
+func Or(conds ...Cond) Cond {
+	return newLogicCond(" OR ", conds)
+}
+
+type notCond struct {
+	cond Cond
+}
+
+func (c *notCond) writeTo(b *strings.Builder, args []any) []any {
+	b.WriteString("NOT (")
+	args = c.cond.writeTo(b, args)
+	b.WriteString(")")
+	return args
+}
+
+func Not(cond Cond) Cond {
+	return &notCond{cond: cond}
+}
+
+type rawCond struct {
+	expr string
+	args []any
+}
+
+func (c *rawCond) writeTo(b *strings.Builder, args []any) []any {
+	b.WriteString("(")
+	b.WriteString(c.expr)
+	b.WriteString(")")
+	return append(args, c.args...)
+}
+
+// Raw is a condition expression with ? placeholders. Write ?? for a literal question mark, see Rebind
+func Raw(expr string, args ...any) Cond {
+	return &rawCond{expr: expr, args: args}
+}
+
+type orderBy struct {
+	column string
+	desc   bool
+}
+
+// Query is WHERE, ORDER BY, LIMIT and OFFSET clauses of a statement
+type Query struct {
+	where    Cond
+	orderBy  []orderBy
+	limit    int
+	offset   int
+	preloads []string
+}
+
+// Where creates query with conditions which are combined with And
+func Where(conds ...Cond) *Query {
+	return (&Query{}).And(conds...)
+}
+
+// And adds conditions to the query
+func (q *Query) And(conds ...Cond) *Query {
+	if q.where != nil {
+		conds = append([]Cond{q.where}, conds...)
+	}
+	switch c := And(conds...).(*logicCond); len(c.conds) {
+	case 0:
+		q.where = nil
+	case 1:
+		q.where = c.conds[0]
+	default:
+		q.where = c
+	}
+	return q
+}
+
+func (q *Query) OrderBy(column string) *Query {
+	mustColumn(column)
+	q.orderBy = append(q.orderBy, orderBy{column: column})
+	return q
+}
+
+func (q *Query) OrderByDesc(column string) *Query {
+	mustColumn(column)
+	q.orderBy = append(q.orderBy, orderBy{column: column, desc: true})
+	return q
+}
+
+// Preload loads relations of selected records by field names. It's ignored by iterators
+func (q *Query) Preload(relations ...string) *Query {
+	q.preloads = append(q.preloads, relations...)
+	return q
+}
+
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+// Build renders clauses with placeholders of driverName, e.g. " WHERE id = $1 ORDER BY name LIMIT 10"
+func (q *Query) Build(driverName string) (string, []any) {
+	query, args := q.build(driverName, true)
+	return Rebind(driverName, query), args
+}
+
+// build renders clauses with ? placeholders, and ORDER BY, LIMIT and OFFSET are skipped if all is false
+func (q *Query) build(driverName string, all bool) (string, []any) {
+	if q == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	var args []any
+	if q.where != nil {
+		b.WriteString(" WHERE ")
+		args = q.where.writeTo(&b, args)
+	}
+	if !all {
+		return b.String(), args
+	}
+	for i, o := range q.orderBy {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(o.column)
+		if o.desc {
+			b.WriteString(" DESC")
+		}
+	}
+	if q.limit > 0 {
+		b.WriteString(" LIMIT ")
+		b.WriteString(strconv.Itoa(q.limit))
+	} else if q.offset > 0 {
+		// OFFSET must follow LIMIT in sqlite and mysql
+		switch {
+		case isSQLite(driverName):
+			b.WriteString(" LIMIT -1")
+		case isMySQL(driverName):
+			b.WriteString(" LIMIT 18446744073709551615")
+		}
+	}
+	if q.offset > 0 {
+		b.WriteString(" OFFSET ")
+		b.WriteString(strconv.Itoa(q.offset))
+	}
+	return b.String(), args
+}
+
+func mustColumn(column string) {
+	if !_regexpColumn.MatchString(column) {
+		panic(fmt.Sprintf("invalid column name: %q", column))
+	}
+}
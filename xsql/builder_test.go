@@ -0,0 +1,61 @@
+package xsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuery_Build(t *testing.T) {
+	q := Where(
+		Eq("name", "tom"),
+		Or(In("status", 1, 2), Between("age", 18, 30)),
+		IsNotNull("email"),
+	).OrderByDesc("created_at").OrderBy("id").Limit(10).Offset(20)
+
+	tests := []struct {
+		driverName string
+		clause     string
+	}{
+		{SQLITE, " WHERE (name = ? AND (status IN (?, ?) OR age BETWEEN ? AND ?) AND email IS NOT NULL) ORDER BY created_at DESC, id LIMIT 10 OFFSET 20"},
+		{POSTGRES, " WHERE (name = $1 AND (status IN ($2, $3) OR age BETWEEN $4 AND $5) AND email IS NOT NULL) ORDER BY created_at DESC, id LIMIT 10 OFFSET 20"},
+	}
+	for _, test := range tests {
+		clause, args := q.Build(test.driverName)
+		if clause != test.clause {
+			t.Fatalf("%s: %s", test.driverName, clause)
+		}
+		if !reflect.DeepEqual(args, []any{"tom", 1, 2, 18, 30}) {
+			t.Fatal(args)
+		}
+	}
+}
+
+func TestQuery_Build_Edge(t *testing.T) {
+	tests := []struct {
+		q      *Query
+		clause string
+	}{
+		{Where(), ""},
+		{Where(In("id")), " WHERE 1 = 0"},
+		{Where(Not(Or())), " WHERE NOT (1 = 0)"},
+		{Where(Raw("name = '?' OR id = ?", 1)), " WHERE (name = '?' OR id = $1)"},
+		{Where(Raw("data ?? 'a' AND data ??| ? AND data ??& ?", "b", "c")), " WHERE (data ? 'a' AND data ?| $1 AND data ?& $2)"},
+		{(&Query{}).Offset(5), " OFFSET 5"},
+	}
+	for _, test := range tests {
+		if clause, _ := test.q.Build(POSTGRES); clause != test.clause {
+			t.Fatal(clause)
+		}
+	}
+
+	if clause, _ := (&Query{}).Offset(5).Build(SQLITE); clause != " LIMIT -1 OFFSET 5" {
+		t.Fatal(clause)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("should panic with invalid column")
+		}
+	}()
+	Eq("id = 1 OR 1", 1)
+}
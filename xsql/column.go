@@ -5,6 +5,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 	
 	"go.olapie.com/x/xname"
@@ -66,6 +67,22 @@ type columnInfo struct {
 
 	nullableNames []string
 
+	//columns with single-column index, tagged with index or unique
+	indexNames  []string
+	uniqueNames []string
+
+	//field types of columns
+	types []reflect.Type
+
+	//optimistic locking column name, tagged with version
+	versionName string
+
+	//soft delete column name, tagged with deleted_at
+	deletedAtName string
+
+	//relations by field name, tagged with has_many or belongs_to
+	relations map[string]*relationInfo
+
 	//for speed
 	notPKNames []string
 	notAINames []string
@@ -112,6 +129,14 @@ func parseColumnInfo(typ reflect.Type) *columnInfo {
 			continue
 		}
 
+		if rel := parseRelation(f, tag); rel != nil {
+			if info.relations == nil {
+				info.relations = make(map[string]*relationInfo)
+			}
+			info.relations[f.Name] = rel
+			continue
+		}
+
 		isJSON := strings.Contains(tag, "json")
 		nullable := strings.Contains(tag, "nullable")
 
@@ -123,8 +148,24 @@ func parseColumnInfo(typ reflect.Type) *columnInfo {
 		}
 
 		var name string
+		var isIndex, isUnique, isVersion, isDeletedAt bool
 		if len(tag) > 0 {
 			strs := strings.Split(tag, ",")
+			for i, s := range strs {
+				switch s = strings.TrimSpace(s); {
+				case i == 0 && (s == "index" || s == "version" || s == "deleted_at"):
+					// they were valid column names before they became options, so they're options only after the name,
+					// e.g. sql:"name,index" or sql:",version"
+				case s == "index":
+					isIndex = true
+				case s == "unique":
+					isUnique = true
+				case s == "version":
+					isVersion = true
+				case s == "deleted_at":
+					isDeletedAt = true
+				}
+			}
 			if len(strs) > 0 {
 				if _, ok := _sqlKeywords[strs[0]]; !ok && _regexpVariable.MatchString(strs[0]) {
 					name = strs[0]
@@ -174,6 +215,33 @@ func parseColumnInfo(typ reflect.Type) *columnInfo {
 		if nullable {
 			info.nullableNames = append(info.nullableNames, name)
 		}
+
+		if isUnique {
+			info.uniqueNames = append(info.uniqueNames, name)
+		} else if isIndex {
+			info.indexNames = append(info.indexNames, name)
+		}
+		info.types = append(info.types, f.Type)
+
+		if isVersion {
+			if len(info.versionName) > 0 {
+				panic("duplicate version")
+			}
+			if !isIntegerType(f.Type) {
+				panic("version must be integer: " + f.Type.String())
+			}
+			info.versionName = name
+		}
+
+		if isDeletedAt {
+			if len(info.deletedAtName) > 0 {
+				panic("duplicate deleted_at")
+			}
+			if f.Type.Kind() != reflect.Bool && (!isIntegerType(f.Type) || f.Type.Bits() < 64) {
+				panic("deleted_at must be bool or 64-bit integer: " + f.Type.String())
+			}
+			info.deletedAtName = name
+		}
 	}
 
 	if len(info.pkNames) == 0 {
@@ -202,6 +270,24 @@ func parseColumnInfo(typ reflect.Type) *columnInfo {
 	return info
 }
 
+// deletedValue returns value of deleted_at column for soft deleted records: true or unix milliseconds
+func (c *columnInfo) deletedValue() any {
+	if c.types[IndexOfString(c.names, c.deletedAtName)].Kind() == reflect.Bool {
+		return true
+	}
+	return time.Now().UnixMilli()
+}
+
+func isIntegerType(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
 func isSupportType(typ reflect.Type) bool {
 	if typ == nil {
 		return false
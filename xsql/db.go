@@ -1,9 +1,11 @@
 package xsql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"reflect"
+	"sync/atomic"
 )
 
 type DBOptions struct {
@@ -17,6 +19,12 @@ var _tableNamerType = reflect.TypeOf((*tableNamer)(nil)).Elem()
 type DB struct {
 	db         *sql.DB
 	driverName string
+
+	debug        bool
+	interceptors []Interceptor
+
+	replicas    []*replica
+	nextReplica atomic.Uint64
 }
 
 // NewDB opens database
@@ -38,100 +46,142 @@ func (d *DB) DB() *sql.DB {
 }
 
 func (d *DB) Exec(query string, args ...any) (sql.Result, error) {
-	return d.db.Exec(query, args...)
+	return d.ExecContext(context.Background(), query, args...)
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.exec(ctx, d.db, query, args)
 }
 
 func (d *DB) MustExec(query string, args ...any) {
-	_, err := d.db.Exec(query, args...)
+	_, err := d.exec(context.Background(), d.db, query, args)
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (d *DB) Begin() (*Tx, error) {
-	tx, err := d.db.Begin()
+	return d.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction. ctx is used until the transaction is committed or rolled back
+func (d *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := d.db.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Tx{
 		tx:         tx,
+		db:         d,
 		driverName: d.driverName,
 	}, nil
 }
 
 func (d *DB) Close() error {
-	return d.db.Close()
+	return errors.Join(d.db.Close(), d.closeReplicas())
 }
 
+// Table returns table by name or record. Soft delete is applied to Delete and Count only if table is created by record
 func (d *DB) Table(nameOrRecord any) *Table {
-	name, ok := nameOrRecord.(string)
-	if !ok {
-		name = getTableName(nameOrRecord)
-	}
-
-	return &Table{
-		exe:        d.db,
-		driverName: d.driverName,
-		name:       name,
-	}
+	return newTable(d, d.db, nameOrRecord)
 }
 
 func (d *DB) Insert(record any) error {
-	return d.Table(getTableName(record)).Insert(record)
+	return d.InsertContext(context.Background(), record)
+}
+
+func (d *DB) InsertContext(ctx context.Context, record any) error {
+	return d.Table(getTableName(record)).InsertContext(ctx, record)
 }
 
 func (d *DB) BatchInsert(values any) error {
-	l := reflect.ValueOf(values)
-	if l.Kind() != reflect.Slice {
-		return errors.New("not slice")
-	}
+	return d.BatchInsertContext(context.Background(), values)
+}
 
-	tx, err := d.Begin()
-	for i := 0; i < l.Len(); i++ {
-		err = tx.Insert(l.Index(i).Interface())
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-	return tx.Commit()
+// BatchInsertContext inserts values with multi-row statements in a transaction
+func (d *DB) BatchInsertContext(ctx context.Context, values any) error {
+	return d.inTx(ctx, func(tx *Tx) error {
+		return tx.BatchInsertContext(ctx, values)
+	})
+}
+
+func (d *DB) Upsert(record any, optFns ...func(options *UpsertOptions)) error {
+	return d.UpsertContext(context.Background(), record, optFns...)
+}
+
+func (d *DB) UpsertContext(ctx context.Context, record any, optFns ...func(options *UpsertOptions)) error {
+	return d.Table(getTableName(record)).UpsertContext(ctx, record, optFns...)
+}
+
+func (d *DB) BatchUpsert(values any, optFns ...func(options *UpsertOptions)) error {
+	return d.BatchUpsertContext(context.Background(), values, optFns...)
+}
+
+// BatchUpsertContext upserts values with multi-row statements in a transaction
+func (d *DB) BatchUpsertContext(ctx context.Context, values any, optFns ...func(options *UpsertOptions)) error {
+	return d.inTx(ctx, func(tx *Tx) error {
+		return tx.BatchUpsertContext(ctx, values, optFns...)
+	})
 }
 
 func (d *DB) Update(record any) error {
-	return d.Table(getTableName(record)).Update(record)
+	return d.UpdateContext(context.Background(), record)
+}
+
+func (d *DB) UpdateContext(ctx context.Context, record any) error {
+	return d.Table(getTableName(record)).UpdateContext(ctx, record)
 }
 
 func (d *DB) BatchUpdate(values any) error {
-	l := reflect.ValueOf(values)
-	if l.Kind() != reflect.Slice {
-		return errors.New("not slice")
-	}
+	return d.BatchUpdateContext(context.Background(), values)
+}
 
-	tx, err := d.Begin()
-	for i := 0; i < l.Len(); i++ {
-		err = tx.Update(l.Index(i).Interface())
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-	return tx.Commit()
+func (d *DB) BatchUpdateContext(ctx context.Context, values any) error {
+	return d.batch(ctx, values, (*Tx).UpdateContext)
 }
 
 func (d *DB) Save(record any) error {
-	return d.Table(getTableName(record)).Save(record)
+	return d.SaveContext(context.Background(), record)
+}
+
+func (d *DB) SaveContext(ctx context.Context, record any) error {
+	return d.Table(getTableName(record)).SaveContext(ctx, record)
 }
 
 func (d *DB) MultiSave(values any) error {
+	return d.MultiSaveContext(context.Background(), values)
+}
+
+func (d *DB) MultiSaveContext(ctx context.Context, values any) error {
+	return d.batch(ctx, values, (*Tx).SaveContext)
+}
+
+func (d *DB) inTx(ctx context.Context, fn func(tx *Tx) error) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// batch applies fn to every element of values in a transaction
+func (d *DB) batch(ctx context.Context, values any, fn func(tx *Tx, ctx context.Context, record any) error) error {
 	l := reflect.ValueOf(values)
 	if l.Kind() != reflect.Slice {
 		return errors.New("not slice")
 	}
 
-	tx, err := d.Begin()
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
 	for i := 0; i < l.Len(); i++ {
-		err = tx.Save(l.Index(i).Interface())
+		err = fn(tx, ctx, l.Index(i).Interface())
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -141,9 +191,33 @@ func (d *DB) MultiSave(values any) error {
 }
 
 func (d *DB) Select(records any, where string, args ...any) error {
-	return d.Table(getTableNameBySlice(records)).Select(records, where, args...)
+	return d.SelectContext(context.Background(), records, where, args...)
+}
+
+func (d *DB) SelectContext(ctx context.Context, records any, where string, args ...any) error {
+	return d.Table(getTableNameBySlice(records)).SelectContext(ctx, records, where, args...)
 }
 
 func (d *DB) SelectOne(record any, where string, args ...any) error {
-	return d.Table(getTableName(record)).SelectOne(record, where, args...)
+	return d.SelectOneContext(context.Background(), record, where, args...)
+}
+
+func (d *DB) SelectOneContext(ctx context.Context, record any, where string, args ...any) error {
+	return d.Table(getTableName(record)).SelectOneContext(ctx, record, where, args...)
+}
+
+func (d *DB) SelectBy(records any, q *Query) error {
+	return d.SelectByContext(context.Background(), records, q)
+}
+
+func (d *DB) SelectByContext(ctx context.Context, records any, q *Query) error {
+	return d.Table(getTableNameBySlice(records)).SelectByContext(ctx, records, q)
+}
+
+func (d *DB) SelectOneBy(record any, q *Query) error {
+	return d.SelectOneByContext(context.Background(), record, q)
+}
+
+func (d *DB) SelectOneByContext(ctx context.Context, record any, q *Query) error {
+	return d.Table(getTableName(record)).SelectOneByContext(ctx, record, q)
 }
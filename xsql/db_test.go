@@ -0,0 +1,20 @@
+package xsql
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+)
+
+// newTestDB returns a sqlite database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	db, err := NewDB("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
@@ -0,0 +1,61 @@
+package xsql
+
+import (
+	"strconv"
+	"strings"
+)
+
+func isPostgres(driverName string) bool {
+	switch driverName {
+	case POSTGRES, "pgx", "pgx/v5":
+		return true
+	default:
+		return false
+	}
+}
+
+func isSQLite(driverName string) bool {
+	switch driverName {
+	case SQLITE, "sqlite3":
+		return true
+	default:
+		return false
+	}
+}
+
+func isMySQL(driverName string) bool {
+	return driverName == MYSQL
+}
+
+// Rebind converts ? placeholders in query to the style of driverName, e.g. $1, $2 for postgres
+// Question marks in quoted strings or identifiers are not converted, and ?? is converted to ? for postgres,
+// so that operators like ?, ?| and ?& of jsonb can be written as ??, ??| and ??& in where strings
+func Rebind(driverName, query string) string {
+	if !isPostgres(driverName) || strings.IndexByte(query, '?') < 0 {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?' && i+1 < len(query) && query[i+1] == '?':
+			i++
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
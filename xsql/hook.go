@@ -0,0 +1,250 @@
+package xsql
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"log/slog"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go.olapie.com/x/xlog"
+)
+
+// QueryEvent describes an executed statement
+type QueryEvent struct {
+	Query    string
+	Args     []any
+	Start    time.Time
+	Duration time.Duration
+
+	// RowsAffected is -1 if it's unknown, e.g. for queries or failed statements
+	RowsAffected int64
+	Err          error
+}
+
+// Interceptor is called after every statement executed by DB, its tables and transactions
+type Interceptor func(ctx context.Context, e *QueryEvent)
+
+// Use appends interceptors which are called in order. It's not safe to call it while d is being used
+func (d *DB) Use(interceptors ...Interceptor) {
+	d.interceptors = append(d.interceptors, interceptors...)
+}
+
+// SetDebug enables logging every statement before it's executed
+func (d *DB) SetDebug(debug bool) {
+	d.debug = debug
+}
+
+func (d *DB) before(query string, args []any) time.Time {
+	if Debug || (d != nil && d.debug) {
+		log.Println(query, toReadableArgs(args))
+	}
+	return time.Now()
+}
+
+func (d *DB) after(ctx context.Context, e *QueryEvent) {
+	if d == nil || len(d.interceptors) == 0 {
+		return
+	}
+	e.Duration = time.Since(e.Start)
+	for _, i := range d.interceptors {
+		i(ctx, e)
+	}
+}
+
+func (d *DB) exec(ctx context.Context, exe ContextExecutor, query string, args []any) (sql.Result, error) {
+	e := &QueryEvent{Query: query, Args: args, Start: d.before(query, args), RowsAffected: -1}
+	result, err := exe.ExecContext(ctx, query, args...)
+	if err == nil {
+		if n, nErr := result.RowsAffected(); nErr == nil {
+			e.RowsAffected = n
+		}
+	}
+	e.Err = err
+	d.after(ctx, e)
+	return result, err
+}
+
+func (d *DB) query(ctx context.Context, exe ContextExecutor, query string, args []any) (*sql.Rows, error) {
+	e := &QueryEvent{Query: query, Args: args, Start: d.before(query, args), RowsAffected: -1}
+	rows, err := exe.QueryContext(ctx, query, args...)
+	e.Err = err
+	d.after(ctx, e)
+	return rows, err
+}
+
+func (d *DB) queryRow(ctx context.Context, exe ContextExecutor, query string, args []any) *sql.Row {
+	e := &QueryEvent{Query: query, Args: args, Start: d.before(query, args), RowsAffected: -1}
+	row := exe.QueryRowContext(ctx, query, args...)
+	e.Err = row.Err()
+	d.after(ctx, e)
+	return row
+}
+
+type SlogOptions struct {
+	// SlowThreshold is min duration of statements logged as slow queries at warn level. Zero disables it
+	SlowThreshold time.Duration
+
+	// LogArgs logs arguments of statements, which may contain sensitive data
+	LogArgs bool
+
+	// LogAll logs every statement at debug level
+	LogAll bool
+}
+
+// NewSlogInterceptor returns interceptor which logs failed and slow statements with logger of xlog.FromContext
+func NewSlogInterceptor(optFns ...func(options *SlogOptions)) Interceptor {
+	options := &SlogOptions{
+		SlowThreshold: 200 * time.Millisecond,
+	}
+	for _, fn := range optFns {
+		fn(options)
+	}
+
+	return func(ctx context.Context, e *QueryEvent) {
+		level, msg := slog.LevelDebug, "sql"
+		switch {
+		case e.Err != nil && e.Err != sql.ErrNoRows:
+			level, msg = slog.LevelError, "sql failed"
+		case options.SlowThreshold > 0 && e.Duration >= options.SlowThreshold:
+			level, msg = slog.LevelWarn, "sql slow"
+		case !options.LogAll:
+			return
+		}
+
+		logger := xlog.FromContext(ctx)
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("query", e.Query),
+			slog.Duration("duration", e.Duration),
+		}
+		if options.LogArgs {
+			attrs = append(attrs, slog.Any("args", toReadableArgs(e.Args)))
+		}
+		if e.RowsAffected >= 0 {
+			attrs = append(attrs, slog.Int64("rows_affected", e.RowsAffected))
+		}
+		if e.Err != nil {
+			attrs = append(attrs, xlog.Err(e.Err))
+		}
+		logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+}
+
+// DefaultLatencyBuckets are upper bounds of LatencyHistogram buckets
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// LatencyStats is latency distribution of a statement
+type LatencyStats struct {
+	Query  string
+	Count  int64
+	Errors int64
+	Sum    time.Duration
+	Max    time.Duration
+
+	// Buckets[i] is number of executions whose latency <= Bounds[i],
+	// and the last one counts executions slower than all bounds
+	Bounds  []time.Duration
+	Buckets []int64
+}
+
+// LatencyHistogram collects latency per statement. Statements which differ only in number of
+// placeholders in a list, e.g. IN (?, ?) and IN (?, ?, ?), are counted as one statement
+type LatencyHistogram struct {
+	bounds []time.Duration
+	mu     sync.Mutex
+	stats  map[string]*LatencyStats
+}
+
+// NewLatencyHistogram creates histogram with bucket bounds. DefaultLatencyBuckets is used if bounds is empty
+func NewLatencyHistogram(bounds ...time.Duration) *LatencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i] < bounds[j]
+	})
+	return &LatencyHistogram{
+		bounds: bounds,
+		stats:  make(map[string]*LatencyStats),
+	}
+}
+
+// Interceptor returns interceptor which records latency of statements into h
+func (h *LatencyHistogram) Interceptor() Interceptor {
+	return func(ctx context.Context, e *QueryEvent) {
+		h.Observe(e.Query, e.Duration, e.Err)
+	}
+}
+
+func (h *LatencyHistogram) Observe(query string, d time.Duration, err error) {
+	query = normalizeQuery(query)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.stats[query]
+	if s == nil {
+		s = &LatencyStats{
+			Query:   query,
+			Bounds:  h.bounds,
+			Buckets: make([]int64, len(h.bounds)+1),
+		}
+		h.stats[query] = s
+	}
+	s.Count++
+	if err != nil && err != sql.ErrNoRows {
+		s.Errors++
+	}
+	s.Sum += d
+	s.Max = max(s.Max, d)
+	s.Buckets[sort.Search(len(h.bounds), func(i int) bool {
+		return d <= h.bounds[i]
+	})]++
+}
+
+// Snapshot returns copy of stats sorted by total latency in descending order
+func (h *LatencyHistogram) Snapshot() []*LatencyStats {
+	h.mu.Lock()
+	l := make([]*LatencyStats, 0, len(h.stats))
+	for _, s := range h.stats {
+		c := *s
+		c.Buckets = append([]int64(nil), s.Buckets...)
+		l = append(l, &c)
+	}
+	h.mu.Unlock()
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Sum > l[j].Sum
+	})
+	return l
+}
+
+func (h *LatencyHistogram) Reset() {
+	h.mu.Lock()
+	h.stats = make(map[string]*LatencyStats)
+	h.mu.Unlock()
+}
+
+var (
+	_regexpPlaceholderList = regexp.MustCompile(`\(\s*(?:\?|\$\d+)(?:\s*,\s*(?:\?|\$\d+))*\s*\)`)
+	_regexpRepeatedList    = regexp.MustCompile(`\(\.\.\.\)(?:\s*,\s*\(\.\.\.\))+`)
+)
+
+// normalizeQuery replaces placeholder lists with (...), and repeated lists with (...), ...
+func normalizeQuery(query string) string {
+	query = _regexpPlaceholderList.ReplaceAllString(query, "(...)")
+	return _regexpRepeatedList.ReplaceAllString(query, "(...), ...")
+}
@@ -0,0 +1,81 @@
+package xsql
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query      string
+		normalized string
+	}{
+		{"SELECT id FROM users WHERE id IN (?, ?, ?)", "SELECT id FROM users WHERE id IN (...)"},
+		{"SELECT id FROM users WHERE id IN ($1,$2) AND age > $3", "SELECT id FROM users WHERE id IN (...) AND age > $3"},
+		{"INSERT INTO users (id, name) VALUES (?, ?), (?, ?), (?, ?)", "INSERT INTO users (id, name) VALUES (...), ..."},
+	}
+	for _, test := range tests {
+		if got := normalizeQuery(test.query); got != test.normalized {
+			t.Fatal(got)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := NewLatencyHistogram(10*time.Millisecond, time.Millisecond)
+	h.Observe("SELECT 1", time.Millisecond, nil)
+	h.Observe("SELECT 1", 5*time.Millisecond, nil)
+	h.Observe("SELECT 1", time.Second, nil)
+	l := h.Snapshot()
+	if len(l) != 1 || l[0].Count != 3 || l[0].Max != time.Second {
+		t.Fatal(l)
+	}
+	if b := l[0].Buckets; len(b) != 3 || b[0] != 1 || b[1] != 1 || b[2] != 1 {
+		t.Fatal(b)
+	}
+}
+
+type hookTestItem struct {
+	ID   int64 `sql:"primary key"`
+	Name string
+}
+
+func TestDB_Use(t *testing.T) {
+	db := newTestDB(t)
+	var queries []string
+	db.Use(func(ctx context.Context, e *QueryEvent) {
+		queries = append(queries, e.Query)
+	})
+
+	db.MustExec("CREATE TABLE hook_test_items(id INTEGER PRIMARY KEY)")
+	if _, err := db.SyncSchema(&hookTestItem{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTable(&schemaTestItem{}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		return db.InTx(ctx, func(ctx context.Context, tx *Tx) error {
+			return tx.Insert(&hookTestItem{ID: 1})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prefix := range []string{
+		"CREATE TABLE hook_test_items",
+		"ALTER TABLE hook_test_items ADD",
+		"CREATE TABLE IF NOT EXISTS schema_test_items",
+		"SAVEPOINT xsql_sp_1",
+		"INSERT INTO hook_test_items",
+		"RELEASE SAVEPOINT xsql_sp_1",
+	} {
+		if !slices.ContainsFunc(queries, func(q string) bool { return strings.HasPrefix(q, prefix) }) {
+			t.Fatal(prefix, queries)
+		}
+	}
+}
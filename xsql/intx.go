@@ -0,0 +1,154 @@
+package xsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+
+	// MaxRetries is max number of retries after the first attempt fails with retryable error
+	MaxRetries int
+
+	// Backoff is delay before the first retry, and it's doubled for every next retry
+	Backoff time.Duration
+
+	// IsRetryable reports whether failed transaction can be retried. Default is IsRetryableError
+	IsRetryable func(err error) bool
+}
+
+type txContextKey struct {
+	db *DB
+}
+
+// TxFromContext returns transaction of d started by InTx, or nil if there is none
+func (d *DB) TxFromContext(ctx context.Context) *Tx {
+	tx, _ := ctx.Value(txContextKey{db: d}).(*Tx)
+	return tx
+}
+
+// InTx runs fn in a transaction, which is committed if fn returns nil, otherwise it's rolled back
+// The transaction is retried with exponential backoff if it fails with serialization failure or deadlock,
+// so fn may be called several times and it should not have side effects out of the transaction. Use Tx.AfterCommit instead.
+// If ctx passed to fn is used to call InTx again, the nested call runs in a savepoint of the same transaction
+// and options of the nested call are ignored
+func (d *DB) InTx(ctx context.Context, fn func(ctx context.Context, tx *Tx) error, optFns ...func(options *TxOptions)) error {
+	if tx := d.TxFromContext(ctx); tx != nil {
+		return tx.inSavepoint(ctx, fn)
+	}
+
+	options := &TxOptions{
+		MaxRetries:  3,
+		Backoff:     10 * time.Millisecond,
+		IsRetryable: IsRetryableError,
+	}
+	for _, f := range optFns {
+		f(options)
+	}
+
+	backoff := options.Backoff
+	for attempt := 0; ; attempt++ {
+		err := d.runTx(ctx, options, fn)
+		if err == nil || attempt >= options.MaxRetries || !options.IsRetryable(err) {
+			return err
+		}
+
+		// jitter avoids retrying at the same time with the conflicting transaction
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+func (d *DB) runTx(ctx context.Context, options *TxOptions, fn func(ctx context.Context, tx *Tx) error) (err error) {
+	tx, err := d.BeginTx(ctx, &sql.TxOptions{
+		Isolation: options.Isolation,
+		ReadOnly:  options.ReadOnly,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txContextKey{db: d}, tx), tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *Tx) inSavepoint(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) (err error) {
+	t.savepoints++
+	name := fmt.Sprintf("xsql_sp_%d", t.savepoints)
+	numHooks := len(t.hooks)
+	if _, err = t.db.exec(ctx, t.tx, "SAVEPOINT "+name, nil); err != nil {
+		return err
+	}
+
+	rollback := func() {
+		t.hooks = t.hooks[:numHooks]
+		if _, err := t.db.exec(ctx, t.tx, "ROLLBACK TO SAVEPOINT "+name, nil); err == nil {
+			t.db.exec(ctx, t.tx, "RELEASE SAVEPOINT "+name, nil)
+		}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx, t); err != nil {
+		rollback()
+		return err
+	}
+	_, err = t.db.exec(ctx, t.tx, "RELEASE SAVEPOINT "+name, nil)
+	return err
+}
+
+// IsRetryableError reports whether err is caused by serialization failure, deadlock or busy database
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// pgx and lib/pq errors
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		switch state.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"could not serialize access",
+		"deadlock",
+		"error 1213",
+		"error 1205",
+		"database is locked",
+		"sqlite_busy",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
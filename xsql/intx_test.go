@@ -0,0 +1,186 @@
+package xsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sql error" }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{errors.New("no rows"), false},
+		{fmt.Errorf("exec: %w", sqlStateError("40001")), true},
+		{sqlStateError("40P01"), true},
+		{sqlStateError("23505"), false},
+		{errors.New("Error 1213 (40001): Deadlock found when trying to get lock"), true},
+		{errors.New("database is locked (5) (SQLITE_BUSY)"), true},
+	}
+	for _, test := range tests {
+		if IsRetryableError(test.err) != test.retryable {
+			t.Fatal(test.err)
+		}
+	}
+}
+
+func countTxTestItems(t *testing.T, db *DB) int {
+	var n int
+	if err := db.DB().QueryRow("SELECT COUNT(*) FROM tx_test_items").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDB_InTx_Savepoint(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE tx_test_items(id INTEGER PRIMARY KEY)")
+	var hooks []string
+	errInner := errors.New("inner")
+	err := db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tx_test_items(id) VALUES(1)"); err != nil {
+			return err
+		}
+		tx.AfterCommit(func() { hooks = append(hooks, "outer") })
+
+		err := db.InTx(ctx, func(ctx context.Context, inner *Tx) error {
+			if inner != tx {
+				t.Fatal("nested call runs in another transaction")
+			}
+			if _, err := inner.ExecContext(ctx, "INSERT INTO tx_test_items(id) VALUES(2)"); err != nil {
+				return err
+			}
+			inner.AfterCommit(func() { hooks = append(hooks, "inner") })
+			return errInner
+		})
+		if !errors.Is(err, errInner) {
+			t.Fatal(err)
+		}
+
+		return db.InTx(ctx, func(ctx context.Context, inner *Tx) error {
+			inner.AfterCommit(func() { hooks = append(hooks, "released") })
+			_, err := inner.ExecContext(ctx, "INSERT INTO tx_test_items(id) VALUES(3)")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.DB().Query("SELECT id FROM tx_test_items ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if fmt.Sprint(ids) != "[1 3]" {
+		t.Fatal("writes of rolled back savepoint are kept", ids)
+	}
+	if fmt.Sprint(hooks) != "[outer released]" {
+		t.Fatal(hooks)
+	}
+}
+
+func TestDB_InTx_AfterCommit(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE tx_test_items(id INTEGER PRIMARY KEY)")
+	called := false
+	err := db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		tx.AfterCommit(func() {
+			called = true
+			if n := countTxTestItems(t, db); n != 1 {
+				t.Error("hook runs before commit", n)
+			}
+		})
+		return db.InTx(ctx, func(ctx context.Context, tx *Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO tx_test_items(id) VALUES(1)")
+			if called {
+				t.Error("hook runs after savepoint is released")
+			}
+			return err
+		})
+	})
+	if err != nil || !called {
+		t.Fatal(called, err)
+	}
+
+	called = false
+	errRollback := errors.New("rollback")
+	err = db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		tx.AfterCommit(func() { called = true })
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tx_test_items(id) VALUES(2)"); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) || called {
+		t.Fatal("hook runs after rollback", err)
+	}
+	if n := countTxTestItems(t, db); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestDB_InTx_Retry(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE tx_test_items(id INTEGER PRIMARY KEY)")
+	backoff := func(options *TxOptions) {
+		options.Backoff = time.Millisecond
+	}
+
+	attempts := 0
+	hooks := 0
+	err := db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		attempts++
+		tx.AfterCommit(func() { hooks++ })
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tx_test_items(id) VALUES(1)"); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return errors.New("database is locked")
+		}
+		return nil
+	}, backoff)
+	if err != nil || attempts != 3 || hooks != 1 {
+		t.Fatal(attempts, hooks, err)
+	}
+	if n := countTxTestItems(t, db); n != 1 {
+		t.Fatal(n)
+	}
+
+	attempts = 0
+	err = db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		attempts++
+		return sqlStateError("40001")
+	}, backoff, func(options *TxOptions) {
+		options.MaxRetries = 2
+	})
+	if !IsRetryableError(err) || attempts != 3 {
+		t.Fatal(attempts, err)
+	}
+
+	attempts = 0
+	err = db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		attempts++
+		return sqlStateError("23505")
+	}, backoff)
+	if err == nil || attempts != 1 {
+		t.Fatal("non-retryable error is retried", attempts, err)
+	}
+}
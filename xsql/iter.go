@@ -0,0 +1,131 @@
+package xsql
+
+import (
+	"context"
+	"iter"
+	"reflect"
+	"strings"
+)
+
+// Iterate streams records of T matched by q from the table of T. T is a struct or pointer to struct
+// Rows are scanned one by one, and iteration stops after the first error
+func Iterate[T any](ctx context.Context, d *DB, q *Query) iter.Seq2[T, error] {
+	return IterateTable[T](ctx, d.Table(getTableNameByType(reflect.TypeFor[T]())), q)
+}
+
+// IterateTable streams records of T matched by q from table t
+func IterateTable[T any](ctx context.Context, t *Table, q *Query) iter.Seq2[T, error] {
+	isPtr, elemType := recordType[T]()
+	fi := getColumnInfo(elemType)
+	return func(yield func(T, error) bool) {
+		var zero T
+		clause, args := t.scopeQuery(fi, q).build(t.driverName, true)
+		rows, err := t.read(ctx, "SELECT "+strings.Join(fi.names, ", ")+" FROM "+t.name+clause, args...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			ptrToElem, err := scanRecord(rows, fi, elemType)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(toRecord[T](ptrToElem, isPtr), nil) {
+				return
+			}
+		}
+		if err = rows.Err(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// SelectAfter selects at most limit records of T matched by q whose primary key is greater than after
+// Records are ordered by primary key, and ORDER BY, LIMIT and OFFSET of q are ignored. after is nil for the first page
+// next is primary key of the last record which is used as after of next page, and it's nil if there are no more records
+func SelectAfter[T any](ctx context.Context, t *Table, q *Query, after any, limit int) (records []T, next any, err error) {
+	if limit <= 0 {
+		panic("limit must be positive")
+	}
+	_, elemType := recordType[T]()
+	pk := keysetColumn(getColumnInfo(elemType))
+	err = t.SelectByContext(ctx, &records, keysetQuery(q, pk, after, limit))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) < limit {
+		return records, nil, nil
+	}
+	return records, primaryKeyOf(records[len(records)-1], pk), nil
+}
+
+// IterateAfter streams records of T matched by q whose primary key is greater than after
+// Unlike IterateTable, records are loaded page by page with SelectAfter, so no cursor is held between pages
+// and an interrupted iteration can be resumed from primary key of the last received record
+func IterateAfter[T any](ctx context.Context, t *Table, q *Query, after any, pageSize int) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			records, next, err := SelectAfter[T](ctx, t, q, after, pageSize)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, r := range records {
+				if !yield(r, nil) {
+					return
+				}
+			}
+			if next == nil {
+				return
+			}
+			after = next
+		}
+	}
+}
+
+func recordType[T any]() (isPtr bool, elemType reflect.Type) {
+	elemType = reflect.TypeFor[T]()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+		isPtr = true
+	}
+	if elemType.Kind() != reflect.Struct {
+		panic("record must be a struct or pointer to struct")
+	}
+	return isPtr, elemType
+}
+
+func toRecord[T any](ptrToElem reflect.Value, isPtr bool) T {
+	if isPtr {
+		return ptrToElem.Interface().(T)
+	}
+	return ptrToElem.Elem().Interface().(T)
+}
+
+func keysetColumn(info *columnInfo) string {
+	if len(info.pkNames) != 1 {
+		panic("keyset pagination requires exactly one primary key column")
+	}
+	return info.pkNames[0]
+}
+
+func keysetQuery(q *Query, pk string, after any, limit int) *Query {
+	k := &Query{}
+	if q != nil {
+		k.where = q.where
+		k.preloads = q.preloads
+	}
+	if after != nil {
+		k.And(Gt(pk, after))
+	}
+	return k.OrderBy(pk).Limit(limit)
+}
+
+func primaryKeyOf(record any, pk string) any {
+	v := getStructValue(record)
+	return v.FieldByIndex(getColumnInfo(v.Type()).nameToIndex[pk]).Interface()
+}
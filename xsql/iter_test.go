@@ -0,0 +1,26 @@
+package xsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeysetQuery(t *testing.T) {
+	q := Where(Eq("status", 1)).OrderByDesc("name").Limit(3)
+	clause, args := keysetQuery(q, "id", int64(10), 20).Build(POSTGRES)
+	if clause != " WHERE (status = $1 AND id > $2) ORDER BY id LIMIT 20" {
+		t.Fatal(clause)
+	}
+	if !reflect.DeepEqual(args, []any{1, int64(10)}) {
+		t.Fatal(args)
+	}
+
+	clause, _ = keysetQuery(q, "id", nil, 20).Build(POSTGRES)
+	if clause != " WHERE status = $1 ORDER BY id LIMIT 20" {
+		t.Fatal(clause)
+	}
+	// q is not changed
+	if clause, _ = q.Build(SQLITE); clause != " WHERE status = ? ORDER BY name DESC LIMIT 3" {
+		t.Fatal(clause)
+	}
+}
@@ -0,0 +1,428 @@
+package xsql
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ErrMigrationModified is returned if an applied migration file has been edited
+var ErrMigrationModified = errors.New("applied migration is modified")
+
+var _regexpMigrationFile = regexp.MustCompile(`^(\d+)_(\w[\w.-]*?)(\.up|\.down)?\.sql$`)
+
+// noTransactionDirective at the beginning of a migration file disables its transaction,
+// e.g. for CREATE INDEX CONCURRENTLY in postgres. Leading blank lines are ignored
+const noTransactionDirective = "-- xsql:no-transaction"
+
+const utf8BOM = "\ufeff"
+
+// Migration is a versioned schema change loaded from files named like 0001_create_users.up.sql and 0001_create_users.down.sql
+// File without .up or .down suffix is treated as up migration
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is sha256 of up and down files before they're executed as templates
+	Checksum string
+}
+
+// AppliedMigration is a record in migration table
+type AppliedMigration struct {
+	Version   int64
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+type MigrateOptions struct {
+	// Table records applied migrations
+	Table string
+	// DryRun reports migrations to run without executing them
+	DryRun bool
+	// Params are used to execute migration files as text/template if it's not empty
+	Params map[string]any
+	// IgnoreChecksum doesn't check if applied migrations are modified
+	IgnoreChecksum bool
+}
+
+// Migrator applies and reverts migrations in order. Each migration runs in a transaction except in mysql
+// Migrations are serialized with advisory lock in postgres and mysql, so multiple instances can migrate concurrently
+type Migrator struct {
+	db         *sql.DB
+	driverName string
+	migrations []*Migration
+	options    *MigrateOptions
+}
+
+func NewMigrator(db *DB, fsys fs.FS, optFns ...func(options *MigrateOptions)) (*Migrator, error) {
+	options := &MigrateOptions{
+		Table: "schema_migrations",
+	}
+	for _, fn := range optFns {
+		fn(options)
+	}
+	if !_regexpVariable.MatchString(options.Table) {
+		return nil, fmt.Errorf("invalid table name: %s", options.Table)
+	}
+	migrations, err := loadMigrations(fsys, options.Params)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db.db,
+		driverName: db.driverName,
+		migrations: migrations,
+		options:    options,
+	}, nil
+}
+
+// Migrations returns all migrations sorted by version
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// LatestVersion returns the max version of migrations, or 0 if there is no migration
+func (m *Migrator) LatestVersion() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Applied returns applied migrations sorted by version
+func (m *Migrator) Applied(ctx context.Context) ([]*AppliedMigration, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err = m.createTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	return m.applied(ctx, conn)
+}
+
+// Version returns the max version of applied migrations
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	applied, err := m.Applied(ctx)
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1].Version, nil
+}
+
+// Up applies all pending migrations, and returns them
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	return m.Migrate(ctx, m.LatestVersion())
+}
+
+// Down reverts the last n applied migrations, and returns them
+func (m *Migrator) Down(ctx context.Context, n int) ([]*Migration, error) {
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 || len(applied) == 0 {
+		return nil, nil
+	}
+	var target int64
+	if n < len(applied) {
+		target = applied[len(applied)-n-1].Version
+	}
+	return m.Migrate(ctx, target)
+}
+
+// Migrate applies pending migrations whose version is not greater than target,
+// and reverts applied migrations whose version is greater than target
+// Returned migrations are in executed order. If it fails, migrations executed before the failure are returned with error
+func (m *Migrator) Migrate(ctx context.Context, target int64) ([]*Migration, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if !m.options.DryRun {
+		unlock, err := m.lock(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	var applied []*AppliedMigration
+	if m.options.DryRun {
+		// migration table might not exist, and it's not created in dry run
+		applied, _ = m.applied(ctx, conn)
+	} else {
+		if err = m.createTable(ctx, conn); err != nil {
+			return nil, err
+		}
+		if applied, err = m.applied(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+	if err = m.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var steps []*Migration
+	appliedSet := make(map[int64]bool, len(applied))
+	for _, a := range applied {
+		appliedSet[a.Version] = true
+	}
+	for i := len(applied) - 1; i >= 0 && applied[i].Version > target; i-- {
+		mg := m.find(applied[i].Version)
+		if mg == nil || mg.Down == "" {
+			return nil, fmt.Errorf("no down migration of version %d", applied[i].Version)
+		}
+		if !m.options.DryRun {
+			if err = m.run(ctx, conn, mg, false); err != nil {
+				return steps, err
+			}
+		}
+		steps = append(steps, mg)
+	}
+
+	for _, mg := range m.migrations {
+		if mg.Version > target || appliedSet[mg.Version] {
+			continue
+		}
+		if !m.options.DryRun {
+			if err = m.run(ctx, conn, mg, true); err != nil {
+				return steps, err
+			}
+		}
+		steps = append(steps, mg)
+	}
+	return steps, nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	i := sort.Search(len(m.migrations), func(i int) bool {
+		return m.migrations[i].Version >= version
+	})
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return m.migrations[i]
+	}
+	return nil
+}
+
+func (m *Migrator) verify(applied []*AppliedMigration) error {
+	if m.options.IgnoreChecksum {
+		return nil
+	}
+	for _, a := range applied {
+		if mg := m.find(a.Version); mg != nil && mg.Checksum != a.Checksum {
+			return fmt.Errorf("%w: %d_%s", ErrMigrationModified, mg.Version, mg.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mg *Migration, up bool) error {
+	query := mg.Down
+	record := "DELETE FROM " + m.options.Table + " WHERE version = ?"
+	args := []any{mg.Version}
+	if up {
+		query = mg.Up
+		record = "INSERT INTO " + m.options.Table + "(version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"
+		args = append(args, mg.Name, mg.Checksum, time.Now().UnixMilli())
+	}
+	record = Rebind(m.driverName, record)
+
+	var err error
+	if isMySQL(m.driverName) || strings.HasPrefix(strings.TrimSpace(query), noTransactionDirective) {
+		// DDL causes implicit commit in mysql
+		if _, err = conn.ExecContext(ctx, query); err == nil {
+			_, err = conn.ExecContext(ctx, record, args...)
+		}
+	} else {
+		err = runInTx(ctx, conn, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, record, args...)
+			return err
+		})
+	}
+	if err != nil {
+		if up {
+			return fmt.Errorf("apply migration %d_%s: %w", mg.Version, mg.Name, err)
+		}
+		return fmt.Errorf("revert migration %d_%s: %w", mg.Version, mg.Name, err)
+	}
+	return nil
+}
+
+func runInTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.options.Table+
+		"(version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, applied_at BIGINT NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("create migration table: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) ([]*AppliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+m.options.Table+" ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var l []*AppliedMigration
+	for rows.Next() {
+		a := &AppliedMigration{}
+		var appliedAt int64
+		if err = rows.Scan(&a.Version, &a.Name, &a.Checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		a.AppliedAt = time.UnixMilli(appliedAt)
+		l = append(l, a)
+	}
+	return l, rows.Err()
+}
+
+// lock acquires advisory lock which is held by conn until unlock is called
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (unlock func(), err error) {
+	h := fnv.New64a()
+	h.Write([]byte("xsql.Migrator:" + m.options.Table))
+	key := int64(h.Sum64())
+	switch {
+	case isPostgres(m.driverName):
+		if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			return nil, fmt.Errorf("pg_advisory_lock: %w", err)
+		}
+		return func() {
+			_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key)
+		}, nil
+	case isMySQL(m.driverName):
+		name := strconv.FormatInt(key, 16)
+		var ok sql.NullInt64
+		if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", name).Scan(&ok); err != nil {
+			return nil, fmt.Errorf("GET_LOCK: %w", err)
+		}
+		if ok.Int64 != 1 {
+			return nil, errors.New("GET_LOCK: failed")
+		}
+		return func() {
+			_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK(?)", name)
+		}, nil
+	default:
+		return func() {}, nil
+	}
+}
+
+func loadMigrations(fsys fs.FS, params map[string]any) ([]*Migration, error) {
+	versionToMigration := make(map[int64]*Migration)
+	versionToDown := make(map[int64][]byte)
+	versionToUp := make(map[int64][]byte)
+	err := fs.WalkDir(fsys, ".", func(filename string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		matches := _regexpMigrationFile.FindStringSubmatch(d.Name())
+		if matches == nil {
+			return nil
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", filename)
+		}
+		content, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return fmt.Errorf("read file %s: %w", filename, err)
+		}
+		content = bytes.TrimPrefix(content, []byte(utf8BOM))
+		query := string(content)
+		if len(params) != 0 {
+			if query, err = executeTemplate(filename, query, params); err != nil {
+				return err
+			}
+		}
+
+		mg := versionToMigration[version]
+		if mg == nil {
+			mg = &Migration{Version: version, Name: matches[2]}
+			versionToMigration[version] = mg
+		} else if mg.Name != matches[2] {
+			return fmt.Errorf("duplicate version %d: %s and %s", version, mg.Name, matches[2])
+		}
+		if matches[3] == ".down" {
+			if mg.Down != "" {
+				return fmt.Errorf("duplicate down migration: %s", filename)
+			}
+			mg.Down = query
+			versionToDown[version] = content
+		} else {
+			if mg.Up != "" {
+				return fmt.Errorf("duplicate up migration: %s", filename)
+			}
+			mg.Up = query
+			versionToUp[version] = content
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]*Migration, 0, len(versionToMigration))
+	for _, mg := range versionToMigration {
+		if mg.Up == "" {
+			return nil, fmt.Errorf("no up migration of version %d", mg.Version)
+		}
+		h := sha256.New()
+		h.Write(versionToUp[mg.Version])
+		if down := versionToDown[mg.Version]; down != nil {
+			// checksum of migration without down file is sha256 of up file
+			h.Write([]byte{0})
+			h.Write(down)
+		}
+		mg.Checksum = hex.EncodeToString(h.Sum(nil))
+		migrations = append(migrations, mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func executeTemplate(name, text string, params map[string]any) (string, error) {
+	tpl, err := template.New(path.Base(name)).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, params); err != nil {
+		return "", fmt.Errorf("execute template %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
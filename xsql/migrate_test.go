@@ -0,0 +1,108 @@
+package xsql
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_age.up.sql":        {Data: []byte("ALTER TABLE {{.table}} ADD age INT")},
+		"0002_add_age.down.sql":      {Data: []byte("ALTER TABLE {{.table}} DROP age")},
+		"0001_create_users.sql":      {Data: []byte("CREATE TABLE {{.table}}(id INT)")},
+		"README.md":                  {Data: []byte("readme")},
+		"0010_create_items.down.sql": {Data: []byte("DROP TABLE items")},
+	}
+	_, err := loadMigrations(fsys, nil)
+	if err == nil {
+		t.Fatal("should fail without up migration")
+	}
+
+	delete(fsys, "0010_create_items.down.sql")
+	migrations, err := loadMigrations(fsys, map[string]any{"table": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "add_age" {
+		t.Fatal(migrations)
+	}
+	if migrations[1].Down != "ALTER TABLE users DROP age" || migrations[0].Down != "" {
+		t.Fatal(migrations[1].Down)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	fsys := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users(id INT)")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users")},
+		"0002_add_age.up.sql":        {Data: []byte("ALTER TABLE users ADD age INT")},
+		"0002_add_age.down.sql":      {Data: []byte("ALTER TABLE users DROP age")},
+		// VACUUM fails in a transaction
+		"0003_vacuum.sql": {Data: []byte("\ufeff\n-- xsql:no-transaction\nVACUUM")},
+	}
+	newMigrator := func(optFns ...func(options *MigrateOptions)) *Migrator {
+		m, err := NewMigrator(db, fsys, optFns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	versions := func(l []*Migration) []int64 {
+		var vs []int64
+		for _, mg := range l {
+			vs = append(vs, mg.Version)
+		}
+		return vs
+	}
+
+	dryRun := newMigrator(func(options *MigrateOptions) {
+		options.DryRun = true
+	})
+	steps, err := dryRun.Up(ctx)
+	if err != nil || !slices.Equal(versions(steps), []int64{1, 2, 3}) {
+		t.Fatal(versions(steps), err)
+	}
+	var n int
+	if err = db.DB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name='users'").Scan(&n); err != nil || n != 0 {
+		t.Fatal("dry run created table", err)
+	}
+
+	m := newMigrator()
+	if steps, err = m.Migrate(ctx, 2); err != nil || !slices.Equal(versions(steps), []int64{1, 2}) {
+		t.Fatal(versions(steps), err)
+	}
+	if steps, err = m.Up(ctx); err != nil || !slices.Equal(versions(steps), []int64{3}) {
+		t.Fatal(versions(steps), err)
+	}
+	if _, err = db.Exec("INSERT INTO users(id, age) VALUES (1, 2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Down(ctx, 1); err == nil {
+		t.Fatal("reverted migration without down file")
+	}
+	if steps, err = m.Migrate(ctx, 1); err == nil || len(steps) != 0 {
+		t.Fatal(versions(steps), err)
+	}
+	fsys["0003_vacuum.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1")}
+	if _, err = newMigrator().Down(ctx, 1); !errors.Is(err, ErrMigrationModified) {
+		t.Fatal("modified down file is not detected", err)
+	}
+	m = newMigrator(func(options *MigrateOptions) {
+		options.IgnoreChecksum = true
+	})
+	if steps, err = m.Migrate(ctx, 1); err != nil || !slices.Equal(versions(steps), []int64{3, 2}) {
+		t.Fatal(versions(steps), err)
+	}
+	if version, err := m.Version(ctx); err != nil || version != 1 {
+		t.Fatal(version, err)
+	}
+	if _, err = db.Exec("INSERT INTO users(id, age) VALUES (1, 2)"); err == nil {
+		t.Fatal("column age is not dropped")
+	}
+}
@@ -0,0 +1,218 @@
+package xsql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type relationKind int
+
+const (
+	hasMany relationKind = iota + 1
+	belongsTo
+)
+
+// relationInfo is parsed from tag of a relation field, e.g.
+// Posts []*Post `sql:"has_many,foreign_key=user_id"` where posts.user_id references users.id, or
+// Author *User `sql:"belongs_to,foreign_key=author_id"` where posts.author_id references users.id.
+// references is the referenced column, and default is the single-column primary key
+type relationInfo struct {
+	kind       relationKind
+	index      fieldIndex
+	elemType   reflect.Type
+	foreignKey string
+	references string
+}
+
+// parseRelation returns nil if f is not a relation field
+func parseRelation(f reflect.StructField, tag string) *relationInfo {
+	rel := &relationInfo{index: f.Index}
+	for _, s := range strings.Split(tag, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(s), "=")
+		switch k {
+		case "has_many":
+			rel.kind = hasMany
+		case "belongs_to":
+			rel.kind = belongsTo
+		case "foreign_key":
+			rel.foreignKey = v
+		case "references":
+			rel.references = v
+		}
+	}
+	if rel.kind == 0 {
+		return nil
+	}
+
+	typ := f.Type
+	if rel.kind == hasMany {
+		if typ.Kind() != reflect.Slice {
+			panic("has_many field must be a slice: " + f.Name)
+		}
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		panic("relation field must be a struct, pointer to struct or slice of them: " + f.Name)
+	}
+	if !_regexpVariable.MatchString(rel.foreignKey) {
+		panic("invalid foreign_key of " + f.Name)
+	}
+	if len(rel.references) > 0 && !_regexpVariable.MatchString(rel.references) {
+		panic("invalid references of " + f.Name)
+	}
+	rel.elemType = typ
+	return rel
+}
+
+// Preload loads relations of records by field names. records is a pointer to struct or slice
+func (d *DB) Preload(records any, relations ...string) error {
+	return d.PreloadContext(context.Background(), records, relations...)
+}
+
+func (d *DB) PreloadContext(ctx context.Context, records any, relations ...string) error {
+	return d.Table("").preload(ctx, records, relations)
+}
+
+func (t *Tx) Preload(records any, relations ...string) error {
+	return t.PreloadContext(context.Background(), records, relations...)
+}
+
+func (t *Tx) PreloadContext(ctx context.Context, records any, relations ...string) error {
+	return t.Table("").preload(ctx, records, relations)
+}
+
+func (t *Table) preload(ctx context.Context, records any, relations []string) error {
+	if len(relations) == 0 {
+		return nil
+	}
+	parents := structValues(reflect.ValueOf(records))
+	if len(parents) == 0 {
+		return nil
+	}
+	info := getColumnInfo(parents[0].Type())
+	for _, name := range relations {
+		rel := info.relations[name]
+		if rel == nil {
+			panic(fmt.Sprintf("no relation %s in %v", name, parents[0].Type()))
+		}
+		if err := t.loadRelation(ctx, info, rel, parents); err != nil {
+			return fmt.Errorf("preload %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// loadRelation selects related records with one IN query, and assigns them to relation field of parents
+func (t *Table) loadRelation(ctx context.Context, info *columnInfo, rel *relationInfo, parents []reflect.Value) error {
+	relInfo := getColumnInfo(rel.elemType)
+	// localKey is column of parents, and remoteKey is column of related records
+	localKey, remoteKey := rel.references, rel.foreignKey
+	if rel.kind == hasMany {
+		if len(localKey) == 0 {
+			localKey = keysetColumn(info)
+		}
+	} else {
+		localKey, remoteKey = rel.foreignKey, rel.references
+		if len(remoteKey) == 0 {
+			remoteKey = keysetColumn(relInfo)
+		}
+	}
+	if _, ok := info.nameToIndex[localKey]; !ok {
+		panic("no column " + localKey + " in " + parents[0].Type().String())
+	}
+	if _, ok := relInfo.nameToIndex[remoteKey]; !ok {
+		panic("no column " + remoteKey + " in " + rel.elemType.String())
+	}
+
+	var keys []any
+	seen := make(map[string]bool)
+	for _, p := range parents {
+		fv := p.FieldByIndex(info.nameToIndex[localKey])
+		if fv.IsZero() {
+			continue
+		}
+		if k := fmt.Sprint(fv.Interface()); !seen[k] {
+			seen[k] = true
+			keys = append(keys, fv.Interface())
+		}
+	}
+
+	// related records are grouped by remote key
+	groups := make(map[string][]reflect.Value)
+	rt := &Table{
+		db:          t.db,
+		exe:         t.exe,
+		driverName:  t.driverName,
+		name:        getTableNameByType(rel.elemType),
+		withDeleted: t.withDeleted,
+	}
+	size := maxParams(t.driverName)
+	for start := 0; start < len(keys); start += size {
+		list := reflect.New(reflect.SliceOf(reflect.PointerTo(rel.elemType)))
+		q := Where(In(remoteKey, keys[start:min(start+size, len(keys))]...))
+		if err := rt.SelectByContext(ctx, list.Interface(), q); err != nil {
+			return err
+		}
+		for i := 0; i < list.Elem().Len(); i++ {
+			r := list.Elem().Index(i)
+			k := fmt.Sprint(r.Elem().FieldByIndex(relInfo.nameToIndex[remoteKey]).Interface())
+			groups[k] = append(groups[k], r)
+		}
+	}
+
+	for _, p := range parents {
+		field := p.FieldByIndex(rel.index)
+		fv := p.FieldByIndex(info.nameToIndex[localKey])
+		group := groups[fmt.Sprint(fv.Interface())]
+		if fv.IsZero() {
+			group = nil
+		}
+		if rel.kind == hasMany {
+			l := reflect.MakeSlice(field.Type(), 0, len(group))
+			for _, r := range group {
+				l = reflect.Append(l, relationValue(r, field.Type().Elem()))
+			}
+			field.Set(l)
+		} else if len(group) > 0 {
+			field.Set(relationValue(group[0], field.Type()))
+		} else {
+			field.SetZero()
+		}
+	}
+	return nil
+}
+
+// relationValue converts pointer to related record to typ which is struct or pointer to struct
+func relationValue(ptr reflect.Value, typ reflect.Type) reflect.Value {
+	if typ.Kind() == reflect.Ptr {
+		return ptr
+	}
+	return ptr.Elem()
+}
+
+// structValues returns addressable struct values in v which is pointer to struct or slice
+func structValues(v reflect.Value) []reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{v}
+	case reflect.Slice:
+		var l []reflect.Value
+		for i := 0; i < v.Len(); i++ {
+			l = append(l, structValues(v.Index(i).Addr())...)
+		}
+		return l
+	default:
+		panic("must be a pointer to struct or slice")
+	}
+}
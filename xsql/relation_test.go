@@ -0,0 +1,56 @@
+package xsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+type relationTestUser struct {
+	ID    int64               `sql:"primary key"`
+	Posts []*relationTestPost `sql:"has_many,foreign_key=user_id"`
+	Meta  *relationTestMeta
+}
+
+type relationTestMeta struct {
+	Tags []string
+}
+
+type relationTestPost struct {
+	ID     int64 `sql:"primary key"`
+	UserID int64
+	User   *relationTestUser `sql:"belongs_to,foreign_key=user_id,references=id"`
+}
+
+func TestParseRelation(t *testing.T) {
+	info := getRecordColumnInfo(&relationTestUser{})
+	if !reflect.DeepEqual(info.names, []string{"id"}) {
+		t.Fatal(info.names)
+	}
+	rel := info.relations["Posts"]
+	if rel == nil || rel.kind != hasMany || rel.foreignKey != "user_id" || rel.elemType != reflect.TypeOf(relationTestPost{}) {
+		t.Fatal(rel)
+	}
+
+	info = getRecordColumnInfo(&relationTestPost{})
+	if !reflect.DeepEqual(info.names, []string{"id", "user_id"}) {
+		t.Fatal(info.names)
+	}
+	rel = info.relations["User"]
+	if rel == nil || rel.kind != belongsTo || rel.references != "id" || rel.elemType != reflect.TypeOf(relationTestUser{}) {
+		t.Fatal(rel)
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	user := newRecord(reflect.TypeOf(&relationTestUser{})).Elem().Interface().(*relationTestUser)
+	if user.Meta == nil {
+		t.Fatal("non-column field is not allocated")
+	}
+	if user.Posts != nil {
+		t.Fatal("relation field is allocated")
+	}
+	post := newRecord(reflect.TypeOf(relationTestPost{})).Interface().(*relationTestPost)
+	if post.User != nil {
+		t.Fatal("relation field is allocated")
+	}
+}
@@ -0,0 +1,124 @@
+package xsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaRetryInterval is how long an unhealthy replica is skipped before it's tried again
+var ReplicaRetryInterval = 5 * time.Second
+
+type replica struct {
+	db *sql.DB
+	// downUntil is unix nanoseconds until which the replica is skipped
+	downUntil atomic.Int64
+}
+
+func (r *replica) healthy(now time.Time) bool {
+	return r.downUntil.Load() <= now.UnixNano()
+}
+
+// failed marks r as unhealthy and returns true if err is caused by connection failure
+func (r *replica) failed(err error) bool {
+	if !isConnError(err) {
+		return false
+	}
+	r.downUntil.Store(time.Now().Add(ReplicaRetryInterval).UnixNano())
+	return true
+}
+
+type primaryContextKey struct{}
+
+// WithPrimary returns ctx which makes reads go to the primary database, e.g. to read your own writes
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+func isPrimaryContext(ctx context.Context) bool {
+	b, _ := ctx.Value(primaryContextKey{}).(bool)
+	return b
+}
+
+// AddReplica opens a read replica. Selects out of transactions are sent to replicas in round-robin,
+// while writes and transactions always use the primary database. It's not safe to call it while d is being used
+func (d *DB) AddReplica(dataSourceName string) error {
+	db, err := sql.Open(d.driverName, dataSourceName)
+	if err != nil {
+		return err
+	}
+	d.AddReplicaDB(db)
+	return nil
+}
+
+// AddReplicaDB adds an opened read replica which is closed by d.Close
+func (d *DB) AddReplicaDB(db *sql.DB) {
+	d.replicas = append(d.replicas, &replica{db: db})
+}
+
+// Replicas returns read replicas
+func (d *DB) Replicas() []*sql.DB {
+	l := make([]*sql.DB, len(d.replicas))
+	for i, r := range d.replicas {
+		l[i] = r.db
+	}
+	return l
+}
+
+// CheckReplicas pings replicas, and updates their health. It can be called periodically to detect recovered replicas early
+func (d *DB) CheckReplicas(ctx context.Context) {
+	for _, r := range d.replicas {
+		if err := r.db.PingContext(ctx); err != nil {
+			r.downUntil.Store(time.Now().Add(ReplicaRetryInterval).UnixNano())
+		} else {
+			r.downUntil.Store(0)
+		}
+	}
+}
+
+// pickReplica returns next healthy replica, or nil if there is none
+func (d *DB) pickReplica() *replica {
+	n := uint64(len(d.replicas))
+	if n == 0 {
+		return nil
+	}
+	now := time.Now()
+	// counter skips unhealthy replicas as well, so that load is spread evenly among healthy ones
+	for i := uint64(0); i < n; i++ {
+		if r := d.replicas[d.nextReplica.Add(1)%n]; r.healthy(now) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (d *DB) closeReplicas() error {
+	var errs []error
+	for _, r := range d.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// replica returns replica for reads of t, or nil if reads should go to t.exe
+func (t *Table) replica(ctx context.Context) *replica {
+	if t.db == nil || len(t.db.replicas) == 0 || t.exe != ContextExecutor(t.db.db) || isPrimaryContext(ctx) {
+		return nil
+	}
+	return t.db.pickReplica()
+}
+
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
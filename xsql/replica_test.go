@@ -0,0 +1,91 @@
+package xsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_pickReplica(t *testing.T) {
+	d := &DB{}
+	if d.pickReplica() != nil {
+		t.Fatal("expected nil")
+	}
+	r1, r2, r3 := &replica{}, &replica{}, &replica{}
+	d.replicas = []*replica{r1, r2, r3}
+	if !r2.failed(fmt.Errorf("query: %w", driver.ErrBadConn)) {
+		t.Fatal("expected conn error")
+	}
+	if r1.failed(context.Canceled) {
+		t.Fatal("unexpected conn error")
+	}
+	counts := map[*replica]int{}
+	for i := 0; i < 6; i++ {
+		counts[d.pickReplica()]++
+	}
+	if counts[r1] != 3 || counts[r3] != 3 {
+		t.Fatal(counts)
+	}
+
+	r1.failed(driver.ErrBadConn)
+	r3.failed(driver.ErrBadConn)
+	if d.pickReplica() != nil {
+		t.Fatal("expected nil")
+	}
+}
+
+type replicaTestItem struct {
+	ID   int64 `sql:"primary key,auto_increment"`
+	Code string
+	Name string
+}
+
+func TestDB_Replica(t *testing.T) {
+	const schema = "CREATE TABLE replica_test_items(id INTEGER PRIMARY KEY AUTOINCREMENT, code TEXT UNIQUE, name TEXT)"
+	db := newTestDB(t)
+	db.MustExec(schema)
+	r, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "replica.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Exec("INSERT INTO replica_test_items(code, name) VALUES ('a', 'replica')"); err != nil {
+		t.Fatal(err)
+	}
+	db.AddReplicaDB(r)
+
+	// INSERT ... RETURNING writes, so it must go to the primary
+	item := &replicaTestItem{Code: "a", Name: "primary"}
+	if err = db.Upsert(item); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.BatchInsert([]*replicaTestItem{{Code: "b", Name: "primary"}}); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err = r.QueryRow("SELECT COUNT(*) FROM replica_test_items").Scan(&count); err != nil || count != 1 {
+		t.Fatal(count, err)
+	}
+
+	var got replicaTestItem
+	if err = db.SelectOne(&got, "code=?", "a"); err != nil || got.Name != "replica" {
+		t.Fatal(got, err)
+	}
+	if n, err := db.Table(&got).Count(""); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if err = db.SelectOneContext(WithPrimary(context.Background()), &got, "id=?", item.ID); err != nil || got.Name != "primary" {
+		t.Fatal(got, err)
+	}
+	err = db.InTx(context.Background(), func(ctx context.Context, tx *Tx) error {
+		return tx.SelectOne(&got, "code=?", "b")
+	})
+	if err != nil || got.Name != "primary" {
+		t.Fatal(got, err)
+	}
+}
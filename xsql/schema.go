@@ -0,0 +1,431 @@
+package xsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SchemaChange is a difference between struct tags and live schema of a table
+type SchemaChange struct {
+	Table string
+	// SQL is DDL to apply the change, it's empty for destructive changes
+	SQL string
+	// Destructive changes may lose data, e.g. dropping or altering columns. They are reported but never applied
+	Destructive bool
+	Description string
+}
+
+func (c *SchemaChange) String() string {
+	if c.SQL != "" {
+		return c.SQL
+	}
+	return c.Table + ": " + c.Description
+}
+
+type tableSchema struct {
+	columns map[string]*liveColumn
+	indexes map[string]bool
+}
+
+type liveColumn struct {
+	typ     string
+	notNull bool
+}
+
+// CreateTable creates table and indexes of record if they don't exist
+// Column types are derived from field types and sql tags: primary key, auto_increment, json, nullable, index and unique
+// index, version and deleted_at are column names if they're the first element of a tag,
+// e.g. sql:"index" names column index while sql:",index" indexes the column
+func (d *DB) CreateTable(record any) error {
+	return d.CreateTableContext(context.Background(), record)
+}
+
+func (d *DB) CreateTableContext(ctx context.Context, record any) error {
+	for _, query := range createTableSQL(d.driverName, getTableName(record), getRecordColumnInfo(record)) {
+		if _, err := d.exec(ctx, d.db, query, nil); err != nil {
+			return fmt.Errorf("%s: %w", query, err)
+		}
+	}
+	return nil
+}
+
+// PlanSchema compares tables of records with live schema, and returns changes without applying them
+func (d *DB) PlanSchema(records ...any) ([]*SchemaChange, error) {
+	return d.PlanSchemaContext(context.Background(), records...)
+}
+
+func (d *DB) PlanSchemaContext(ctx context.Context, records ...any) ([]*SchemaChange, error) {
+	var changes []*SchemaChange
+	for _, record := range records {
+		l, err := d.diffSchema(ctx, getTableName(record), getRecordColumnInfo(record))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, l...)
+	}
+	return changes, nil
+}
+
+// SyncSchema creates missing tables, columns and indexes of records
+// Destructive changes are not applied but returned with applied changes
+func (d *DB) SyncSchema(records ...any) ([]*SchemaChange, error) {
+	return d.SyncSchemaContext(context.Background(), records...)
+}
+
+func (d *DB) SyncSchemaContext(ctx context.Context, records ...any) ([]*SchemaChange, error) {
+	changes, err := d.PlanSchemaContext(ctx, records...)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		if c.SQL == "" {
+			continue
+		}
+		if _, err = d.exec(ctx, d.db, c.SQL, nil); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.SQL, err)
+		}
+	}
+	return changes, nil
+}
+
+func getRecordColumnInfo(record any) *columnInfo {
+	typ := reflect.TypeOf(record)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return getColumnInfo(typ)
+}
+
+func (d *DB) diffSchema(ctx context.Context, table string, info *columnInfo) ([]*SchemaChange, error) {
+	live, err := loadTableSchema(ctx, d.db, d.driverName, table)
+	if err != nil {
+		return nil, fmt.Errorf("load schema of %s: %w", table, err)
+	}
+	if live == nil {
+		var changes []*SchemaChange
+		for _, query := range createTableSQL(d.driverName, table, info) {
+			changes = append(changes, &SchemaChange{Table: table, SQL: query, Description: "create table"})
+		}
+		return changes, nil
+	}
+
+	var changes []*SchemaChange
+	for i, name := range info.names {
+		c := live.columns[name]
+		if c == nil {
+			changes = append(changes, &SchemaChange{
+				Table:       table,
+				SQL:         "ALTER TABLE " + table + " ADD COLUMN " + columnDefinition(d.driverName, info, i, true),
+				Description: "add column " + name,
+			})
+			continue
+		}
+
+		typ, _ := columnType(d.driverName, info, i)
+		if typeClass(typ) != typeClass(c.typ) {
+			changes = append(changes, &SchemaChange{
+				Table:       table,
+				Destructive: true,
+				Description: fmt.Sprintf("column %s type %s differs from %s", name, c.typ, typ),
+			})
+		}
+		if notNull := IndexOfString(info.nullableNames, name) < 0; notNull != c.notNull && IndexOfString(info.pkNames, name) < 0 {
+			changes = append(changes, &SchemaChange{
+				Table:       table,
+				Destructive: true,
+				Description: fmt.Sprintf("column %s nullability differs: NOT NULL is %t in struct", name, notNull),
+			})
+		}
+	}
+	for _, name := range sortedKeys(live.columns) {
+		if _, ok := info.nameToIndex[name]; !ok {
+			changes = append(changes, &SchemaChange{
+				Table:       table,
+				Destructive: true,
+				Description: "column " + name + " is not in struct",
+			})
+		}
+	}
+
+	indexes := tableIndexes(table, info)
+	names := make(map[string]bool, len(indexes))
+	for _, idx := range indexes {
+		names[strings.ToLower(idx.name)] = true
+		if !live.indexes[strings.ToLower(idx.name)] {
+			changes = append(changes, &SchemaChange{
+				Table:       table,
+				SQL:         createIndexSQL(d.driverName, table, idx),
+				Description: "create index " + idx.name,
+			})
+		}
+	}
+	// only indexes created by xsql are reported
+	for _, name := range sortedKeys(live.indexes) {
+		if !names[name] && (strings.HasPrefix(name, "idx_"+table+"_") || strings.HasPrefix(name, "ux_"+table+"_")) {
+			changes = append(changes, &SchemaChange{
+				Table:       table,
+				Destructive: true,
+				Description: "index " + name + " is not in struct",
+			})
+		}
+	}
+	return changes, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func createTableSQL(driverName, table string, info *columnInfo) []string {
+	var b strings.Builder
+	b.WriteString("CREATE TABLE IF NOT EXISTS ")
+	b.WriteString(table)
+	b.WriteString(" (")
+	for i := range info.names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(columnDefinition(driverName, info, i, false))
+	}
+	if len(info.pkNames) > 0 && info.aiName == "" {
+		b.WriteString(", PRIMARY KEY (")
+		b.WriteString(strings.Join(info.pkNames, ", "))
+		b.WriteString(")")
+	}
+	b.WriteString(")")
+	l := []string{b.String()}
+	for _, idx := range tableIndexes(table, info) {
+		l = append(l, createIndexSQL(driverName, table, idx))
+	}
+	return l
+}
+
+type indexDef struct {
+	name   string
+	column string
+	unique bool
+}
+
+func tableIndexes(table string, info *columnInfo) []*indexDef {
+	var l []*indexDef
+	for _, name := range info.uniqueNames {
+		l = append(l, &indexDef{name: "ux_" + table + "_" + name, column: name, unique: true})
+	}
+	for _, name := range info.indexNames {
+		l = append(l, &indexDef{name: "idx_" + table + "_" + name, column: name})
+	}
+	return l
+}
+
+func createIndexSQL(driverName, table string, idx *indexDef) string {
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if idx.unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	// mysql doesn't support IF NOT EXISTS for index
+	if !isMySQL(driverName) {
+		b.WriteString("IF NOT EXISTS ")
+	}
+	b.WriteString(idx.name + " ON " + table + " (" + idx.column + ")")
+	return b.String()
+}
+
+// columnDefinition returns column name with type and constraints. adding is true for ALTER TABLE ADD COLUMN,
+// and NOT NULL column gets zero default value so that existing rows are valid
+func columnDefinition(driverName string, info *columnInfo, i int, adding bool) string {
+	name := info.names[i]
+	if name == info.aiName {
+		switch {
+		case isSQLite(driverName):
+			return name + " INTEGER PRIMARY KEY AUTOINCREMENT"
+		case isPostgres(driverName):
+			return name + " BIGSERIAL PRIMARY KEY"
+		default:
+			return name + " BIGINT AUTO_INCREMENT PRIMARY KEY"
+		}
+	}
+
+	typ, zero := columnType(driverName, info, i)
+	def := name + " " + typ
+	if IndexOfString(info.nullableNames, name) < 0 {
+		def += " NOT NULL"
+		if adding && zero != "" {
+			def += " DEFAULT " + zero
+		}
+	}
+	return def
+}
+
+// columnType returns SQL type and zero value literal of the column
+func columnType(driverName string, info *columnInfo, i int) (typ string, zero string) {
+	name := info.names[i]
+	if IndexOfString(info.jsonNames, name) >= 0 {
+		switch {
+		case isPostgres(driverName):
+			return "JSONB", "'null'"
+		case isMySQL(driverName):
+			// JSON column cannot have literal default value in mysql
+			return "JSON", ""
+		default:
+			return "TEXT", "'null'"
+		}
+	}
+
+	switch info.types[i].Kind() {
+	case reflect.Bool:
+		if isSQLite(driverName) {
+			return "BOOLEAN", "0"
+		}
+		return "BOOLEAN", "FALSE"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		if isSQLite(driverName) {
+			return "INTEGER", "0"
+		}
+		return "BIGINT", "0"
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return "INTEGER", "0"
+	case reflect.Float32:
+		return "REAL", "0"
+	case reflect.Float64:
+		if isPostgres(driverName) {
+			return "DOUBLE PRECISION", "0"
+		} else if isMySQL(driverName) {
+			return "DOUBLE", "0"
+		}
+		return "REAL", "0"
+	case reflect.String:
+		if isMySQL(driverName) {
+			if IndexOfString(info.pkNames, name) >= 0 || IndexOfString(info.indexNames, name) >= 0 || IndexOfString(info.uniqueNames, name) >= 0 {
+				return "VARCHAR(255)", "''"
+			}
+			return "TEXT", ""
+		}
+		return "TEXT", "''"
+	default:
+		// []byte
+		if isPostgres(driverName) {
+			return "BYTEA", "''"
+		}
+		if isMySQL(driverName) {
+			return "BLOB", ""
+		}
+		return "BLOB", "x''"
+	}
+}
+
+// typeClass normalizes SQL type for comparison
+func typeClass(typ string) string {
+	typ = strings.ToUpper(typ)
+	switch {
+	case strings.Contains(typ, "BOOL"), typ == "TINYINT(1)":
+		return "bool"
+	case strings.Contains(typ, "INT"), strings.Contains(typ, "SERIAL"):
+		return "int"
+	case strings.Contains(typ, "REAL"), strings.Contains(typ, "FLOA"), strings.Contains(typ, "DOUB"), strings.Contains(typ, "NUMERIC"), strings.Contains(typ, "DECIMAL"):
+		return "float"
+	case strings.Contains(typ, "JSON"):
+		return "json"
+	case strings.Contains(typ, "BLOB"), strings.Contains(typ, "BYTEA"), strings.Contains(typ, "BINARY"):
+		return "bytes"
+	default:
+		return "text"
+	}
+}
+
+// loadTableSchema returns nil if table doesn't exist
+func loadTableSchema(ctx context.Context, db *sql.DB, driverName, table string) (*tableSchema, error) {
+	var columnQuery, indexQuery string
+	switch {
+	case isSQLite(driverName):
+		return loadSQLiteSchema(ctx, db, table)
+	case isPostgres(driverName):
+		columnQuery = `SELECT column_name, data_type, is_nullable FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = $1`
+		indexQuery = `SELECT indexname FROM pg_indexes WHERE schemaname = current_schema() AND tablename = $1`
+	case isMySQL(driverName):
+		columnQuery = `SELECT column_name, column_type, is_nullable FROM information_schema.columns
+WHERE table_schema = DATABASE() AND table_name = ?`
+		indexQuery = `SELECT DISTINCT index_name FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ?`
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", driverName)
+	}
+
+	s := &tableSchema{columns: map[string]*liveColumn{}, indexes: map[string]bool{}}
+	rows, err := db.QueryContext(ctx, columnQuery, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, typ, nullable string
+		if err = rows.Scan(&name, &typ, &nullable); err != nil {
+			return nil, err
+		}
+		s.columns[strings.ToLower(name)] = &liveColumn{typ: typ, notNull: nullable == "NO"}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.columns) == 0 {
+		return nil, nil
+	}
+	if s.indexes, err = queryIndexNames(ctx, db, indexQuery, table); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func loadSQLiteSchema(ctx context.Context, db *sql.DB, table string) (*tableSchema, error) {
+	s := &tableSchema{columns: map[string]*liveColumn{}}
+	rows, err := db.QueryContext(ctx, "SELECT name, type, \"notnull\" FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, typ string
+		var notNull bool
+		if err = rows.Scan(&name, &typ, &notNull); err != nil {
+			return nil, err
+		}
+		s.columns[strings.ToLower(name)] = &liveColumn{typ: typ, notNull: notNull}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.columns) == 0 {
+		return nil, nil
+	}
+	if s.indexes, err = queryIndexNames(ctx, db, "SELECT name FROM pragma_index_list(?)", table); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func queryIndexNames(ctx context.Context, db *sql.DB, query, table string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,60 @@
+package xsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+type schemaTestItem struct {
+	ID     int64    `sql:"primary key,auto_increment"`
+	Email  string   `sql:"unique"`
+	Name   string   `sql:",index"`
+	Labels []string `sql:"json"`
+	Note   string   `sql:"nullable"`
+	Score  float64
+}
+
+func TestCreateTableSQL(t *testing.T) {
+	info := getRecordColumnInfo(&schemaTestItem{})
+	tests := []struct {
+		driverName string
+		sqls       []string
+	}{
+		{SQLITE, []string{
+			"CREATE TABLE IF NOT EXISTS items (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT NOT NULL, name TEXT NOT NULL, labels TEXT NOT NULL, note TEXT, score REAL NOT NULL)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS ux_items_email ON items (email)",
+			"CREATE INDEX IF NOT EXISTS idx_items_name ON items (name)",
+		}},
+		{POSTGRES, []string{
+			"CREATE TABLE IF NOT EXISTS items (id BIGSERIAL PRIMARY KEY, email TEXT NOT NULL, name TEXT NOT NULL, labels JSONB NOT NULL, note TEXT, score DOUBLE PRECISION NOT NULL)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS ux_items_email ON items (email)",
+			"CREATE INDEX IF NOT EXISTS idx_items_name ON items (name)",
+		}},
+		{MYSQL, []string{
+			"CREATE TABLE IF NOT EXISTS items (id BIGINT AUTO_INCREMENT PRIMARY KEY, email VARCHAR(255) NOT NULL, name VARCHAR(255) NOT NULL, labels JSON NOT NULL, note TEXT, score DOUBLE NOT NULL)",
+			"CREATE UNIQUE INDEX ux_items_email ON items (email)",
+			"CREATE INDEX idx_items_name ON items (name)",
+		}},
+	}
+	for _, test := range tests {
+		sqls := createTableSQL(test.driverName, "items", info)
+		if !reflect.DeepEqual(sqls, test.sqls) {
+			t.Fatalf("%s: %q", test.driverName, sqls)
+		}
+	}
+}
+
+func TestParseColumnInfo_IndexName(t *testing.T) {
+	type item struct {
+		ID    int64 `sql:"primary key"`
+		Index int64 `sql:"index"`
+		Pos   int64 `sql:"position,index"`
+	}
+	info := getRecordColumnInfo(&item{})
+	if !reflect.DeepEqual(info.names, []string{"id", "index", "position"}) {
+		t.Fatal(info.names)
+	}
+	if !reflect.DeepEqual(info.indexNames, []string{"position"}) {
+		t.Fatal(info.indexNames)
+	}
+}
@@ -2,6 +2,7 @@ package xsql
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
@@ -9,6 +10,9 @@ import (
 	"text/template"
 )
 
+// Debug enables logging statements of all DBs
+//
+// Deprecated: use DB.SetDebug
 var Debug = false
 
 const (
@@ -27,6 +31,13 @@ type Executor interface {
 	QueryRow(query string, args ...any) *sql.Row
 }
 
+// ContextExecutor is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type ContextExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func MustPrepare(db *sql.DB, format string, args ...any) *sql.Stmt {
 	query := fmt.Sprintf(format, args...)
 	stmt, err := db.Prepare(query)
@@ -41,6 +52,9 @@ type readDirAndFileFS interface {
 	fs.ReadFileFS
 }
 
+// ExecSQLDir executes all .sql files in target on every call
+//
+// Deprecated: use Migrator which records applied migrations and supports up and down migrations
 func ExecSQLDir(db *sql.DB, target fs.FS, params map[string]any) error {
 	return fs.WalkDir(target, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
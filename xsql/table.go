@@ -2,13 +2,17 @@ package xsql
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"reflect"
+	"regexp"
+	"slices"
 	"strings"
 
+	"go.olapie.com/x/xerror"
 	"go.olapie.com/x/xname"
 	"go.olapie.com/x/xreflect"
 )
@@ -61,30 +65,72 @@ func getTableNameByType(typ reflect.Type) string {
 	return xname.Plural(xname.ToSnake(typ.Name()))
 }
 
+// Table reads and writes records of a table
+// Where strings of its methods use ? placeholders which are converted by Rebind, and ?? is a literal ? with postgres
 type Table struct {
-	exe        Executor
+	db         *DB
+	exe        ContextExecutor
 	driverName string
 	name       string
+
+	// info is column info of the record which table is created by. It's used to apply soft delete in Delete and Count
+	info        *columnInfo
+	withDeleted bool
+}
+
+func newTable(db *DB, exe ContextExecutor, nameOrRecord any) *Table {
+	t := &Table{
+		db:         db,
+		exe:        exe,
+		driverName: db.driverName,
+	}
+	if name, ok := nameOrRecord.(string); ok {
+		t.name = name
+	} else {
+		t.name = getTableName(nameOrRecord)
+		t.info = typeColumnInfo(reflect.TypeOf(nameOrRecord))
+	}
+	return t
+}
+
+// WithDeleted returns a copy of t which includes soft deleted records in Select and Count,
+// and its Delete removes records permanently
+func (t *Table) WithDeleted() *Table {
+	c := *t
+	c.withDeleted = true
+	return &c
 }
 
 func (t *Table) Insert(record any) error {
+	return t.InsertContext(context.Background(), record)
+}
+
+// InsertContext inserts record. Version of record starts from 1 if it's zero
+func (t *Table) InsertContext(ctx context.Context, record any) (err error) {
+	v := getStructValue(record)
+	info := getColumnInfo(v.Type())
+	if len(info.versionName) > 0 {
+		if version := v.FieldByIndex(info.nameToIndex[info.versionName]); version.IsZero() {
+			addVersion(version, 1)
+			defer func() {
+				if err != nil {
+					version.SetZero()
+				}
+			}()
+		}
+	}
+
 	query, values, err := t.prepareInsertQuery(record)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	if Debug {
-		log.Println(query, toReadableArgs(values))
-	}
-
-	result, err := t.exe.Exec(query, values...)
+	result, err := t.exec(ctx, query, values...)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	v := getStructValue(record)
-	info := getColumnInfo(v.Type())
 	if len(info.aiName) > 0 && v.FieldByIndex(info.nameToIndex[info.aiName]).Int() == 0 {
 		id, err := result.LastInsertId()
 		if err != nil {
@@ -130,6 +176,13 @@ func (t *Table) prepareInsertQuery(record any) (string, []any, error) {
 }
 
 func (t *Table) Update(record any) error {
+	return t.UpdateContext(context.Background(), record)
+}
+
+// UpdateContext updates record by primary key
+// If record has version column, it's updated only if version is not changed, otherwise xerror.Conflict is returned.
+// Version of record is increased after update. Soft deleted records are not updated unless t.WithDeleted is used
+func (t *Table) UpdateContext(ctx context.Context, record any) error {
 	v := getStructValue(record)
 	info := getColumnInfo(v.Type())
 	if len(info.pkNames) == 0 {
@@ -140,12 +193,27 @@ func (t *Table) Update(record any) error {
 	buf.WriteString("UPDATE ")
 	buf.WriteString(t.name)
 	buf.WriteString(" SET ")
-	for i, c := range info.notPKNames {
-		if i > 0 {
+	args := make([]any, 0, len(info.indexes)+1)
+	for _, name := range info.notPKNames {
+		if name == info.versionName {
+			continue
+		}
+		if len(args) > 0 {
 			buf.WriteString(", ")
 		}
-		buf.WriteString(c)
+		buf.WriteString(name)
 		buf.WriteString(" = ?")
+		fv, err := t.getFieldValueByName(v, info, name)
+		if err != nil {
+			return err
+		}
+		args = append(args, fv)
+	}
+	if len(info.versionName) > 0 {
+		if len(args) > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(info.versionName + " = " + info.versionName + " + 1")
 	}
 
 	buf.WriteString(" WHERE ")
@@ -155,41 +223,78 @@ func (t *Table) Update(record any) error {
 		}
 		buf.WriteString(c)
 		buf.WriteString(" = ?")
+		args = append(args, v.FieldByIndex(info.nameToIndex[c]).Interface())
 	}
 
-	args := make([]any, 0, len(info.indexes))
-	for _, name := range info.notPKNames {
-		fv, err := t.getFieldValueByName(v, info, name)
-		if err != nil {
-			return err
-		}
-		args = append(args, fv)
+	var version reflect.Value
+	if len(info.versionName) > 0 {
+		version = v.FieldByIndex(info.nameToIndex[info.versionName])
+		buf.WriteString(" and ")
+		buf.WriteString(info.versionName)
+		buf.WriteString(" = ?")
+		args = append(args, version.Interface())
 	}
-
-	for _, name := range info.pkNames {
-		args = append(args, v.FieldByIndex(info.nameToIndex[name]).Interface())
+	if filter := t.softDeleteFilter(info); len(filter) > 0 {
+		buf.WriteString(" and ")
+		buf.WriteString(filter)
 	}
 
 	query := buf.String()
-	if Debug {
-		log.Println(query, toReadableArgs(args))
+	result, err := t.exec(ctx, query, args...)
+	if err != nil || !version.IsValid() {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return xerror.Conflict("%s: record has been modified or deleted", t.name)
+	}
+	addVersion(version, 1)
+	return nil
+}
+
+func addVersion(v reflect.Value, delta int) {
+	if v.CanInt() {
+		v.SetInt(v.Int() + int64(delta))
+	} else {
+		v.SetUint(v.Uint() + uint64(delta))
 	}
-	_, err := t.exe.Exec(query, args...)
-	return err
 }
 
 func (t *Table) Save(record any) error {
+	return t.SaveContext(context.Background(), record)
+}
+
+// SaveContext inserts or replaces record
+// If record has version column, it's inserted if version is zero, otherwise it's updated by UpdateContext
+// If record has soft delete column, it's upserted by primary key without updating soft delete column,
+// so a soft deleted row is not restored by saving a stale record
+func (t *Table) SaveContext(ctx context.Context, record any) error {
+	v := getStructValue(record)
+	info := getColumnInfo(v.Type())
+	if len(info.versionName) > 0 {
+		if v.FieldByIndex(info.nameToIndex[info.versionName]).IsZero() {
+			return t.InsertContext(ctx, record)
+		}
+		return t.UpdateContext(ctx, record)
+	}
+	if len(info.deletedAtName) > 0 {
+		return t.UpsertContext(ctx, record)
+	}
+
 	switch t.driverName {
 	case "mysql":
-		return t.mysqlSave(record)
+		return t.mysqlSave(ctx, record)
 	case "sqlite", "sqlite3":
-		return t.sqliteSave(record)
+		return t.sqliteSave(ctx, record)
 	default:
 		panic("Save operation is not supported for driver: " + t.driverName)
 	}
 }
 
-func (t *Table) mysqlSave(record any) error {
+func (t *Table) mysqlSave(ctx context.Context, record any) error {
 	query, values, err := t.prepareInsertQuery(record)
 	if err != nil {
 		log.Println(err)
@@ -221,11 +326,7 @@ func (t *Table) mysqlSave(record any) error {
 
 	query = buf.String()
 
-	if Debug {
-		log.Println(query, toReadableArgs(values))
-	}
-
-	result, err := t.exe.Exec(query, values...)
+	result, err := t.exec(ctx, query, values...)
 	if len(info.aiName) > 0 && v.FieldByIndex(info.nameToIndex[info.aiName]).Int() == 0 {
 		id, err := result.LastInsertId()
 		if err != nil {
@@ -237,7 +338,7 @@ func (t *Table) mysqlSave(record any) error {
 	return err
 }
 
-func (t *Table) sqliteSave(record any) error {
+func (t *Table) sqliteSave(ctx context.Context, record any) error {
 	query, values, err := t.prepareInsertQuery(record)
 	if err != nil {
 		log.Println(err)
@@ -248,11 +349,7 @@ func (t *Table) sqliteSave(record any) error {
 	v := getStructValue(record)
 	info := getColumnInfo(v.Type())
 
-	if Debug {
-		log.Println(query, toReadableArgs(values))
-	}
-
-	result, err := t.exe.Exec(query, values...)
+	result, err := t.exec(ctx, query, values...)
 	if len(info.aiName) > 0 && v.FieldByIndex(info.nameToIndex[info.aiName]).Int() == 0 {
 		id, err := result.LastInsertId()
 		if err != nil {
@@ -265,6 +362,30 @@ func (t *Table) sqliteSave(record any) error {
 }
 
 func (t *Table) Select(records any, where string, args ...any) error {
+	return t.SelectContext(context.Background(), records, where, args...)
+}
+
+// SelectContext selects records matched by where. Soft deleted records are excluded unless t.WithDeleted is used
+func (t *Table) SelectContext(ctx context.Context, records any, where string, args ...any) error {
+	where = t.scopeWhere(typeColumnInfo(reflect.TypeOf(records)), where)
+	return t.selectRecords(ctx, records, whereClause(where), args)
+}
+
+// SelectBy selects records matched by q
+func (t *Table) SelectBy(records any, q *Query) error {
+	return t.SelectByContext(context.Background(), records, q)
+}
+
+func (t *Table) SelectByContext(ctx context.Context, records any, q *Query) error {
+	q = t.scopeQuery(typeColumnInfo(reflect.TypeOf(records)), q)
+	clause, args := q.build(t.driverName, true)
+	if err := t.selectRecords(ctx, records, clause, args); err != nil || q == nil {
+		return err
+	}
+	return t.preload(ctx, records, q.preloads)
+}
+
+func (t *Table) selectRecords(ctx context.Context, records any, clause string, args []any) error {
 	v := reflect.ValueOf(records)
 	if v.Kind() != reflect.Ptr {
 		panic("must be a pointer to slice")
@@ -297,17 +418,10 @@ func (t *Table) Select(records any, where string, args ...any) error {
 	buf.WriteString(strings.Join(fi.names, ", "))
 	buf.WriteString(" FROM ")
 	buf.WriteString(t.name)
-	if len(where) > 0 {
-		buf.WriteString(" WHERE ")
-		buf.WriteString(where)
-	}
+	buf.WriteString(clause)
 	query := buf.String()
 
-	if Debug {
-		log.Println(query, toReadableArgs(args))
-	}
-
-	rows, err := t.exe.Query(query, args...)
+	rows, err := t.read(ctx, query, args...)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -318,99 +432,191 @@ func (t *Table) Select(records any, where string, args ...any) error {
 		v.Set(reflect.New(sliceType))
 	}
 	sliceValue := v.Elem()
-	fields := make([]any, len(fi.indexes))
 	for rows.Next() {
-		ptrToElem := xreflect.DeepNew(elemType)
-		elem := ptrToElem.Elem()
-		for i, idx := range fi.indexes {
-			if IndexOfString(fi.jsonNames, fi.names[i]) >= 0 {
-				var data []byte
-				fields[i] = &data
-			} else if IndexOfString(fi.nullableNames, fi.names[i]) >= 0 {
-				switch elem.FieldByIndex(idx).Kind() {
-				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-					var v sql.NullInt64
-					fields[i] = &v
-				case reflect.Bool:
-					var b sql.NullBool
-					fields[i] = &b
-				case reflect.Float32, reflect.Float64:
-					var v sql.NullFloat64
-					fields[i] = &v
-				case reflect.String:
-					var v sql.NullString
-					fields[i] = &v
-				default:
-					panic("invalid nullable type" + fmt.Sprint(elem.FieldByIndex(idx).Type()))
-				}
-			} else {
-				fields[i] = elem.FieldByIndex(idx).Addr().Interface()
-			}
-		}
-
-		err = rows.Scan(fields...)
+		ptrToElem, err := scanRecord(rows, fi, elemType)
 		if err != nil {
 			log.Println(err)
 			return err
 		}
 
-		for _, name := range fi.jsonNames {
-			idx := fi.nameToIndex[name]
-			i := IndexOfString(fi.names, name)
-			addr := fields[i]
-			data := reflect.ValueOf(addr).Elem().Interface()
-			err = json.Unmarshal(data.([]byte), elem.FieldByIndex(idx).Addr().Interface())
-			if err != nil {
-				log.Println(err)
-				return err
+		if isPtr {
+			sliceValue = reflect.Append(sliceValue, ptrToElem)
+		} else {
+			sliceValue = reflect.Append(sliceValue, ptrToElem.Elem())
+		}
+	}
+	v.Elem().Set(sliceValue)
+	return nil
+}
+
+// newRecord returns pointer to a new value of typ like xreflect.DeepNew, except that relation fields are left zero
+// Relations are loaded by Preload, and allocating them would add an empty element to has_many slices,
+// or never end if relations refer to each other
+func newRecord(typ reflect.Type) reflect.Value {
+	v := reflect.New(typ)
+	e := v.Elem()
+	for e.Kind() == reflect.Ptr {
+		e.Set(reflect.New(e.Type().Elem()))
+		e = e.Elem()
+	}
+	if e.Kind() != reflect.Struct {
+		return v
+	}
+	newFields(e, getColumnInfo(e.Type()).relations, nil)
+	return v
+}
+
+// newFields allocates fields of struct v like xreflect.DeepNew except relations. index is the field index of v in the record
+func newFields(v reflect.Value, relations map[string]*relationInfo, index fieldIndex) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !f.CanSet() {
+			continue
+		}
+		fi := append(index[:len(index):len(index)], i)
+		isRelation, hasRelations := relationOf(relations, fi)
+		switch {
+		case isRelation:
+			continue
+		case hasRelations:
+			// embedded struct which has relation fields
+			if f.Kind() == reflect.Ptr {
+				f.Set(reflect.New(f.Type().Elem()))
+				f = f.Elem()
 			}
+			newFields(f, relations, fi)
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Ptr:
+			f.Set(xreflect.DeepNew(f.Type().Elem()))
+		case reflect.Struct:
+			f.Set(xreflect.DeepNew(f.Type()).Elem())
+		case reflect.Slice:
+			f.Set(reflect.Append(reflect.New(f.Type()).Elem(), xreflect.DeepNew(f.Type().Elem()).Elem()))
 		}
+	}
+}
 
-		for _, name := range fi.nullableNames {
-			idx := fi.nameToIndex[name]
-			i := IndexOfString(fi.names, name)
-			addr := fields[i]
-			switch v := reflect.ValueOf(addr).Elem().Interface().(type) {
-			case sql.NullString:
-				if v.Valid {
-					elem.FieldByIndex(idx).SetString(v.String)
-				}
-			case sql.NullFloat64:
-				if v.Valid {
-					elem.FieldByIndex(idx).SetFloat(v.Float64)
-				}
-			case sql.NullBool:
-				if v.Valid {
-					elem.FieldByIndex(idx).SetBool(v.Bool)
-				}
-			case sql.NullInt64:
-				if v.Valid {
-					elem.FieldByIndex(idx).SetInt(v.Int64)
-				}
+// relationOf reports whether the field of index is a relation, or has relation fields
+func relationOf(relations map[string]*relationInfo, index fieldIndex) (isRelation, hasRelations bool) {
+	for _, rel := range relations {
+		if len(rel.index) < len(index) || !slices.Equal(rel.index[:len(index)], index) {
+			continue
+		}
+		if len(rel.index) == len(index) {
+			return true, false
+		}
+		hasRelations = true
+	}
+	return false, hasRelations
+}
+
+// scanRecord scans current row into a new value of elemType, and returns pointer to it
+func scanRecord(rows ColumnScanner, fi *columnInfo, elemType reflect.Type) (reflect.Value, error) {
+	fields := make([]any, len(fi.indexes))
+	ptrToElem := newRecord(elemType)
+	elem := ptrToElem.Elem()
+	for i, idx := range fi.indexes {
+		if IndexOfString(fi.jsonNames, fi.names[i]) >= 0 {
+			var data []byte
+			fields[i] = &data
+		} else if IndexOfString(fi.nullableNames, fi.names[i]) >= 0 {
+			switch elem.FieldByIndex(idx).Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				var v sql.NullInt64
+				fields[i] = &v
+			case reflect.Bool:
+				var b sql.NullBool
+				fields[i] = &b
+			case reflect.Float32, reflect.Float64:
+				var v sql.NullFloat64
+				fields[i] = &v
+			case reflect.String:
+				var v sql.NullString
+				fields[i] = &v
 			default:
-				panic("invalid type:" + fmt.Sprint(v))
+				panic("invalid nullable type" + fmt.Sprint(elem.FieldByIndex(idx).Type()))
 			}
+		} else {
+			fields[i] = elem.FieldByIndex(idx).Addr().Interface()
 		}
+	}
 
-		if isPtr {
-			sliceValue = reflect.Append(sliceValue, ptrToElem)
-		} else {
-			sliceValue = reflect.Append(sliceValue, elem)
+	if err := rows.Scan(fields...); err != nil {
+		return reflect.Value{}, err
+	}
+
+	for _, name := range fi.jsonNames {
+		idx := fi.nameToIndex[name]
+		i := IndexOfString(fi.names, name)
+		addr := fields[i]
+		data := reflect.ValueOf(addr).Elem().Interface()
+		if err := json.Unmarshal(data.([]byte), elem.FieldByIndex(idx).Addr().Interface()); err != nil {
+			return reflect.Value{}, err
 		}
 	}
-	v.Elem().Set(sliceValue)
-	return nil
+
+	for _, name := range fi.nullableNames {
+		idx := fi.nameToIndex[name]
+		i := IndexOfString(fi.names, name)
+		addr := fields[i]
+		switch v := reflect.ValueOf(addr).Elem().Interface().(type) {
+		case sql.NullString:
+			if v.Valid {
+				elem.FieldByIndex(idx).SetString(v.String)
+			}
+		case sql.NullFloat64:
+			if v.Valid {
+				elem.FieldByIndex(idx).SetFloat(v.Float64)
+			}
+		case sql.NullBool:
+			if v.Valid {
+				elem.FieldByIndex(idx).SetBool(v.Bool)
+			}
+		case sql.NullInt64:
+			if v.Valid {
+				elem.FieldByIndex(idx).SetInt(v.Int64)
+			}
+		default:
+			panic("invalid type:" + fmt.Sprint(v))
+		}
+	}
+	return ptrToElem, nil
 }
 
 func (t *Table) SelectOne(record any, where string, args ...any) error {
+	return t.SelectOneContext(context.Background(), record, where, args...)
+}
+
+func (t *Table) SelectOneContext(ctx context.Context, record any, where string, args ...any) error {
+	where = t.scopeWhere(typeColumnInfo(reflect.TypeOf(record)), where)
+	return t.selectOne(ctx, record, whereClause(where), args)
+}
+
+// SelectOneBy selects the first record matched by q
+func (t *Table) SelectOneBy(record any, q *Query) error {
+	return t.SelectOneByContext(context.Background(), record, q)
+}
+
+func (t *Table) SelectOneByContext(ctx context.Context, record any, q *Query) error {
+	q = t.scopeQuery(typeColumnInfo(reflect.TypeOf(record)), q)
+	clause, args := q.build(t.driverName, true)
+	if err := t.selectOne(ctx, record, clause, args); err != nil || q == nil {
+		return err
+	}
+	return t.preload(ctx, record, q.preloads)
+}
+
+func (t *Table) selectOne(ctx context.Context, record any, clause string, args []any) error {
 	rv := reflect.ValueOf(record)
 	if rv.Kind() != reflect.Ptr {
 		panic("not pointer to a struct")
 	}
 
 	//Store result in ev. If failed, don't change record's value
-	ev := xreflect.DeepNew(rv.Elem().Type()).Elem()
+	ev := newRecord(rv.Elem().Type()).Elem()
 	elem := ev
 	if elem.Kind() == reflect.Ptr {
 		elem = elem.Elem()
@@ -427,16 +633,9 @@ func (t *Table) SelectOne(record any, where string, args ...any) error {
 	buf.WriteString(strings.Join(info.names, ", "))
 	buf.WriteString(" FROM ")
 	buf.WriteString(t.name)
-	if len(where) > 0 {
-		buf.WriteString(" WHERE ")
-		buf.WriteString(where)
-	}
+	buf.WriteString(clause)
 	query := buf.String()
 
-	if Debug {
-		log.Println(query, toReadableArgs(args))
-	}
-
 	fieldAddrs := make([]any, len(info.indexes))
 	for i, idx := range info.indexes {
 		if IndexOfString(info.jsonNames, info.names[i]) >= 0 {
@@ -464,7 +663,7 @@ func (t *Table) SelectOne(record any, where string, args ...any) error {
 			fieldAddrs[i] = elem.FieldByIndex(idx).Addr().Interface()
 		}
 	}
-	err := t.exe.QueryRow(query, args...).Scan(fieldAddrs...)
+	err := t.readRow(ctx, query, args...).Scan(fieldAddrs...)
 	if err != nil {
 		if err != sql.ErrNoRows {
 			log.Println(err)
@@ -528,22 +727,38 @@ func (t *Table) Query(query string, args ...any) (*Rows, error) {
 }*/
 
 func (t *Table) Delete(where string, args ...any) error {
+	return t.DeleteContext(context.Background(), where, args...)
+}
+
+// DeleteContext deletes records matched by where
+// If t is created by a record with deleted_at column, records are soft deleted by setting deleted_at
+func (t *Table) DeleteContext(ctx context.Context, where string, args ...any) error {
 	if len(where) == 0 {
 		panic("where is empty")
 	}
-	var buf bytes.Buffer
-	buf.WriteString("DELETE FROM ")
-	buf.WriteString(t.name)
-	buf.WriteString(" WHERE ")
-	buf.WriteString(where)
+	return t.delete(ctx, whereClause(t.scopeWhere(t.info, where)), args)
+}
 
-	query := buf.String()
+// DeleteBy deletes records matched by q. ORDER BY, LIMIT and OFFSET of q are ignored
+func (t *Table) DeleteBy(q *Query) error {
+	return t.DeleteByContext(context.Background(), q)
+}
 
-	if Debug {
-		log.Println(query, toReadableArgs(args))
+func (t *Table) DeleteByContext(ctx context.Context, q *Query) error {
+	if q == nil || q.where == nil {
+		panic("where is empty")
 	}
+	clause, args := t.scopeQuery(t.info, q).build(t.driverName, false)
+	return t.delete(ctx, clause, args)
+}
 
-	_, err := t.exe.Exec(query, args...)
+func (t *Table) delete(ctx context.Context, clause string, args []any) error {
+	query := "DELETE FROM " + t.name + clause
+	if len(t.softDeleteFilter(t.info)) > 0 {
+		query = "UPDATE " + t.name + " SET " + t.info.deletedAtName + " = ?" + clause
+		args = append([]any{t.info.deletedValue()}, args...)
+	}
+	_, err := t.exec(ctx, query, args...)
 	if err != nil {
 		log.Println(err)
 	}
@@ -551,21 +766,34 @@ func (t *Table) Delete(where string, args ...any) error {
 }
 
 func (t *Table) Count(where string, args ...any) (int, error) {
+	return t.CountContext(context.Background(), where, args...)
+}
+
+// CountContext counts records matched by where
+// If t is created by a record with deleted_at column, soft deleted records are excluded unless t.WithDeleted is used
+func (t *Table) CountContext(ctx context.Context, where string, args ...any) (int, error) {
+	return t.count(ctx, whereClause(t.scopeWhere(t.info, where)), args)
+}
+
+// CountBy counts records matched by q. ORDER BY, LIMIT and OFFSET of q are ignored
+func (t *Table) CountBy(q *Query) (int, error) {
+	return t.CountByContext(context.Background(), q)
+}
+
+func (t *Table) CountByContext(ctx context.Context, q *Query) (int, error) {
+	clause, args := t.scopeQuery(t.info, q).build(t.driverName, false)
+	return t.count(ctx, clause, args)
+}
+
+func (t *Table) count(ctx context.Context, clause string, args []any) (int, error) {
 	var buf bytes.Buffer
 	buf.WriteString("SELECT COUNT(*) FROM ")
 	buf.WriteString(t.name)
-	if len(where) > 0 {
-		buf.WriteString(" WHERE ")
-		buf.WriteString(where)
-	}
+	buf.WriteString(clause)
 	query := buf.String()
 
-	if Debug {
-		log.Println(query, toReadableArgs(args))
-	}
-
 	var count int
-	err := t.exe.QueryRow(query, args...).Scan(&count)
+	err := t.readRow(ctx, query, args...).Scan(&count)
 	if err != nil {
 		log.Println(err)
 		return 0, err
@@ -574,6 +802,129 @@ func (t *Table) Count(where string, args ...any) (int, error) {
 	return count, nil
 }
 
+func (t *Table) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.db.exec(ctx, t.exe, Rebind(t.driverName, query), args)
+}
+
+func (t *Table) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.db.query(ctx, t.exe, Rebind(t.driverName, query), args)
+}
+
+// read runs a select on a healthy replica if possible, and falls back to t.exe if all replicas are unreachable
+// Statements which write, e.g. INSERT ... RETURNING, must use query instead
+func (t *Table) read(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = Rebind(t.driverName, query)
+	for r := t.replica(ctx); r != nil; r = t.replica(ctx) {
+		rows, err := t.db.query(ctx, r.db, query, args)
+		if !r.failed(err) {
+			return rows, err
+		}
+	}
+	return t.db.query(ctx, t.exe, query, args)
+}
+
+// readRow is the single row version of read
+func (t *Table) readRow(ctx context.Context, query string, args ...any) *sql.Row {
+	query = Rebind(t.driverName, query)
+	for r := t.replica(ctx); r != nil; r = t.replica(ctx) {
+		row := t.db.queryRow(ctx, r.db, query, args)
+		if !r.failed(row.Err()) {
+			return row
+		}
+	}
+	return t.db.queryRow(ctx, t.exe, query, args)
+}
+
+// softDeleteFilter returns condition which excludes soft deleted records, or empty string if soft delete is not applied
+func (t *Table) softDeleteFilter(info *columnInfo) string {
+	if t.withDeleted || info == nil || len(info.deletedAtName) == 0 {
+		return ""
+	}
+	name := info.deletedAtName
+	switch {
+	case IndexOfString(info.nullableNames, name) >= 0:
+		return name + " IS NULL"
+	case info.types[IndexOfString(info.names, name)].Kind() == reflect.Bool:
+		return "NOT " + name
+	default:
+		return name + " = 0"
+	}
+}
+
+// scopeWhere adds soft delete filter to conditions of where, and trailing clauses like ORDER BY and LIMIT are kept after them
+func (t *Table) scopeWhere(info *columnInfo, where string) string {
+	filter := t.softDeleteFilter(info)
+	if len(filter) == 0 {
+		return where
+	}
+	cond, tail := splitWhere(where)
+	if len(cond) > 0 {
+		filter += " AND (" + cond + ")"
+	}
+	if len(tail) > 0 {
+		filter += " " + tail
+	}
+	return filter
+}
+
+var _regexpTrailingClause = regexp.MustCompile(`(?i)^(ORDER\s+BY|GROUP\s+BY|LIMIT|OFFSET|FOR\s+UPDATE)\b`)
+
+// splitWhere splits where into conditions and trailing clauses, which start with a keyword out of quotes and parentheses
+func splitWhere(where string) (cond, tail string) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(where); i++ {
+		c := where[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && (i == 0 || where[i-1] == ' ' || where[i-1] == '\n' || where[i-1] == '\t') &&
+			_regexpTrailingClause.MatchString(where[i:]):
+			return strings.TrimSpace(where[:i]), where[i:]
+		}
+	}
+	return where, ""
+}
+
+// scopeQuery returns a copy of q with soft delete filter
+func (t *Table) scopeQuery(info *columnInfo, q *Query) *Query {
+	filter := t.softDeleteFilter(info)
+	if len(filter) == 0 {
+		return q
+	}
+	scoped := &Query{}
+	if q != nil {
+		*scoped = *q
+	}
+	return scoped.And(Raw(filter))
+}
+
+// typeColumnInfo returns column info of struct type which typ points to or contains, or nil if typ is not about struct
+func typeColumnInfo(typ reflect.Type) *columnInfo {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	return getColumnInfo(typ)
+}
+
+func whereClause(where string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + where
+}
+
 func (t *Table) getFieldValueByName(item reflect.Value, info *columnInfo, name string) (any, error) {
 	k := item.FieldByIndex(info.nameToIndex[name]).Interface()
 	if IndexOfString(info.jsonNames, name) >= 0 {
@@ -597,18 +948,15 @@ func (t *Table) getFieldValueByName(item reflect.Value, info *columnInfo, name s
 }
 
 func toReadableArgs(args []any) []any {
-	if Debug {
-		readableArgs := make([]any, len(args))
-		for i, a := range args {
-			if b, ok := a.([]byte); ok {
-				readableArgs[i] = string(b)
-			} else {
-				readableArgs[i] = a
-			}
+	readableArgs := make([]any, len(args))
+	for i, a := range args {
+		if b, ok := a.([]byte); ok {
+			readableArgs[i] = string(b)
+		} else {
+			readableArgs[i] = a
 		}
-		return readableArgs
 	}
-	return args
+	return readableArgs
 }
 
 func getStructValue(i any) reflect.Value {
@@ -0,0 +1,160 @@
+package xsql
+
+import (
+	"net/http"
+	"testing"
+
+	"go.olapie.com/x/xerror"
+)
+
+type softDeleteTestItem struct {
+	ID        int64 `sql:"primary key"`
+	Name      string
+	Version   int   `sql:",version"`
+	DeletedAt int64 `sql:",deleted_at"`
+}
+
+func TestTable_scope(t *testing.T) {
+	info := getRecordColumnInfo(&softDeleteTestItem{})
+	if info.versionName != "version" || info.deletedAtName != "deleted_at" {
+		t.Fatal(info.versionName, info.deletedAtName)
+	}
+
+	tbl := &Table{name: "items", info: info}
+	if where := tbl.scopeWhere(info, "id = ? OR id = ?"); where != "deleted_at = 0 AND (id = ? OR id = ?)" {
+		t.Fatal(where)
+	}
+	q := Where(Eq("id", 1))
+	if clause, _ := tbl.scopeQuery(info, q).Build(SQLITE); clause != " WHERE (id = ? AND (deleted_at = 0))" {
+		t.Fatal(clause)
+	}
+	if clause, _ := q.Build(SQLITE); clause != " WHERE id = ?" {
+		t.Fatal(clause)
+	}
+	if where := tbl.WithDeleted().scopeWhere(info, "id = ?"); where != "id = ?" {
+		t.Fatal(where)
+	}
+
+	tests := []struct {
+		where  string
+		scoped string
+	}{
+		{"id > ? OR id < ? ORDER BY id LIMIT 10", "deleted_at = 0 AND (id > ? OR id < ?) ORDER BY id LIMIT 10"},
+		{"name = 'limit' and id in (select id from tags order by id) order by name", "deleted_at = 0 AND (name = 'limit' and id in (select id from tags order by id)) order by name"},
+		{"ORDER BY id", "deleted_at = 0 ORDER BY id"},
+		{"limited = 1", "deleted_at = 0 AND (limited = 1)"},
+	}
+	for _, test := range tests {
+		if where := tbl.scopeWhere(info, test.where); where != test.scoped {
+			t.Fatal(where)
+		}
+	}
+}
+
+func TestTable_VersionAndDeletedAtNames(t *testing.T) {
+	type nameTestItem struct {
+		ID        int64 `sql:"primary key"`
+		Rev       int   `sql:"version"`
+		DeletedAt int64 `sql:"deleted_at"`
+	}
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE name_test_items(id INTEGER PRIMARY KEY, version INT, deleted_at BIGINT)")
+	info := getRecordColumnInfo(&nameTestItem{})
+	if info.versionName != "" || info.deletedAtName != "" {
+		t.Fatal(info.versionName, info.deletedAtName)
+	}
+	if err := db.Insert(&nameTestItem{ID: 1, Rev: 7}); err != nil {
+		t.Fatal(err)
+	}
+	var item nameTestItem
+	if err := db.SelectOne(&item, "id = ?", 1); err != nil || item.Rev != 7 {
+		t.Fatal(item, err)
+	}
+	item.Rev = 3
+	if err := db.Update(&item); err != nil || item.Rev != 3 {
+		t.Fatal(item, err)
+	}
+	if err := db.Table(&item).Delete("id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.DB().QueryRow("SELECT COUNT(*) FROM name_test_items").Scan(&n); err != nil || n != 0 {
+		t.Fatal("record isn't deleted", n, err)
+	}
+}
+
+func TestTable_Save_SoftDeleted(t *testing.T) {
+	type softDeleteSaveItem struct {
+		ID        int64 `sql:"primary key"`
+		Name      string
+		DeletedAt int64 `sql:",deleted_at"`
+	}
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE soft_delete_save_items(id INTEGER PRIMARY KEY, name TEXT, deleted_at BIGINT)")
+	item := &softDeleteSaveItem{ID: 1, Name: "a"}
+	if err := db.Save(item); err != nil {
+		t.Fatal(err)
+	}
+	stale := *item
+	tbl := db.Table(item)
+	if err := tbl.Delete("id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Name = "b"
+	if err := db.Save(&stale); err != nil {
+		t.Fatal(err)
+	}
+	var got softDeleteSaveItem
+	if err := tbl.WithDeleted().SelectOne(&got, "id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "b" || got.DeletedAt == 0 {
+		t.Fatal("soft deleted record is restored", got)
+	}
+}
+
+func TestTable_SoftDelete(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE soft_delete_test_items(id INTEGER PRIMARY KEY, name TEXT, version INT, deleted_at BIGINT)")
+	for i := int64(1); i <= 3; i++ {
+		if err := db.Insert(&softDeleteTestItem{ID: i, Name: "item"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var item softDeleteTestItem
+	if err := db.SelectOne(&item, "id = ?", 1); err != nil || item.Version != 1 {
+		t.Fatal(item, err)
+	}
+	stale := item
+	item.Name = "updated"
+	if err := db.Update(&item); err != nil || item.Version != 2 {
+		t.Fatal(item, err)
+	}
+	if err := db.Update(&stale); xerror.GetCode(err) != http.StatusConflict {
+		t.Fatal(err)
+	}
+
+	tbl := db.Table(&item)
+	if err := tbl.Delete("id = ? OR id = ?", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(&item); xerror.GetCode(err) != http.StatusConflict {
+		t.Fatal("soft deleted record is updated", err)
+	}
+	var items []*softDeleteTestItem
+	if err := db.Select(&items, "id > ? ORDER BY id DESC LIMIT 2", 0); err != nil || len(items) != 1 || items[0].ID != 3 {
+		t.Fatal(items, err)
+	}
+	if n, err := tbl.Count(""); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if n, err := tbl.WithDeleted().Count(""); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	var all []*softDeleteTestItem
+	if err := tbl.WithDeleted().Select(&all, "id > ? ORDER BY id DESC LIMIT 2", 0); err != nil || len(all) != 2 || all[1].ID != 2 {
+		t.Fatal(all, err)
+	}
+}
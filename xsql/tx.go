@@ -1,50 +1,173 @@
 package xsql
 
 import (
+	"context"
 	"database/sql"
+	"reflect"
 )
 
 type Tx struct {
 	tx         *sql.Tx
+	db         *DB
 	driverName string
+
+	hooks      []func()
+	savepoints int
 }
 
+// Commit commits the transaction, and runs after-commit hooks if it succeeds
 func (t *Tx) Commit() error {
-	return t.tx.Commit()
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	hooks := t.hooks
+	t.hooks = nil
+	for _, h := range hooks {
+		h()
+	}
+	return nil
+}
+
+// AfterCommit adds hook which runs after the transaction is committed
+// Hooks added in a nested InTx are discarded if it's rolled back to savepoint
+func (t *Tx) AfterCommit(hook func()) {
+	t.hooks = append(t.hooks, hook)
 }
 
 func (t *Tx) Rollback() error {
 	return t.tx.Rollback()
 }
 
-func (t *Tx) Table(name string) *Table {
-	return &Table{
-		exe:        t.tx,
-		driverName: t.driverName,
-		name:       name,
-	}
+// Table returns table by name or record. Soft delete is applied to Delete and Count only if table is created by record
+func (t *Tx) Table(nameOrRecord any) *Table {
+	return newTable(t.db, t.tx, nameOrRecord)
 }
 
 func (t *Tx) Insert(record any) error {
-	return t.Table(getTableName(record)).Insert(record)
+	return t.InsertContext(context.Background(), record)
+}
+
+func (t *Tx) InsertContext(ctx context.Context, record any) error {
+	return t.Table(getTableName(record)).InsertContext(ctx, record)
+}
+
+func (t *Tx) BatchInsert(values any) error {
+	return t.BatchInsertContext(context.Background(), values)
+}
+
+func (t *Tx) BatchInsertContext(ctx context.Context, values any) error {
+	if tbl := t.batchTable(values); tbl != nil {
+		return tbl.BatchInsertContext(ctx, values)
+	}
+	return eachRecord(values, func(record any) error {
+		return t.InsertContext(ctx, record)
+	})
+}
+
+func (t *Tx) Upsert(record any, optFns ...func(options *UpsertOptions)) error {
+	return t.UpsertContext(context.Background(), record, optFns...)
+}
+
+func (t *Tx) UpsertContext(ctx context.Context, record any, optFns ...func(options *UpsertOptions)) error {
+	return t.Table(getTableName(record)).UpsertContext(ctx, record, optFns...)
+}
+
+func (t *Tx) BatchUpsert(values any, optFns ...func(options *UpsertOptions)) error {
+	return t.BatchUpsertContext(context.Background(), values, optFns...)
+}
+
+func (t *Tx) BatchUpsertContext(ctx context.Context, values any, optFns ...func(options *UpsertOptions)) error {
+	if tbl := t.batchTable(values); tbl != nil {
+		return tbl.BatchUpsertContext(ctx, values, optFns...)
+	}
+	return eachRecord(values, func(record any) error {
+		return t.UpsertContext(ctx, record, optFns...)
+	})
+}
+
+// batchTable returns table of values. Table name is empty if values is not a slice, which is reported by batch operations
+// It returns nil if elements are not structs or pointers to structs, e.g. []any, then records are written one by one
+func (t *Tx) batchTable(values any) *Table {
+	typ := reflect.TypeOf(values)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Slice {
+		return t.Table("")
+	}
+	elem := typ.Elem()
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil
+	}
+	return t.Table(getTableNameByType(elem))
+}
+
+// eachRecord calls fn with every element of slice values
+func eachRecord(values any, fn func(record any) error) error {
+	l := reflect.Indirect(reflect.ValueOf(values))
+	for i := 0; i < l.Len(); i++ {
+		if err := fn(l.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (t *Tx) Update(record any) error {
-	return t.Table(getTableName(record)).Update(record)
+	return t.UpdateContext(context.Background(), record)
+}
+
+func (t *Tx) UpdateContext(ctx context.Context, record any) error {
+	return t.Table(getTableName(record)).UpdateContext(ctx, record)
 }
 
 func (t *Tx) Save(record any) error {
-	return t.Table(getTableName(record)).Save(record)
+	return t.SaveContext(context.Background(), record)
+}
+
+func (t *Tx) SaveContext(ctx context.Context, record any) error {
+	return t.Table(getTableName(record)).SaveContext(ctx, record)
 }
 
 func (t *Tx) Select(records any, where string, args ...any) error {
-	return t.Table(getTableNameBySlice(records)).Select(records, where, args...)
+	return t.SelectContext(context.Background(), records, where, args...)
+}
+
+func (t *Tx) SelectContext(ctx context.Context, records any, where string, args ...any) error {
+	return t.Table(getTableNameBySlice(records)).SelectContext(ctx, records, where, args...)
 }
 
 func (t *Tx) SelectOne(record any, where string, args ...any) error {
-	return t.Table(getTableName(record)).SelectOne(record, where, args...)
+	return t.SelectOneContext(context.Background(), record, where, args...)
+}
+
+func (t *Tx) SelectOneContext(ctx context.Context, record any, where string, args ...any) error {
+	return t.Table(getTableName(record)).SelectOneContext(ctx, record, where, args...)
+}
+
+func (t *Tx) SelectBy(records any, q *Query) error {
+	return t.SelectByContext(context.Background(), records, q)
+}
+
+func (t *Tx) SelectByContext(ctx context.Context, records any, q *Query) error {
+	return t.Table(getTableNameBySlice(records)).SelectByContext(ctx, records, q)
+}
+
+func (t *Tx) SelectOneBy(record any, q *Query) error {
+	return t.SelectOneByContext(context.Background(), record, q)
+}
+
+func (t *Tx) SelectOneByContext(ctx context.Context, record any, q *Query) error {
+	return t.Table(getTableName(record)).SelectOneByContext(ctx, record, q)
 }
 
 func (t *Tx) Exec(query string, args ...any) (sql.Result, error) {
-	return t.tx.Exec(query, args...)
+	return t.ExecContext(context.Background(), query, args...)
+}
+
+func (t *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.db.exec(ctx, t.tx, query, args)
 }
@@ -0,0 +1,421 @@
+package xsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
+
+type UpsertOptions struct {
+	// ConflictColumns are columns of the primary key or unique index which conflicts. Default is primary key
+	// It's ignored by mysql which resolves conflicts with any unique index
+	ConflictColumns []string
+
+	// UpdateColumns are columns updated on conflict. Default is all inserted columns except conflict columns,
+	// primary key, created_at and soft delete column, and version is increased if any other column is updated.
+	// Conflicted rows are kept unchanged if it's empty after excluding conflict columns
+	UpdateColumns []string
+}
+
+// Upsert inserts record, or updates columns of the existing record on conflict
+// Version column of record is set to the saved version, so upsert of versioned records is not supported by mysql
+func (t *Table) Upsert(record any, optFns ...func(options *UpsertOptions)) error {
+	return t.UpsertContext(context.Background(), record, optFns...)
+}
+
+func (t *Table) UpsertContext(ctx context.Context, record any, optFns ...func(options *UpsertOptions)) error {
+	return t.insertRows(ctx, []reflect.Value{getStructValue(record)}, newUpsertOptions(optFns))
+}
+
+// BatchUpsert upserts records with multi-row statements
+// With mysql, records whose auto increment ids are set are upserted one by one, and ids of updated rows are not set
+func (t *Table) BatchUpsert(records any, optFns ...func(options *UpsertOptions)) error {
+	return t.BatchUpsertContext(context.Background(), records, optFns...)
+}
+
+func (t *Table) BatchUpsertContext(ctx context.Context, records any, optFns ...func(options *UpsertOptions)) error {
+	values, err := recordValues(records)
+	if err != nil {
+		return err
+	}
+	return t.insertRows(ctx, values, newUpsertOptions(optFns))
+}
+
+// BatchInsert inserts records with multi-row statements
+// Records are split into chunks so that every statement stays under parameter limit of the driver
+// With mysql, records whose auto increment ids are set are inserted one by one
+// With postgres and sqlite, they're inserted one by one unless a not null unique column identifies the returned rows
+func (t *Table) BatchInsert(records any) error {
+	return t.BatchInsertContext(context.Background(), records)
+}
+
+func (t *Table) BatchInsertContext(ctx context.Context, records any) error {
+	values, err := recordValues(records)
+	if err != nil {
+		return err
+	}
+	return t.insertRows(ctx, values, nil)
+}
+
+func newUpsertOptions(optFns []func(options *UpsertOptions)) *UpsertOptions {
+	options := &UpsertOptions{}
+	for _, fn := range optFns {
+		fn(options)
+	}
+	return options
+}
+
+// recordValues returns struct values of records which is a slice or pointer to slice
+// Records must be of the same type as they're written into one table
+func recordValues(records any) ([]reflect.Value, error) {
+	l := reflect.ValueOf(records)
+	for l.Kind() == reflect.Ptr {
+		l = l.Elem()
+	}
+	if l.Kind() != reflect.Slice {
+		return nil, errors.New("not slice")
+	}
+	values := make([]reflect.Value, l.Len())
+	for i := range values {
+		v := l.Index(i)
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("not struct: %v", v.Kind())
+		}
+		if i > 0 && v.Type() != values[0].Type() {
+			return nil, fmt.Errorf("different record types: %v, %v", values[0].Type(), v.Type())
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// maxParams returns max number of parameters in a statement
+func maxParams(driverName string) int {
+	switch {
+	case isPostgres(driverName), isMySQL(driverName):
+		return 65535
+	case isSQLite(driverName):
+		return 32766
+	default:
+		return 999
+	}
+}
+
+// insertRows inserts values in chunks, and it's upsert if options is not nil
+func (t *Table) insertRows(ctx context.Context, values []reflect.Value, options *UpsertOptions) error {
+	if len(values) == 0 {
+		return nil
+	}
+	info := getColumnInfo(values[0].Type())
+
+	// auto increment column is omitted only if it's zero in all records
+	columns := info.names
+	var aiValues []reflect.Value
+	if len(info.aiName) > 0 {
+		for _, v := range values {
+			if fv := v.FieldByIndex(info.nameToIndex[info.aiName]); fv.Int() == 0 {
+				aiValues = append(aiValues, fv)
+			}
+		}
+		if len(aiValues) == len(values) {
+			columns = info.notAINames
+		} else if len(aiValues) > 0 {
+			return errors.New("auto increment column must be all zero or all non-zero")
+		} else {
+			aiValues = nil
+		}
+	}
+
+	var suffix string
+	var keyColumns []string
+	if options != nil {
+		var err error
+		if suffix, err = t.conflictClause(info, columns, options); err != nil {
+			return err
+		}
+		keyColumns = t.conflictColumns(info, options)
+	}
+	if aiValues != nil && (len(keyColumns) == 0 || IndexOfString(keyColumns, info.aiName) >= 0) {
+		// generated ids are unknown before insert, so they cannot identify rows
+		keyColumns = insertKeyColumns(info)
+	}
+
+	// versions of upserted records are read back, as conflicted rows keep or increase their versions
+	var versions []reflect.Value
+	if len(info.versionName) > 0 {
+		if options != nil && isMySQL(t.driverName) {
+			return errors.New("upsert of versioned records is not supported by mysql")
+		}
+		for _, v := range values {
+			version := v.FieldByIndex(info.nameToIndex[info.versionName])
+			if version.IsZero() {
+				addVersion(version, 1)
+			}
+			if options != nil {
+				versions = append(versions, version)
+			}
+		}
+	}
+
+	size := maxParams(t.driverName) / len(columns)
+	if aiValues != nil && isMySQL(t.driverName) {
+		// ids of a multi-row insert are not always consecutive, e.g. innodb_autoinc_lock_mode=2,
+		// so LastInsertId tells the id of only one row
+		size = 1
+	} else if (aiValues != nil || versions != nil) && len(keyColumns) == 0 {
+		// returned rows are matched with records by key columns, as the order of RETURNING rows is not guaranteed
+		size = 1
+	}
+	for start := 0; start < len(values); start += size {
+		end := min(start+size, len(values))
+		var ids, chunkVersions []reflect.Value
+		if aiValues != nil {
+			ids = aiValues[start:end]
+		}
+		if versions != nil {
+			chunkVersions = versions[start:end]
+		}
+		if err := t.insertChunk(ctx, info, columns, values[start:end], suffix, keyColumns, ids, chunkVersions, options != nil); err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// insertKeyColumns returns a not null unique column which identifies inserted rows whose ids are generated
+func insertKeyColumns(info *columnInfo) []string {
+	for _, name := range info.uniqueNames {
+		if IndexOfString(info.nullableNames, name) < 0 {
+			return []string{name}
+		}
+	}
+	return nil
+}
+
+// insertChunk inserts values with one statement, and sets ids and versions to values returned by the database
+// Returned rows are matched with values by keyColumns, or by position if there is only one value
+func (t *Table) insertChunk(ctx context.Context, info *columnInfo, columns []string, values []reflect.Value, suffix string,
+	keyColumns []string, ids, versions []reflect.Value, upsert bool) error {
+	var buf strings.Builder
+	buf.WriteString("INSERT INTO ")
+	buf.WriteString(t.name)
+	buf.WriteString(" (")
+	buf.WriteString(strings.Join(columns, ", "))
+	buf.WriteString(") VALUES ")
+	row := "(" + strings.Repeat("?, ", len(columns)-1) + "?)"
+	args := make([]any, 0, len(values)*len(columns))
+	for i, v := range values {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(row)
+		for _, name := range columns {
+			fv, err := t.getFieldValueByName(v, info, name)
+			if err != nil {
+				return err
+			}
+			args = append(args, fv)
+		}
+	}
+	buf.WriteString(suffix)
+
+	if len(ids) == 0 && len(versions) == 0 {
+		_, err := t.exec(ctx, buf.String(), args...)
+		return err
+	}
+
+	if isMySQL(t.driverName) {
+		result, err := t.exec(ctx, buf.String(), args...)
+		if err != nil {
+			return err
+		}
+		if upsert {
+			// last insert id is ambiguous if the row is updated
+			if n, err := result.RowsAffected(); err != nil || n != 1 {
+				return err
+			}
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		ids[0].SetInt(id)
+		return nil
+	}
+
+	// RETURNING is supported by postgres and sqlite
+	var returning []string
+	if len(ids) > 0 {
+		returning = append(returning, info.aiName)
+	}
+	if len(versions) > 0 {
+		returning = append(returning, info.versionName)
+	}
+	if len(values) == 1 {
+		keyColumns = nil
+	}
+	buf.WriteString(" RETURNING ")
+	buf.WriteString(strings.Join(append(returning, keyColumns...), ", "))
+	rows, err := t.query(ctx, buf.String(), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string]int, len(values))
+	if len(keyColumns) > 0 {
+		for i, v := range values {
+			key, err := t.rowKey(v, info, keyColumns)
+			if err != nil {
+				return err
+			}
+			indexes[key] = i
+		}
+	}
+	n := 0
+	for ; rows.Next(); n++ {
+		row := make([]int64, len(returning))
+		keys := make([]any, len(keyColumns))
+		dest := make([]any, 0, len(row)+len(keys))
+		for i := range row {
+			dest = append(dest, &row[i])
+		}
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		i := 0
+		if len(keyColumns) > 0 {
+			var ok bool
+			if i, ok = indexes[keyString(keys)]; !ok {
+				return fmt.Errorf("no record matches returned row: %v", toReadableArgs(keys))
+			}
+		} else if n > 0 {
+			return errors.New("more rows are returned than inserted")
+		}
+		if len(ids) > 0 {
+			ids[i].SetInt(row[0])
+			row = row[1:]
+		}
+		if len(versions) > 0 {
+			setVersion(versions[i], row[0])
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	// rows skipped by DO NOTHING are not returned, and their ids and versions are not set
+	if n != len(values) && !strings.HasSuffix(suffix, " DO NOTHING") {
+		return fmt.Errorf("%d rows are returned for %d records", n, len(values))
+	}
+	return nil
+}
+
+// rowKey returns the key of record v which is compared with keyString of the returned key columns
+func (t *Table) rowKey(v reflect.Value, info *columnInfo, keyColumns []string) (string, error) {
+	keys := make([]any, len(keyColumns))
+	for i, name := range keyColumns {
+		fv, err := t.getFieldValueByName(v, info, name)
+		if err != nil {
+			return "", err
+		}
+		if keys[i], err = driver.DefaultParameterConverter.ConvertValue(fv); err != nil {
+			return "", err
+		}
+	}
+	return keyString(keys), nil
+}
+
+func keyString(keys []any) string {
+	return fmt.Sprint(toReadableArgs(keys)...)
+}
+
+func setVersion(v reflect.Value, version int64) {
+	if v.CanInt() {
+		v.SetInt(version)
+	} else {
+		v.SetUint(uint64(version))
+	}
+}
+
+// conflictColumns returns ConflictColumns of options, or primary key by default
+func (t *Table) conflictColumns(info *columnInfo, options *UpsertOptions) []string {
+	if len(options.ConflictColumns) > 0 {
+		return options.ConflictColumns
+	}
+	return info.pkNames
+}
+
+// conflictClause returns ON CONFLICT or ON DUPLICATE KEY UPDATE clause
+func (t *Table) conflictClause(info *columnInfo, columns []string, options *UpsertOptions) (string, error) {
+	conflictColumns := t.conflictColumns(info, options)
+	if len(conflictColumns) == 0 && !isMySQL(t.driverName) {
+		return "", errors.New("no conflict columns: record has no primary key and ConflictColumns is empty")
+	}
+
+	updateColumns := options.UpdateColumns
+	if updateColumns == nil {
+		for _, name := range columns {
+			if IndexOfString(info.pkNames, name) >= 0 || IndexOfString(conflictColumns, name) >= 0 {
+				continue
+			}
+			// soft deleted rows are not restored by upsert, and version is increased rather than overwritten
+			if name != "created_at" && name != info.deletedAtName && name != info.versionName {
+				updateColumns = append(updateColumns, name)
+			}
+		}
+		if len(updateColumns) > 0 && len(info.versionName) > 0 {
+			updateColumns = append(updateColumns, info.versionName)
+		}
+	}
+
+	var sets []string
+	for _, name := range updateColumns {
+		mustColumn(name)
+		if IndexOfString(conflictColumns, name) >= 0 {
+			continue
+		}
+		switch {
+		case name == info.versionName && isMySQL(t.driverName):
+			sets = append(sets, name+" = "+name+" + 1")
+		case name == info.versionName:
+			sets = append(sets, fmt.Sprintf("%s = %s.%s + 1", name, t.name, name))
+		case isMySQL(t.driverName):
+			sets = append(sets, fmt.Sprintf("%s = VALUES(%s)", name, name))
+		default:
+			sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", name, name))
+		}
+	}
+
+	if isMySQL(t.driverName) {
+		if len(sets) == 0 {
+			// assigning a column to itself is a no-op update
+			name := info.names[0]
+			if len(conflictColumns) > 0 {
+				name = conflictColumns[0]
+			}
+			sets = []string{name + " = " + name}
+		}
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", "), nil
+	}
+
+	for _, name := range conflictColumns {
+		mustColumn(name)
+	}
+	clause := " ON CONFLICT (" + strings.Join(conflictColumns, ", ") + ")"
+	if len(sets) == 0 {
+		return clause + " DO NOTHING", nil
+	}
+	return clause + " DO UPDATE SET " + strings.Join(sets, ", "), nil
+}
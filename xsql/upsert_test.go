@@ -0,0 +1,174 @@
+package xsql
+
+import "testing"
+
+type upsertTestItem struct {
+	ID      int64  `sql:"primary key,auto_increment"`
+	Code    string `sql:"unique"`
+	Name    string
+	Version int `sql:",version"`
+}
+
+func TestTable_conflictClause(t *testing.T) {
+	info := getRecordColumnInfo(&upsertTestItem{})
+	options := &UpsertOptions{ConflictColumns: []string{"code"}}
+	tests := []struct {
+		driverName string
+		clause     string
+	}{
+		{SQLITE, " ON CONFLICT (code) DO UPDATE SET name = EXCLUDED.name, version = items.version + 1"},
+		{POSTGRES, " ON CONFLICT (code) DO UPDATE SET name = EXCLUDED.name, version = items.version + 1"},
+		{MYSQL, " ON DUPLICATE KEY UPDATE name = VALUES(name), version = version + 1"},
+	}
+	for _, test := range tests {
+		tbl := &Table{name: "items", driverName: test.driverName}
+		if clause, err := tbl.conflictClause(info, info.notAINames, options); err != nil || clause != test.clause {
+			t.Fatalf("%s: %s, %v", test.driverName, clause, err)
+		}
+	}
+
+	options.UpdateColumns = []string{"code"}
+	tbl := &Table{name: "items", driverName: POSTGRES}
+	if clause, err := tbl.conflictClause(info, info.notAINames, options); err != nil || clause != " ON CONFLICT (code) DO NOTHING" {
+		t.Fatal(clause, err)
+	}
+}
+
+func TestTable_Upsert_NoConflictColumns(t *testing.T) {
+	type noKeyItem struct {
+		Code string
+		Name string
+	}
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE no_key_items(code TEXT UNIQUE, name TEXT)")
+	if err := db.Table(&noKeyItem{}).Upsert(&noKeyItem{Code: "a"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := db.Table(&noKeyItem{}).Upsert(&noKeyItem{Code: "a"}, func(options *UpsertOptions) {
+		options.ConflictColumns = []string{"code"}
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTable_Upsert_SoftDeleted(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE soft_delete_test_items(id INTEGER PRIMARY KEY, name TEXT, version INT, deleted_at BIGINT)")
+	item := &softDeleteTestItem{ID: 1, Name: "a"}
+	if err := db.Upsert(item); err != nil {
+		t.Fatal(err)
+	}
+	stale := *item
+	if err := db.Table(item).Delete("id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Name = "b"
+	if err := db.Upsert(&stale); err != nil || stale.Version != 2 {
+		t.Fatal(stale, err)
+	}
+	var got softDeleteTestItem
+	if err := db.Table(item).WithDeleted().SelectOne(&got, "id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "b" || got.Version != 2 || got.DeletedAt == 0 {
+		t.Fatal("soft deleted record is restored", got)
+	}
+}
+
+type upsertTestTag struct {
+	ID   int64 `sql:"primary key,auto_increment"`
+	Name string
+}
+
+func TestDB_BatchInsert(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE upsert_test_items(id INTEGER PRIMARY KEY AUTOINCREMENT, code TEXT UNIQUE, name TEXT, version INT)")
+	db.MustExec("CREATE TABLE upsert_test_tags(id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)")
+
+	items := []*upsertTestItem{{Code: "a"}, {Code: "b"}}
+	if err := db.BatchInsert(items); err != nil {
+		t.Fatal(err)
+	}
+	if items[0].ID == 0 || items[1].ID != items[0].ID+1 {
+		t.Fatal(items[0].ID, items[1].ID)
+	}
+
+	// records of different types are inserted one by one
+	tag := &upsertTestTag{Name: "t"}
+	if err := db.BatchInsert([]any{&upsertTestItem{Code: "c"}, tag}); err != nil {
+		t.Fatal(err)
+	}
+	if tag.ID == 0 {
+		t.Fatal("id is not set")
+	}
+	if err := db.Table("upsert_test_items").BatchInsert([]any{&upsertTestItem{Code: "d"}, tag}); err == nil {
+		t.Fatal("expected error")
+	}
+	if n, err := db.Table(&upsertTestItem{}).Count(""); err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+
+	// ids are read back row by row if there is no key column to match returned rows
+	tags := []*upsertTestTag{{Name: "x"}, {Name: "y"}, {Name: "z"}}
+	if err := db.BatchInsert(tags); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range tags {
+		var got upsertTestTag
+		if err := db.SelectOne(&got, "id = ?", tag.ID); err != nil || got.Name != tag.Name {
+			t.Fatal(tag, got, err)
+		}
+	}
+}
+
+func TestTable_Upsert_Version(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec("CREATE TABLE upsert_test_items(id INTEGER PRIMARY KEY AUTOINCREMENT, code TEXT UNIQUE, name TEXT, version INT)")
+	conflictCode := func(options *UpsertOptions) {
+		options.ConflictColumns = []string{"code"}
+	}
+
+	item := &upsertTestItem{Code: "a", Name: "a"}
+	if err := db.Upsert(item, conflictCode); err != nil {
+		t.Fatal(err)
+	}
+	if item.ID == 0 || item.Version != 1 {
+		t.Fatal(item)
+	}
+
+	items := []*upsertTestItem{{Code: "a", Name: "a2"}, {Code: "b", Name: "b"}}
+	if err := db.BatchUpsert(items, conflictCode); err != nil {
+		t.Fatal(err)
+	}
+	if items[0].ID != item.ID || items[0].Version != 2 || items[1].Version != 1 {
+		t.Fatal(items[0], items[1])
+	}
+
+	// version is updated by the returned one, then record can be updated with optimistic locking
+	items[0].Name = "a3"
+	if err := db.Update(items[0]); err != nil {
+		t.Fatal(err)
+	}
+	var got upsertTestItem
+	if err := db.SelectOne(&got, "code=?", "a"); err != nil || got.Name != "a3" || got.Version != 3 {
+		t.Fatal(got, err)
+	}
+
+	// returned ids and versions are matched with records by conflict columns
+	items = []*upsertTestItem{{Code: "c", Name: "c"}, {Code: "b", Name: "b2"}, {Code: "a", Name: "a4"}}
+	if err := db.BatchUpsert(items, conflictCode); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		var got upsertTestItem
+		if err := db.SelectOne(&got, "code = ?", item.Code); err != nil || got != *item {
+			t.Fatal(*item, got, err)
+		}
+	}
+
+	tbl := &Table{name: "upsert_test_items", driverName: MYSQL}
+	if err := tbl.Upsert(&upsertTestItem{Code: "c"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
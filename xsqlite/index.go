@@ -0,0 +1,310 @@
+package xsqlite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var _regexpIndexName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Index extracts a field of records into a side column of LocalTable,
+// so that records can be filtered and sorted without decoding their data
+// Indexed values are stored in plain text even if LocalTable is encrypted
+type Index[R any] struct {
+	name    string
+	sqlType string
+	extract func(r R) any
+}
+
+// StringIndex creates an index of a string field
+func StringIndex[R any](name string, fn func(r R) string) Index[R] {
+	return newIndex(name, "TEXT", func(r R) any {
+		return fn(r)
+	})
+}
+
+// Int64Index creates an index of an integer field
+func Int64Index[R any](name string, fn func(r R) int64) Index[R] {
+	return newIndex(name, "INTEGER", func(r R) any {
+		return fn(r)
+	})
+}
+
+// TimeIndex creates an index of a time field which is stored in unix milliseconds
+// Values of conditions on it can be time.Time as well as unix milliseconds
+func TimeIndex[R any](name string, fn func(r R) time.Time) Index[R] {
+	return newIndex(name, "INTEGER", func(r R) any {
+		return fn(r).UnixMilli()
+	})
+}
+
+func newIndex[R any](name string, sqlType string, extract func(r R) any) Index[R] {
+	if !_regexpIndexName.MatchString(name) {
+		panic("invalid index name: " + name)
+	}
+	return Index[R]{
+		name:    name,
+		sqlType: sqlType,
+		extract: extract,
+	}
+}
+
+func (i Index[R]) Name() string {
+	return i.name
+}
+
+func (i Index[R]) column() string {
+	return "idx_" + i.name
+}
+
+// IndexCondition compares an indexed field with Value. Op is one of =, !=, <, <=, >, >=
+type IndexCondition struct {
+	Index string
+	Op    string
+	Value any
+}
+
+type ListOptions struct {
+	// Categories filters records by category if it's not empty
+	Categories []int
+
+	// Where are conditions on indexes which are combined with AND
+	Where []IndexCondition
+
+	// OrderBy is name of the index by which records are sorted. Records are sorted by id if it's empty
+	OrderBy string
+	Desc    bool
+
+	// Limit is max number of returned records. Zero means no limit
+	Limit  int
+	Offset int
+}
+
+// ListBy lists records like List, and filters, sorts and pages them by indexes
+func (t *LocalTable[R]) ListBy(ctx context.Context, optFns ...func(options *ListOptions)) ([]R, error) {
+	options := &ListOptions{}
+	for _, fn := range optFns {
+		fn(options)
+	}
+
+	var conditions []string
+	var args []any
+	if len(options.Categories) > 0 {
+		conditions = append(conditions, fmt.Sprintf("category in (%s)", strings.Join(toStringSlice(options.Categories), ",")))
+	}
+	for _, c := range options.Where {
+		index, ok := t.index(c.Index)
+		if !ok {
+			return nil, fmt.Errorf("no index %s", c.Index)
+		}
+		switch c.Op {
+		case "=", "!=", "<", "<=", ">", ">=":
+		default:
+			return nil, fmt.Errorf("invalid operator %s", c.Op)
+		}
+		conditions = append(conditions, index.column()+c.Op+"?")
+		if tm, ok := c.Value.(time.Time); ok {
+			args = append(args, tm.UnixMilli())
+		} else {
+			args = append(args, c.Value)
+		}
+	}
+
+	sortKey := "id"
+	if options.OrderBy != "" {
+		index, ok := t.index(options.OrderBy)
+		if !ok {
+			return nil, fmt.Errorf("no index %s", options.OrderBy)
+		}
+		sortKey = index.column()
+	}
+
+	where := strings.Join(conditions, " AND ")
+	localWhere := "id NOT IN (SELECT id FROM remotes)"
+	if where != "" {
+		localWhere = where + " AND " + localWhere
+		where = " WHERE " + where
+	}
+
+	// locals which have been saved remotely are excluded as List does
+	query := fmt.Sprintf(`SELECT id, data, 'remotes' AS source, %[1]s AS sort_key FROM remotes%[2]s
+UNION ALL
+SELECT id, data, 'locals' AS source, %[1]s AS sort_key FROM locals WHERE %[3]s
+ORDER BY sort_key`, sortKey, where, localWhere)
+	if options.Desc {
+		query += " DESC"
+	}
+	if sortKey != "id" {
+		query += ", id"
+	}
+	args = append(args, args...)
+	if options.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, options.Limit, options.Offset)
+	} else if options.Offset > 0 {
+		query += " LIMIT -1 OFFSET ?"
+		args = append(args, options.Offset)
+	}
+
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %s, %w", query, err)
+	}
+	defer rows.Close()
+
+	var records []R
+	for rows.Next() {
+		var localID, source string
+		var data []byte
+		var sortValue any
+		if err = rows.Scan(&localID, &data, &source, &sortValue); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		cache := t.localCache
+		if source == "remotes" {
+			cache = t.remoteCache
+		}
+		r, ok := cache.Get(localID)
+		if !ok {
+			r, err = t.decode(localID, data)
+			if err != nil {
+				return nil, fmt.Errorf("decode: %w", err)
+			}
+			cache.Add(localID, r)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func (t *LocalTable[R]) index(name string) (Index[R], bool) {
+	for _, i := range t.options.Indexes {
+		if i.name == name {
+			return i, true
+		}
+	}
+	return Index[R]{}, false
+}
+
+// indexColumns returns index columns which are appended to column list of insert statements, e.g. ", idx_a, idx_b"
+func (t *LocalTable[R]) indexColumns() string {
+	var b strings.Builder
+	for _, i := range t.options.Indexes {
+		b.WriteString(", ")
+		b.WriteString(i.column())
+	}
+	return b.String()
+}
+
+// indexInsert returns placeholders and values of index columns in insert statements
+func (t *LocalTable[R]) indexInsert(r R) (string, []any) {
+	args := make([]any, len(t.options.Indexes))
+	for i, index := range t.options.Indexes {
+		args[i] = index.extract(r)
+	}
+	return strings.Repeat(",?", len(args)), args
+}
+
+// indexUpdate returns assignments and values of index columns in update statements
+func (t *LocalTable[R]) indexUpdate(r R) (string, []any) {
+	var b strings.Builder
+	args := make([]any, len(t.options.Indexes))
+	for i, index := range t.options.Indexes {
+		b.WriteString(", ")
+		b.WriteString(index.column())
+		b.WriteString("=?")
+		args[i] = index.extract(r)
+	}
+	return b.String(), args
+}
+
+// migrateIndexes adds columns of new indexes, and backfills rows whose index columns are null
+func (t *LocalTable[R]) migrateIndexes(ctx context.Context) error {
+	if len(t.options.Indexes) == 0 {
+		return nil
+	}
+	for _, tableName := range []string{"remotes", "locals"} {
+		columns := make(map[string]bool)
+		rows, err := t.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, tableName)
+		if err != nil {
+			return fmt.Errorf("query table info: %w", err)
+		}
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan table info: %w", err)
+			}
+			columns[name] = true
+		}
+		rows.Close()
+
+		var nullConditions []string
+		for _, index := range t.options.Indexes {
+			column := index.column()
+			if !columns[column] {
+				_, err = t.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, tableName, column, index.sqlType))
+				if err != nil {
+					return fmt.Errorf("add column %s: %w", column, err)
+				}
+			}
+			_, err = t.db.ExecContext(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_%s ON %s(%s)`,
+				tableName, column, tableName, column))
+			if err != nil {
+				return fmt.Errorf("create index %s: %w", column, err)
+			}
+			nullConditions = append(nullConditions, column+" IS NULL")
+		}
+
+		if err = t.backfillIndexes(ctx, tableName, strings.Join(nullConditions, " OR ")); err != nil {
+			return fmt.Errorf("backfill %s: %w", tableName, err)
+		}
+	}
+	return nil
+}
+
+func (t *LocalTable[R]) backfillIndexes(ctx context.Context, tableName string, where string) error {
+	rows, err := t.db.QueryContext(ctx, `SELECT id, data FROM `+tableName+` WHERE `+where)
+	if err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	records := make(map[string]R)
+	for rows.Next() {
+		var localID string
+		var data []byte
+		if err = rows.Scan(&localID, &data); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan: %w", err)
+		}
+		r, err := t.decode(localID, data)
+		if err != nil {
+			log.Println("cannot index undecodable record", tableName, localID, err)
+			continue
+		}
+		records[localID] = r
+	}
+	rows.Close()
+	if len(records) == 0 {
+		return nil
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	for localID, r := range records {
+		sets, args := t.indexUpdate(r)
+		_, err = tx.ExecContext(ctx, `UPDATE `+tableName+` SET `+strings.TrimPrefix(sets, ", ")+` WHERE id=?`, append(args, localID)...)
+		if err != nil {
+			return fmt.Errorf("update %s: %w", localID, err)
+		}
+	}
+	log.Printf("indexed %d records in %s\n", len(records), tableName)
+	return tx.Commit()
+}
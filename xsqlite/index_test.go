@@ -0,0 +1,95 @@
+package xsqlite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.olapie.com/x/xtest"
+)
+
+func TestLocalTable_ListBy(t *testing.T) {
+	ctx := context.TODO()
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+	filename := "testdata/index" + fmt.Sprint(time.Now().UnixMilli()) + ".db"
+	db, err := Open(filename)
+	xtest.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(filename)
+	})
+
+	password := uuid.NewString()
+	table := NewLocalTable[*localTableItem](db, func(opts *LocalTableOptions[*localTableItem]) {
+		opts.Password = password
+	})
+	items := make([]*localTableItem, 6)
+	for i := range items {
+		items[i] = newLocalTableItem()
+		items[i].ID = int64(i)
+		items[i].Text = fmt.Sprint("text", i%2)
+		if i%2 == 0 {
+			xtest.NoError(t, table.SaveRemote(ctx, uuid.NewString(), i%3, items[i], time.Now().Unix()))
+		} else {
+			xtest.NoError(t, table.SaveLocal(ctx, uuid.NewString(), i%3, items[i]))
+		}
+	}
+
+	// existing records are indexed when table is created with new indexes
+	table = NewLocalTable[*localTableItem](db, func(opts *LocalTableOptions[*localTableItem]) {
+		opts.Password = password
+		opts.Indexes = []Index[*localTableItem]{
+			Int64Index("id", func(r *localTableItem) int64 { return r.ID }),
+			StringIndex("text", func(r *localTableItem) string { return r.Text }),
+		}
+	})
+
+	records, err := table.ListBy(ctx, func(options *ListOptions) {
+		options.OrderBy = "id"
+		options.Desc = true
+	})
+	xtest.NoError(t, err)
+	xtest.Equal(t, []*localTableItem{items[5], items[4], items[3], items[2], items[1], items[0]}, records)
+
+	records, err = table.ListBy(ctx, func(options *ListOptions) {
+		options.Where = []IndexCondition{{"text", "=", "text1"}, {"id", ">", 1}}
+		options.OrderBy = "id"
+	})
+	xtest.NoError(t, err)
+	xtest.Equal(t, []*localTableItem{items[3], items[5]}, records)
+
+	records, err = table.ListBy(ctx, func(options *ListOptions) {
+		options.Categories = []int{0, 1}
+		options.OrderBy = "id"
+		options.Limit = 2
+		options.Offset = 1
+	})
+	xtest.NoError(t, err)
+	xtest.Equal(t, []*localTableItem{items[1], items[3]}, records)
+
+	t.Run("Update", func(t *testing.T) {
+		id := uuid.NewString()
+		item := newLocalTableItem()
+		item.ID = 100
+		xtest.NoError(t, table.SaveLocal(ctx, id, 0, item))
+		item.ID = -1
+		xtest.NoError(t, table.Update(ctx, id, item))
+		records, err := table.ListBy(ctx, func(options *ListOptions) {
+			options.Where = []IndexCondition{{"id", "<", 0}}
+		})
+		xtest.NoError(t, err)
+		xtest.Equal(t, []*localTableItem{item}, records)
+	})
+
+	t.Run("NoIndex", func(t *testing.T) {
+		_, err := table.ListBy(ctx, func(options *ListOptions) {
+			options.OrderBy = "number"
+		})
+		xtest.Error(t, err)
+	})
+}
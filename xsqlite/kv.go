@@ -4,22 +4,36 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"go.olapie.com/x/xconv"
 	"go.olapie.com/x/xtime"
 )
 
+const defaultKVPurgeBatchSize = 500
+
+// notExpired is condition of keys which are not expired at time in the argument
+const notExpired = "(expires_at=0 OR expires_at>?)"
+
 type KVTableOptions struct {
 	Clock xtime.Clock
+
+	// PurgeInterval is interval of purging expired keys in background until the table is closed. Zero disables it
+	PurgeInterval time.Duration
 }
 
 type KVTable struct {
+	observable
 	options KVTableOptions
 	db      *sql.DB
 	mu      sync.RWMutex
 	name    string
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func NewKVTable(db *sql.DB, name string, optFns ...func(options *KVTableOptions)) *KVTable {
@@ -30,6 +44,7 @@ func NewKVTable(db *sql.DB, name string, optFns ...func(options *KVTableOptions)
 	r := &KVTable{
 		db:   db,
 		name: name,
+		stop: make(chan struct{}),
 	}
 
 	for _, fn := range optFns {
@@ -44,26 +59,54 @@ func NewKVTable(db *sql.DB, name string, optFns ...func(options *KVTableOptions)
 CREATE TABLE IF NOT EXISTS %s(
 k VARCHAR(255) PRIMARY KEY, 
 v BLOB NOT NULL,
-updated_at BIGINT NOT NULL
+updated_at BIGINT NOT NULL,
+expires_at BIGINT NOT NULL DEFAULT 0
 )`, name))
 	if err != nil {
 		panic(err)
 	}
+	if err = r.migrate(); err != nil {
+		panic(fmt.Errorf("migrate %s: %w", name, err))
+	}
+	if r.options.PurgeInterval > 0 {
+		go r.purgePeriodically()
+	}
 	return r
 }
 
+// migrate adds column expires_at to tables created before expiration is supported
+func (t *KVTable) migrate() error {
+	var exists bool
+	err := t.db.QueryRow(`SELECT EXISTS(SELECT * FROM pragma_table_info(?) WHERE name='expires_at')`, t.name).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("query table info: %w", err)
+	}
+	if !exists {
+		_, err = t.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN expires_at BIGINT NOT NULL DEFAULT 0", t.name))
+		if err != nil {
+			return fmt.Errorf("add column expires_at: %w", err)
+		}
+	}
+	_, err = t.db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_expires_at ON %s(expires_at)", t.name, t.name))
+	if err != nil {
+		return fmt.Errorf("create index: %w", err)
+	}
+	return nil
+}
+
 func (t *KVTable) SaveInt64(key string, val int64) error {
-	t.mu.Lock()
-	_, err := t.db.Exec(fmt.Sprintf("REPLACE INTO %s(k,v,updated_at) VALUES(?1,?2,?3)", t.name),
-		key, fmt.Sprint(val), t.options.Clock.Now())
-	t.mu.Unlock()
-	return err
+	return t.save(key, fmt.Sprint(val), 0)
+}
+
+// SaveInt64WithTTL saves val which expires after ttl. It never expires if ttl isn't positive
+func (t *KVTable) SaveInt64WithTTL(key string, val int64, ttl time.Duration) error {
+	return t.save(key, fmt.Sprint(val), ttl)
 }
 
 func (t *KVTable) Int64(key string) (int64, error) {
 	var v string
 	t.mu.RLock()
-	err := t.db.QueryRow(fmt.Sprintf("SELECT v FROM %s WHERE k=?", t.name), key).Scan(&v)
+	err := t.db.QueryRow(fmt.Sprintf("SELECT v FROM %s WHERE k=? AND %s", t.name, notExpired), key, t.now()).Scan(&v)
 	t.mu.RUnlock()
 	if err != nil {
 		return 0, err
@@ -80,6 +123,11 @@ func (t *KVTable) SaveString(key string, str string) error {
 	return t.SaveBytes(key, []byte(str))
 }
 
+// SaveStringWithTTL saves str which expires after ttl. It never expires if ttl isn't positive
+func (t *KVTable) SaveStringWithTTL(key string, str string, ttl time.Duration) error {
+	return t.SaveBytesWithTTL(key, []byte(str), ttl)
+}
+
 func (t *KVTable) String(key string) (string, error) {
 	data, err := t.Bytes(key)
 	if err != nil {
@@ -89,16 +137,18 @@ func (t *KVTable) String(key string) (string, error) {
 }
 
 func (t *KVTable) SaveBytes(key string, data []byte) error {
-	t.mu.Lock()
-	_, err := t.db.Exec(fmt.Sprintf("REPLACE INTO %s(k,v,updated_at) VALUES(?1,?2,?3)", t.name), key, data, t.options.Clock.Now())
-	t.mu.Unlock()
-	return err
+	return t.save(key, data, 0)
+}
+
+// SaveBytesWithTTL saves data which expires after ttl. It never expires if ttl isn't positive
+func (t *KVTable) SaveBytesWithTTL(key string, data []byte, ttl time.Duration) error {
+	return t.save(key, data, ttl)
 }
 
 func (t *KVTable) Bytes(key string) ([]byte, error) {
 	var v []byte
 	t.mu.RLock()
-	err := t.db.QueryRow(fmt.Sprintf("SELECT v FROM %s WHERE k=?", t.name), key).Scan(&v)
+	err := t.db.QueryRow(fmt.Sprintf("SELECT v FROM %s WHERE k=? AND %s", t.name, notExpired), key, t.now()).Scan(&v)
 	t.mu.RUnlock()
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
@@ -107,24 +157,25 @@ func (t *KVTable) Bytes(key string) ([]byte, error) {
 }
 
 func (t *KVTable) SaveObject(key string, obj any) error {
+	return t.SaveObjectWithTTL(key, obj, 0)
+}
+
+// SaveObjectWithTTL saves obj which expires after ttl. It never expires if ttl isn't positive
+func (t *KVTable) SaveObjectWithTTL(key string, obj any, ttl time.Duration) error {
 	data, err := t.encode(obj)
 	if err != nil {
 		return err
 	}
-	t.mu.Lock()
 	if obj == nil {
-		_, err = t.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE k=?1", t.name), key)
-	} else {
-		_, err = t.db.Exec(fmt.Sprintf("REPLACE INTO %s(k,v,updated_at) VALUES(?1,?2,?3)", t.name), key, data, t.options.Clock.Now())
+		return t.Delete(key)
 	}
-	t.mu.Unlock()
-	return err
+	return t.save(key, data, ttl)
 }
 
 func (t *KVTable) GetObject(key string, ptrToObj any) error {
 	var data []byte
 	t.mu.RLock()
-	err := t.db.QueryRow(fmt.Sprintf("SELECT v FROM %s WHERE k=?", t.name), key).Scan(&data)
+	err := t.db.QueryRow(fmt.Sprintf("SELECT v FROM %s WHERE k=? AND %s", t.name, notExpired), key, t.now()).Scan(&data)
 	t.mu.RUnlock()
 	if err != nil {
 		return err
@@ -135,11 +186,11 @@ func (t *KVTable) GetObject(key string, ptrToObj any) error {
 func (t *KVTable) ListKeys(prefix string) ([]string, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	query := "SELECT k FROM " + t.name
+	query := "SELECT k FROM " + t.name + " WHERE " + notExpired
 	if prefix != "" {
-		query += " WHERE k LIKE '" + prefix + "%'"
+		query += " AND k LIKE '" + prefix + "%'"
 	}
-	rows, err := t.db.Query(query)
+	rows, err := t.db.Query(query, t.now())
 	if err != nil {
 		return nil, fmt.Errorf("failed executing %s: %w", query, err)
 	}
@@ -157,28 +208,63 @@ func (t *KVTable) ListKeys(prefix string) ([]string, error) {
 }
 
 func (t *KVTable) Delete(key string) error {
-	t.mu.Lock()
-	_, err := t.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE k=?", t.name), key)
-	t.mu.Unlock()
+	_, err := t.delete("k=?", key)
 	return err
 }
 
 func (t *KVTable) DeleteWithPrefix(prefix string) error {
-	t.mu.Lock()
-	_, err := t.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE k like '%s%%'", t.name, prefix))
-	t.mu.Unlock()
+	_, err := t.delete(fmt.Sprintf("k like '%s%%'", prefix))
 	return err
 }
 
 func (t *KVTable) Exists(key string) (bool, error) {
 	t.mu.RLock()
 	var exists bool
-	err := t.db.QueryRow(fmt.Sprintf("SELECT EXISTS(SELECT * FROM %s WHERE k=?)", t.name), key).Scan(&exists)
+	err := t.db.QueryRow(fmt.Sprintf("SELECT EXISTS(SELECT * FROM %s WHERE k=? AND %s)", t.name, notExpired), key, t.now()).Scan(&exists)
 	t.mu.RUnlock()
 	return exists, err
 }
 
+// Purge deletes expired keys in batches of batchSize, and returns number of deleted keys
+// Expired keys are invisible to reads, and they're only deleted by Purge or background purging
+func (t *KVTable) Purge(batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultKVPurgeBatchSize
+	}
+	now := t.now()
+	where := fmt.Sprintf("k IN (SELECT k FROM %s WHERE expires_at>0 AND expires_at<=? LIMIT ?)", t.name)
+	total := 0
+	for {
+		// the lock is released between batches, so that reads and writes aren't blocked for long
+		n, err := t.delete(where, now, batchSize)
+		total += n
+		if err != nil || n < batchSize {
+			return total, err
+		}
+	}
+}
+
+func (t *KVTable) purgePeriodically() {
+	ticker := time.NewTicker(t.options.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if n, err := t.Purge(0); err != nil {
+				log.Println("failed purging expired keys", t.name, err)
+			} else if n > 0 {
+				log.Printf("purged %d expired keys in %s\n", n, t.name)
+			}
+		}
+	}
+}
+
 func (t *KVTable) Close() error {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
 	if t.db == nil {
 		return nil
 	}
@@ -190,6 +276,74 @@ func (t *KVTable) Close() error {
 	return nil
 }
 
+// save replaces value of key which expires after ttl, and notifies observers after unlocking
+func (t *KVTable) save(key string, v any, ttl time.Duration) error {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = t.options.Clock.Now().Add(ttl).UnixMilli()
+	}
+	t.mu.Lock()
+	kind := Updated
+	if t.observed() {
+		if keys, err := t.listKeys("k=? AND "+notExpired, key, t.now()); err == nil && len(keys) == 0 {
+			kind = Inserted
+		}
+	}
+	_, err := t.db.Exec(fmt.Sprintf("REPLACE INTO %s(k,v,updated_at,expires_at) VALUES(?1,?2,?3,?4)", t.name),
+		key, v, t.options.Clock.Now(), expiresAt)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	t.notify(kind, key)
+	return nil
+}
+
+// delete deletes keys matched by where, notifies observers of deleted keys, and returns number of deleted keys
+func (t *KVTable) delete(where string, args ...any) (int, error) {
+	t.mu.Lock()
+	var keys []string
+	var err error
+	if t.observed() {
+		keys, err = t.listKeys(where, args...)
+	}
+	var n int64
+	if err == nil {
+		var res sql.Result
+		res, err = t.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", t.name, where), args...)
+		if err == nil {
+			n, err = res.RowsAffected()
+		}
+	}
+	t.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	t.notify(Deleted, keys...)
+	return int(n), nil
+}
+
+func (t *KVTable) now() int64 {
+	return t.options.Clock.Now().UnixMilli()
+}
+
+func (t *KVTable) listKeys(where string, args ...any) ([]string, error) {
+	rows, err := t.db.Query(fmt.Sprintf("SELECT k FROM %s WHERE %s", t.name, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
 func (t *KVTable) encode(obj any) ([]byte, error) {
 	return json.Marshal(obj)
 }
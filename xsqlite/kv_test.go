@@ -0,0 +1,72 @@
+package xsqlite
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"go.olapie.com/x/xtest"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestKVTable_TTL(t *testing.T) {
+	db, err := sql.Open("sqlite", "file::memory:")
+	xtest.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	// table created before expiration is supported
+	_, err = db.Exec(`CREATE TABLE kv(k VARCHAR(255) PRIMARY KEY, v BLOB NOT NULL, updated_at BIGINT NOT NULL)`)
+	xtest.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO kv(k,v,updated_at) VALUES('old','v',0)`)
+	xtest.NoError(t, err)
+
+	clock := &fakeClock{now: time.Now()}
+	kv := NewKVTable(db, "kv", func(options *KVTableOptions) {
+		options.Clock = clock
+	})
+	v, err := kv.String("old")
+	xtest.NoError(t, err)
+	xtest.Equal(t, "v", v)
+
+	xtest.NoError(t, kv.SaveStringWithTTL("short", "s", time.Minute))
+	xtest.NoError(t, kv.SaveInt64WithTTL("long", 1, time.Hour))
+	xtest.NoError(t, kv.SaveObjectWithTTL("obj", []int{1}, time.Minute))
+	n, err := kv.Purge(0)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 0, n)
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	_, err = kv.String("short")
+	xtest.Error(t, err)
+	exists, err := kv.Exists("obj")
+	xtest.NoError(t, err)
+	xtest.False(t, exists)
+	keys, err := kv.ListKeys("")
+	xtest.NoError(t, err)
+	xtest.Equal(t, 2, len(keys))
+
+	// expired keys are deleted by Purge, and saving again makes key alive
+	xtest.NoError(t, kv.SaveString("short", "s2"))
+	for i := 0; i < 5; i++ {
+		xtest.NoError(t, kv.SaveInt64WithTTL(string(rune('a'+i)), 1, time.Second))
+	}
+	clock.now = clock.now.Add(time.Minute)
+	n, err = kv.Purge(2)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 6, n)
+	v, err = kv.String("short")
+	xtest.NoError(t, err)
+	xtest.Equal(t, "s2", v)
+	i, err := kv.Int64("long")
+	xtest.NoError(t, err)
+	xtest.Equal(t, int64(1), i)
+}
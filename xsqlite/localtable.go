@@ -28,13 +28,23 @@ type LocalTableOptions[R any] struct {
 	LocalCacheSize    int
 	RemoteCacheSize   int
 	DeletionCacheSize int
+
+	// Indexes are fields stored in side columns, which ListBy can filter and sort by
+	// Existing records are indexed when an index is added
+	Indexes []Index[R]
+
+	// SearchText extracts text of records which is indexed with FTS5 for Search
+	// Existing records are indexed when search is enabled
+	SearchText func(r R) string
 }
 
 type LocalTable[R any] struct {
+	observable
 	db            *sql.DB
 	localCache    *lru.Cache[string, R]
 	remoteCache   *lru.Cache[string, R]
 	deletionCache *lru.Cache[string, bool]
+	search        *searchIndex
 	options       LocalTableOptions[R]
 }
 
@@ -96,6 +106,23 @@ func NewLocalTable[R any](db *sql.DB, optFns ...func(*LocalTableOptions[R])) *Lo
     delete_time INTEGER
 )`))
 
+	if err := t.migrateIndexes(context.Background()); err != nil {
+		panic(fmt.Errorf("migrate indexes: %w", err))
+	}
+
+	if t.options.SearchText != nil {
+		search, created, err := newSearchIndex(db, "search_index", t.options.Password)
+		if err != nil {
+			panic(err)
+		}
+		t.search = search
+		if created {
+			if err = t.buildSearchIndex(context.Background()); err != nil {
+				panic(fmt.Errorf("build search index: %w", err))
+			}
+		}
+	}
+
 	return t
 }
 
@@ -132,19 +159,24 @@ func (t *LocalTable[R]) SaveRemote(ctx context.Context, localID string, category
 		return fmt.Errorf("encode: %s, %w", localID, err)
 	}
 
-	_, err = t.db.ExecContext(ctx, `REPLACE INTO remotes(id, category, data, update_time, synced) VALUES(?,?,?,?,1)`,
-		localID, category, data, updateTime)
+	kind := t.changeKind(ctx, localID)
+	placeholders, indexArgs := t.indexInsert(record)
+	_, err = t.db.ExecContext(ctx, `REPLACE INTO remotes(id, category, data, update_time, synced`+t.indexColumns()+`) VALUES(?,?,?,?,1`+placeholders+`)`,
+		append([]any{localID, category, data, updateTime}, indexArgs...)...)
 	if err != nil {
 		return fmt.Errorf("replace into remotes: %s,%w", localID, err)
 	}
 	t.remoteCache.Add(localID, record)
+	if err = t.indexText(ctx, t.db, localID, record); err != nil {
+		return err
+	}
 
 	_, err = t.db.ExecContext(ctx, `DELETE FROM locals WHERE id=? AND update_time<=?`, localID, updateTime)
 	if err != nil {
 		return fmt.Errorf("delete locals: %s, %w", localID, err)
 	}
 	t.localCache.Remove(localID)
-
+	t.notify(kind, localID)
 	return nil
 }
 
@@ -155,12 +187,18 @@ func (t *LocalTable[R]) SaveLocal(ctx context.Context, localID string, category
 		return fmt.Errorf("encode: %s, %w", localID, err)
 	}
 
-	_, err = t.db.ExecContext(ctx, `REPLACE INTO locals(id,category, data, update_time) VALUES(?,?,?,?)`,
-		localID, category, data, t.options.Clock.Now().Unix())
+	kind := t.changeKind(ctx, localID)
+	placeholders, indexArgs := t.indexInsert(record)
+	_, err = t.db.ExecContext(ctx, `REPLACE INTO locals(id,category, data, update_time`+t.indexColumns()+`) VALUES(?,?,?,?`+placeholders+`)`,
+		append([]any{localID, category, data, t.options.Clock.Now().Unix()}, indexArgs...)...)
 	if err != nil {
 		return fmt.Errorf("replace into locals: %s, %w", localID, err)
 	}
 	t.localCache.Add(localID, record)
+	if err = t.indexText(ctx, t.db, localID, record); err != nil {
+		return err
+	}
+	t.notify(kind, localID)
 	return nil
 }
 
@@ -168,11 +206,13 @@ func (t *LocalTable[R]) Delete(ctx context.Context, localID string) error {
 	// delete from locals
 	// delete from remotes
 	// save in delete_record
-	_, err := t.db.ExecContext(ctx, `DELETE FROM locals WHERE id=?`, localID)
+	res, err := t.db.ExecContext(ctx, `DELETE FROM locals WHERE id=?`, localID)
 	if err != nil {
 		return fmt.Errorf("delete locals: %s, %w", localID, err)
 	}
 	t.localCache.Remove(localID)
+	n, _ := res.RowsAffected()
+	deleted := n > 0
 
 	var remoteData []byte
 	var category int
@@ -197,6 +237,13 @@ func (t *LocalTable[R]) Delete(ctx context.Context, localID string) error {
 			return fmt.Errorf("delete remotes: %s, %w", localID, err)
 		}
 		t.remoteCache.Remove(localID)
+		deleted = true
+	}
+	if err = t.unindexText(ctx, t.db, localID); err != nil {
+		return err
+	}
+	if deleted {
+		t.notify(Deleted, localID)
 	}
 	return nil
 }
@@ -385,8 +432,27 @@ func (t *LocalTable[R]) RemoveDeletions(ctx context.Context, localIDs ...string)
 }
 
 func (t *LocalTable[R]) RemoveAllRemotes(ctx context.Context) error {
+	var deletedIDs, updatedIDs []string
+	if t.observed() {
+		// remotes which have locals are replaced by locals
+		var err error
+		deletedIDs, err = t.listIDs(ctx, `SELECT id FROM remotes WHERE id NOT IN (SELECT id FROM locals)`)
+		if err != nil {
+			return err
+		}
+		updatedIDs, err = t.listIDs(ctx, `SELECT id FROM remotes WHERE id IN (SELECT id FROM locals)`)
+		if err != nil {
+			return err
+		}
+	}
 	_, err := t.db.ExecContext(ctx, `DELETE FROM remotes`)
-	return err
+	if err != nil {
+		return err
+	}
+	t.remoteCache.Purge()
+	t.notify(Deleted, deletedIDs...)
+	t.notify(Updated, updatedIDs...)
+	return nil
 }
 
 func (t *LocalTable[R]) Get(ctx context.Context, localID string) (record R, err error) {
@@ -492,13 +558,14 @@ func (t *LocalTable[R]) updateLocal(ctx context.Context, localID string, record
 		}
 		optionalData = &data
 	}
-	_, err := t.db.ExecContext(ctx, `UPDATE locals SET data=?, update_time=? WHERE id=?`,
-		*optionalData, t.options.Clock.Now().Unix(), localID)
+	sets, indexArgs := t.indexUpdate(record)
+	args := append(append([]any{*optionalData, t.options.Clock.Now().Unix()}, indexArgs...), localID)
+	res, err := t.db.ExecContext(ctx, `UPDATE locals SET data=?, update_time=?`+sets+` WHERE id=?`, args...)
 	if err != nil {
 		return fmt.Errorf("update locals: %w", err)
 	}
 	t.localCache.Add(localID, record)
-	return nil
+	return t.updated(ctx, res, localID, record)
 }
 
 func (t *LocalTable[R]) updateRemote(ctx context.Context, localID string, record R, optionalData *[]byte) error {
@@ -509,15 +576,71 @@ func (t *LocalTable[R]) updateRemote(ctx context.Context, localID string, record
 		}
 		optionalData = &data
 	}
-	_, err := t.db.ExecContext(ctx, `UPDATE remotes SET data=?, update_time=?, synced=0 WHERE id=?`,
-		*optionalData, t.options.Clock.Now().Unix(), localID)
+	sets, indexArgs := t.indexUpdate(record)
+	args := append(append([]any{*optionalData, t.options.Clock.Now().Unix()}, indexArgs...), localID)
+	res, err := t.db.ExecContext(ctx, `UPDATE remotes SET data=?, update_time=?, synced=0`+sets+` WHERE id=?`, args...)
 	if err != nil {
 		return fmt.Errorf("update remotes: %w", err)
 	}
 	t.remoteCache.Add(localID, record)
+	return t.updated(ctx, res, localID, record)
+}
+
+// updated indexes text of updated record, and notifies observers if it's updated
+func (t *LocalTable[R]) updated(ctx context.Context, res sql.Result, localID string, record R) error {
+	if err := t.indexText(ctx, t.db, localID, record); err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		t.notify(Updated, localID)
+	}
 	return nil
 }
 
+// changeKind returns Updated if record of localID exists, otherwise Inserted. Existence is queried only if t is observed
+func (t *LocalTable[R]) changeKind(ctx context.Context, localID string) ChangeKind {
+	if !t.observed() {
+		return Updated
+	}
+	ids, err := t.existingIDs(ctx, []string{localID})
+	if err != nil || ids[localID] {
+		return Updated
+	}
+	return Inserted
+}
+
+// existingIDs returns ids of localIDs which exist in remotes or locals
+func (t *LocalTable[R]) existingIDs(ctx context.Context, localIDs []string) (map[string]bool, error) {
+	condition, args := t.getBatchIDsCondition(localIDs...)
+	ids, err := t.listIDs(ctx, `SELECT id FROM remotes WHERE id IN `+condition+`
+UNION SELECT id FROM locals WHERE id IN `+condition, append(args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		m[id] = true
+	}
+	return m, nil
+}
+
+func (t *LocalTable[R]) listIDs(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %s, %w", query, err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func (t *LocalTable[R]) encryptTable(ctx context.Context, tableName string) (map[string][]byte, error) {
 	rows, err := t.db.QueryContext(ctx, `SELECT id, data FROM `+tableName)
 	if err != nil {
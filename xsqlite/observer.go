@@ -0,0 +1,124 @@
+package xsqlite
+
+import (
+	"strings"
+	"sync"
+)
+
+type ChangeKind int
+
+const (
+	Inserted ChangeKind = iota + 1
+	Updated
+	Deleted
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Inserted:
+		return "inserted"
+	case Updated:
+		return "updated"
+	case Deleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// ChangeEvent describes committed changes of records
+type ChangeEvent struct {
+	Kind ChangeKind
+
+	// Keys are ids of LocalTable and SimpleTable records, or keys of KVTable
+	Keys []string
+}
+
+// Observable is implemented by LocalTable, SimpleTable and KVTable
+type Observable interface {
+	Observe(fn func(e *ChangeEvent), keys ...string) (cancel func())
+	ObservePrefix(prefix string, fn func(e *ChangeEvent)) (cancel func())
+}
+
+type subscription struct {
+	keys   map[string]bool
+	prefix string
+	fn     func(e *ChangeEvent)
+}
+
+func (s *subscription) matches(key string) bool {
+	if s.keys != nil {
+		return s.keys[key]
+	}
+	return strings.HasPrefix(key, s.prefix)
+}
+
+// observable is embedded by tables to notify subscriptions
+type observable struct {
+	subscriptionsMu sync.RWMutex
+	subscriptions   map[*subscription]bool
+}
+
+// Observe calls fn after records of keys are inserted, updated or deleted, and all records are observed if keys is empty
+// fn is called synchronously by the goroutine which made the change, so it should return quickly
+func (o *observable) Observe(fn func(e *ChangeEvent), keys ...string) (cancel func()) {
+	s := &subscription{fn: fn}
+	if len(keys) > 0 {
+		s.keys = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			s.keys[k] = true
+		}
+	}
+	return o.subscribe(s)
+}
+
+// ObservePrefix calls fn after records whose keys have prefix are inserted, updated or deleted
+func (o *observable) ObservePrefix(prefix string, fn func(e *ChangeEvent)) (cancel func()) {
+	return o.subscribe(&subscription{prefix: prefix, fn: fn})
+}
+
+func (o *observable) subscribe(s *subscription) func() {
+	o.subscriptionsMu.Lock()
+	if o.subscriptions == nil {
+		o.subscriptions = make(map[*subscription]bool)
+	}
+	o.subscriptions[s] = true
+	o.subscriptionsMu.Unlock()
+	return func() {
+		o.subscriptionsMu.Lock()
+		delete(o.subscriptions, s)
+		o.subscriptionsMu.Unlock()
+	}
+}
+
+// observed reports whether there are subscriptions, so that changes needn't be collected if it's false
+func (o *observable) observed() bool {
+	o.subscriptionsMu.RLock()
+	defer o.subscriptionsMu.RUnlock()
+	return len(o.subscriptions) > 0
+}
+
+// notify calls subscriptions with their observed keys in keys
+func (o *observable) notify(kind ChangeKind, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	o.subscriptionsMu.RLock()
+	subscriptions := make([]*subscription, 0, len(o.subscriptions))
+	for s := range o.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	o.subscriptionsMu.RUnlock()
+
+	for _, s := range subscriptions {
+		var matched []string
+		for _, k := range keys {
+			if s.matches(k) {
+				matched = append(matched, k)
+			}
+		}
+		if len(matched) > 0 {
+			s.fn(&ChangeEvent{Kind: kind, Keys: matched})
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package xsqlite
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.olapie.com/x/xtest"
+)
+
+type changeRecorder struct {
+	events []ChangeEvent
+}
+
+func (r *changeRecorder) record(e *ChangeEvent) {
+	r.events = append(r.events, *e)
+}
+
+func TestKVTable_Observe(t *testing.T) {
+	db, err := sql.Open("sqlite", "file::memory:")
+	xtest.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	kv := NewKVTable(db, "kv")
+	all, prefixed, single := &changeRecorder{}, &changeRecorder{}, &changeRecorder{}
+	kv.Observe(all.record)
+	kv.ObservePrefix("user.", prefixed.record)
+	cancel := kv.Observe(single.record, "user.1")
+
+	xtest.NoError(t, kv.SaveString("user.1", "a"))
+	xtest.NoError(t, kv.SaveString("user.1", "b"))
+	xtest.NoError(t, kv.SaveInt64("user.2", 1))
+	xtest.NoError(t, kv.SaveInt64("count", 1))
+	cancel()
+	xtest.NoError(t, kv.DeleteWithPrefix("user."))
+	xtest.NoError(t, kv.Delete("none"))
+
+	xtest.Equal(t, []ChangeEvent{
+		{Inserted, []string{"user.1"}},
+		{Updated, []string{"user.1"}},
+		{Inserted, []string{"user.2"}},
+		{Inserted, []string{"count"}},
+		{Deleted, []string{"user.1", "user.2"}},
+	}, all.events)
+	xtest.Equal(t, 4, len(prefixed.events))
+	xtest.Equal(t, 2, len(single.events))
+}
+
+func TestSimpleTable_Observe(t *testing.T) {
+	tbl := createTable[int64, *IntItem](t, func(r *IntItem) int64 {
+		return r.ID
+	})
+	r := &changeRecorder{}
+	tbl.Observe(r.record)
+
+	item := &IntItem{ID: 1}
+	xtest.NoError(t, tbl.Insert(item))
+	xtest.NoError(t, tbl.Save(item))
+	xtest.NoError(t, tbl.Update(&IntItem{ID: 2}))
+	xtest.NoError(t, tbl.Save(&IntItem{ID: 2}))
+	xtest.NoError(t, tbl.DeleteLessThan(3))
+	xtest.Equal(t, []ChangeEvent{
+		{Inserted, []string{"1"}},
+		{Updated, []string{"1"}},
+		{Inserted, []string{"2"}},
+		{Deleted, []string{"1", "2"}},
+	}, r.events)
+}
+
+func TestLocalTable_Observe(t *testing.T) {
+	ctx := context.TODO()
+	table := setupLocalTable(t)
+	r := &changeRecorder{}
+	table.Observe(r.record)
+
+	id := uuid.NewString()
+	xtest.NoError(t, table.SaveLocal(ctx, id, 0, newLocalTableItem()))
+	xtest.NoError(t, table.Update(ctx, id, newLocalTableItem()))
+	xtest.NoError(t, table.SaveRemote(ctx, id, 0, newLocalTableItem(), time.Now().Unix()))
+	xtest.NoError(t, table.Delete(ctx, id))
+	xtest.NoError(t, table.Delete(ctx, id))
+	xtest.Equal(t, []ChangeEvent{
+		{Inserted, []string{id}},
+		{Updated, []string{id}},
+		{Updated, []string{id}},
+		{Deleted, []string{id}},
+	}, r.events)
+
+	t.Run("Pull", func(t *testing.T) {
+		r.events = nil
+		store := newMemoryStore()
+		syncer := NewSyncer[*localTableItem](table, store)
+		added := uuid.NewString()
+		store.put(&Change[*localTableItem]{LocalID: added, Record: newLocalTableItem(), UpdateTime: 1})
+		xtest.NoError(t, syncer.Pull(ctx))
+		xtest.NoError(t, store.Delete(ctx, []*Change[*localTableItem]{{LocalID: added}}))
+		xtest.NoError(t, syncer.Pull(ctx))
+		xtest.Equal(t, []ChangeEvent{
+			{Inserted, []string{added}},
+			{Deleted, []string{added}},
+		}, r.events)
+	})
+}
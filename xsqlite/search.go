@@ -0,0 +1,333 @@
+package xsqlite
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const snippetWords = 10
+
+var errSearchDisabled = errors.New("search is not enabled")
+
+type SearchResult[R any] struct {
+	Record R
+
+	// Snippet is matched text around the first matched term, and matched terms are enclosed in []
+	Snippet string
+
+	// Rank is bm25 score of the record. Lower is better
+	Rank float64
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// searchIndex is an FTS5 table which indexes text of records by id
+// If key is not nil, the table is encrypted, and tokens are indexed as keyed hashes instead of plain text,
+// so that only whole words can be matched, and snippets are made of decoded records
+type searchIndex struct {
+	name string
+	key  []byte
+}
+
+// newSearchIndex creates FTS5 table, and created is true if it didn't exist
+func newSearchIndex(db *sql.DB, name string, password string) (index *searchIndex, created bool, err error) {
+	index = &searchIndex{name: name}
+	if password != "" {
+		sum := sha256.Sum256([]byte("xsqlite.search:" + password))
+		index.key = sum[:]
+	}
+
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT * FROM sqlite_master WHERE name=?)`, name).Scan(&exists)
+	if err != nil {
+		return nil, false, fmt.Errorf("query sqlite_master: %w", err)
+	}
+	if exists {
+		return index, false, nil
+	}
+	_, err = db.Exec(fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS %s USING fts5(id UNINDEXED, text)`, name))
+	if err != nil {
+		return nil, false, fmt.Errorf("create virtual table %s: %w", name, err)
+	}
+	return index, true, nil
+}
+
+func (s *searchIndex) save(ctx context.Context, exe execer, id any, text string) error {
+	if err := s.delete(ctx, exe, id); err != nil {
+		return err
+	}
+	if s.key != nil {
+		text = strings.Join(s.hashes(tokenize(text)), " ")
+	}
+	_, err := exe.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s(id, text) VALUES(?,?)`, s.name), id, text)
+	if err != nil {
+		return fmt.Errorf("insert into %s: %w", s.name, err)
+	}
+	return nil
+}
+
+func (s *searchIndex) delete(ctx context.Context, exe execer, id any) error {
+	_, err := exe.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id=?`, s.name), id)
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// match returns FTS5 query of all terms in query, and the last term is matched as a prefix in plain tables
+func (s *searchIndex) match(terms []string) string {
+	if s.key != nil {
+		terms = s.hashes(terms)
+	}
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + t + `"`
+	}
+	if s.key == nil {
+		quoted[len(quoted)-1] += "*"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// snippetColumn returns expression of snippet column in search queries
+func (s *searchIndex) snippetColumn() string {
+	if s.key != nil {
+		return "''"
+	}
+	return fmt.Sprintf(`snippet(%s, 1, '[', ']', '…', %d)`, s.name, snippetWords)
+}
+
+func (s *searchIndex) hashes(tokens []string) []string {
+	l := make([]string, len(tokens))
+	for i, t := range tokens {
+		h := hmac.New(sha256.New, s.key)
+		h.Write([]byte(t))
+		l[i] = hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	return l
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// makeSnippet returns snippet of text around the first word matching terms, which is similar to FTS5 snippet
+func makeSnippet(text string, terms []string) string {
+	words := strings.Fields(text)
+	matched := func(word string) bool {
+		for _, token := range tokenize(word) {
+			for _, term := range terms {
+				if token == term {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	first := 0
+	for i, w := range words {
+		if matched(w) {
+			first = i
+			break
+		}
+	}
+	start := max(0, min(first-snippetWords/3, len(words)-snippetWords))
+	end := min(start+snippetWords, len(words))
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i, w := range words[start:end] {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if matched(w) {
+			b.WriteString("[" + w + "]")
+		} else {
+			b.WriteString(w)
+		}
+	}
+	if end < len(words) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// Search returns at most limit records matching all words in query, ordered by relevance
+// It requires LocalTableOptions.SearchText
+func (t *LocalTable[R]) Search(ctx context.Context, query string, limit int) ([]*SearchResult[R], error) {
+	if t.search == nil {
+		return nil, errSearchDisabled
+	}
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// locals which have been saved remotely are excluded as List does
+	q := fmt.Sprintf(`SELECT r.id, r.data, r.source, %[2]s, rank FROM %[1]s
+JOIN (SELECT id, data, 'remotes' AS source FROM remotes
+UNION ALL
+SELECT id, data, 'locals' AS source FROM locals WHERE id NOT IN (SELECT id FROM remotes)) r ON r.id=%[1]s.id
+WHERE %[1]s MATCH ? ORDER BY rank LIMIT ?`, t.search.name, t.search.snippetColumn())
+	rows, err := t.db.QueryContext(ctx, q, t.search.match(terms), limit)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %s, %w", q, err)
+	}
+	defer rows.Close()
+
+	var results []*SearchResult[R]
+	for rows.Next() {
+		var localID, source string
+		var data []byte
+		res := &SearchResult[R]{}
+		if err = rows.Scan(&localID, &data, &source, &res.Snippet, &res.Rank); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		cache := t.localCache
+		if source == "remotes" {
+			cache = t.remoteCache
+		}
+		var ok bool
+		if res.Record, ok = cache.Get(localID); !ok {
+			if res.Record, err = t.decode(localID, data); err != nil {
+				return nil, fmt.Errorf("decode: %w", err)
+			}
+			cache.Add(localID, res.Record)
+		}
+		if t.search.key != nil {
+			res.Snippet = makeSnippet(t.options.SearchText(res.Record), terms)
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+func (t *LocalTable[R]) indexText(ctx context.Context, exe execer, localID string, record R) error {
+	if t.search == nil {
+		return nil
+	}
+	return t.search.save(ctx, exe, localID, t.options.SearchText(record))
+}
+
+func (t *LocalTable[R]) unindexText(ctx context.Context, exe execer, localID string) error {
+	if t.search == nil {
+		return nil
+	}
+	return t.search.delete(ctx, exe, localID)
+}
+
+// buildSearchIndex indexes text of all records
+func (t *LocalTable[R]) buildSearchIndex(ctx context.Context) error {
+	for _, tableName := range []string{"remotes", "locals"} {
+		ids, records, err := t.list(ctx, tableName, "")
+		if err != nil {
+			return fmt.Errorf("list %s: %w", tableName, err)
+		}
+		for i, id := range ids {
+			if err = t.indexText(ctx, t.db, id, records[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Search returns at most limit records matching all words in query, ordered by relevance
+// It requires SimpleTableOptions.SearchText
+func (t *SimpleTable[K, R]) Search(ctx context.Context, query string, limit int) ([]*SearchResult[R], error) {
+	if t.search == nil {
+		return nil, errSearchDisabled
+	}
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	q := fmt.Sprintf(`SELECT t.id, t.data, %[3]s, rank FROM %[2]s JOIN %[1]s t ON t.id=%[2]s.id
+WHERE %[2]s MATCH ? ORDER BY rank LIMIT ?`, t.name, t.search.name, t.search.snippetColumn())
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rows, err := t.db.QueryContext(ctx, q, t.search.match(terms), limit)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %s, %w", q, err)
+	}
+	defer rows.Close()
+
+	var results []*SearchResult[R]
+	for rows.Next() {
+		var key K
+		var data []byte
+		res := &SearchResult[R]{}
+		if err = rows.Scan(&key, &data, &res.Snippet, &res.Rank); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		if res.Record, err = t.decode(key, data); err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+		if t.search.key != nil {
+			res.Snippet = makeSnippet(t.options.SearchText(res.Record), terms)
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+func (t *SimpleTable[K, R]) indexText(key K, record R) error {
+	if t.search == nil {
+		return nil
+	}
+	return t.search.save(context.Background(), t.db, key, t.options.SearchText(record))
+}
+
+// unindexDeleted removes text of deleted records
+func (t *SimpleTable[K, R]) unindexDeleted() error {
+	if t.search == nil {
+		return nil
+	}
+	_, err := t.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id NOT IN (SELECT id FROM %s)`, t.search.name, t.name))
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", t.search.name, err)
+	}
+	return nil
+}
+
+func (t *SimpleTable[K, R]) buildSearchIndex() error {
+	rows, err := t.db.Query(fmt.Sprintf(`SELECT id, data FROM %s`, t.name))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	records := make(map[K]R)
+	for rows.Next() {
+		var key K
+		var data []byte
+		if err = rows.Scan(&key, &data); err != nil {
+			return err
+		}
+		if records[key], err = t.decode(key, data); err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+	}
+	rows.Close()
+	for key, r := range records {
+		if err = t.indexText(key, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package xsqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.olapie.com/x/xtest"
+)
+
+func TestLocalTable_Search(t *testing.T) {
+	ctx := context.TODO()
+	for _, password := range []string{"", uuid.NewString()} {
+		t.Run("Password"+password, func(t *testing.T) {
+			db, err := sql.Open("sqlite", "file::memory:")
+			xtest.NoError(t, err)
+			t.Cleanup(func() {
+				db.Close()
+			})
+			optFn := func(opts *LocalTableOptions[*localTableItem]) {
+				opts.Password = password
+				opts.SearchText = func(r *localTableItem) string {
+					return r.Text
+				}
+			}
+
+			table := NewLocalTable[*localTableItem](db, func(opts *LocalTableOptions[*localTableItem]) {
+				opts.Password = password
+			})
+			remote := &localTableItem{Text: "Buy milk and bread on the way home"}
+			xtest.NoError(t, table.SaveRemote(ctx, uuid.NewString(), 0, remote, time.Now().Unix()))
+
+			// existing records are indexed when search is enabled
+			table = NewLocalTable[*localTableItem](db, optFn)
+			local := &localTableItem{Text: "Bread recipe with milk, flour and yeast"}
+			localID := uuid.NewString()
+			xtest.NoError(t, table.SaveLocal(ctx, localID, 0, local))
+
+			results, err := table.Search(ctx, "milk bread", 10)
+			xtest.NoError(t, err)
+			xtest.Equal(t, 2, len(results))
+
+			results, err = table.Search(ctx, "YEAST", 10)
+			xtest.NoError(t, err)
+			xtest.Equal(t, 1, len(results))
+			xtest.Equal(t, local, results[0].Record)
+			xtest.True(t, strings.Contains(results[0].Snippet, "[yeast]"), results[0].Snippet)
+
+			local.Text = "Pancakes"
+			xtest.NoError(t, table.Update(ctx, localID, local))
+			results, err = table.Search(ctx, "yeast", 10)
+			xtest.NoError(t, err)
+			xtest.Equal(t, 0, len(results))
+
+			xtest.NoError(t, table.Delete(ctx, localID))
+			results, err = table.Search(ctx, "pancakes", 10)
+			xtest.NoError(t, err)
+			xtest.Equal(t, 0, len(results))
+
+			if password != "" {
+				var text string
+				err = db.QueryRow(`SELECT text FROM search_index`).Scan(&text)
+				xtest.NoError(t, err)
+				xtest.False(t, strings.Contains(text, "milk"), text)
+			}
+		})
+	}
+}
+
+func TestSimpleTable_Search(t *testing.T) {
+	ctx := context.TODO()
+	db, err := sql.Open("sqlite", "file::memory:")
+	xtest.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	tbl, err := NewSimpleTable[int64, *IntItem](db, "items", func(r *IntItem) int64 {
+		return r.ID
+	}, func(options *SimpleTableOptions[int64, *IntItem]) {
+		options.SearchText = func(r *IntItem) string {
+			return r.Name
+		}
+	})
+	xtest.NoError(t, err)
+
+	items := []*IntItem{{ID: 1, Name: "Quarterly report draft"}, {ID: 2, Name: "Report of the trip"}, {ID: 3, Name: "Groceries"}}
+	for _, item := range items {
+		xtest.NoError(t, tbl.Insert(item))
+	}
+
+	results, err := tbl.Search(ctx, "rep", 10)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 2, len(results))
+
+	items[0].Name = "Quarterly summary"
+	xtest.NoError(t, tbl.Update(items[0]))
+	xtest.NoError(t, tbl.DeleteGreaterThan(2))
+	results, err = tbl.Search(ctx, "quarterly", 10)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 1, len(results))
+	xtest.Equal(t, items[0], results[0].Record)
+	xtest.Equal(t, "[Quarterly] summary", results[0].Snippet)
+
+	results, err = tbl.Search(ctx, "groceries", 10)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 0, len(results))
+
+	// text of a record which isn't updated is not indexed
+	xtest.NoError(t, tbl.Update(&IntItem{ID: 9, Name: "Ghost"}))
+	var n int
+	xtest.NoError(t, db.QueryRow("SELECT COUNT(*) FROM items_search WHERE id = ?", 9).Scan(&n))
+	xtest.Equal(t, 0, n)
+}
@@ -17,6 +17,10 @@ type SimpleTableOptions[K SimpleKey, R any] struct {
 	MarshalFunc   func(r R) ([]byte, error)
 	UnmarshalFunc func(data []byte, r *R) error
 	Password      string
+
+	// SearchText extracts text of records which is indexed with FTS5 for Search
+	// Existing records are indexed when search is enabled
+	SearchText func(r R) string
 }
 
 type SimpleKey interface {
@@ -24,6 +28,7 @@ type SimpleKey interface {
 }
 
 type SimpleTable[K SimpleKey, R any] struct {
+	observable
 	options SimpleTableOptions[K, R]
 	name    string
 	db      *sql.DB
@@ -40,7 +45,8 @@ type SimpleTable[K SimpleKey, R any] struct {
 		deleteGreaterThan *sql.Stmt
 		deleteLessThan    *sql.Stmt
 	}
-	pkFn func(r R) K
+	pkFn   func(r R) K
+	search *searchIndex
 }
 
 func NewSimpleTable[K SimpleKey, R any](db *sql.DB, name string, primaryKeyFunc func(r R) K, optFns ...func(options *SimpleTableOptions[K, R])) (*SimpleTable[K, R], error) {
@@ -87,6 +93,19 @@ updated_at BIGINT
 	t.stmts.delete = xsql.MustPrepare(db, `DELETE FROM %s WHERE id=?`, name)
 	t.stmts.deleteGreaterThan = xsql.MustPrepare(db, `DELETE FROM %s WHERE id>?`, name)
 	t.stmts.deleteLessThan = xsql.MustPrepare(db, `DELETE FROM %s WHERE id<?`, name)
+
+	if t.options.SearchText != nil {
+		search, created, err := newSearchIndex(db, name+"_search", t.options.Password)
+		if err != nil {
+			return nil, err
+		}
+		t.search = search
+		if created {
+			if err = t.buildSearchIndex(); err != nil {
+				return nil, fmt.Errorf("build search index: %w", err)
+			}
+		}
+	}
 	return t, nil
 }
 
@@ -96,10 +115,7 @@ func (t *SimpleTable[K, R]) Insert(v R) error {
 	if err != nil {
 		return err
 	}
-	t.mu.Lock()
-	_, err = t.stmts.insert.Exec(key, b, t.options.Clock.Now())
-	t.mu.Unlock()
-	return err
+	return t.write(t.stmts.insert, Inserted, key, v, key, b, t.options.Clock.Now())
 }
 
 func (t *SimpleTable[K, R]) Update(v R) error {
@@ -108,10 +124,7 @@ func (t *SimpleTable[K, R]) Update(v R) error {
 	if err != nil {
 		return err
 	}
-	t.mu.Lock()
-	_, err = t.stmts.update.Exec(key, b, t.options.Clock.Now())
-	t.mu.Unlock()
-	return err
+	return t.write(t.stmts.update, Updated, key, v, b, t.options.Clock.Now(), key)
 }
 
 func (t *SimpleTable[K, R]) Save(v R) error {
@@ -120,10 +133,7 @@ func (t *SimpleTable[K, R]) Save(v R) error {
 	if err != nil {
 		return err
 	}
-	t.mu.Lock()
-	_, err = t.stmts.save.Exec(key, b, t.options.Clock.Now())
-	t.mu.Unlock()
-	return err
+	return t.write(t.stmts.save, 0, key, v, key, b, t.options.Clock.Now())
 }
 
 func (t *SimpleTable[K, R]) Get(key K) (R, error) {
@@ -198,24 +208,85 @@ func (t *SimpleTable[K, R]) readList(rows *sql.Rows) ([]R, error) {
 }
 
 func (t *SimpleTable[K, R]) Delete(key K) error {
-	t.mu.Lock()
-	_, err := t.stmts.delete.Exec(key)
-	t.mu.Unlock()
-	return err
+	return t.delete(t.stmts.delete, "id=?", key)
 }
 
 func (t *SimpleTable[K, R]) DeleteGreaterThan(key K) error {
+	return t.delete(t.stmts.deleteGreaterThan, "id>?", key)
+}
+
+func (t *SimpleTable[K, R]) DeleteLessThan(key K) error {
+	return t.delete(t.stmts.deleteLessThan, "id<?", key)
+}
+
+// write executes stmt which writes v, and notifies observers after unlocking
+// kind is zero if stmt replaces v, then it's resolved by whether key exists
+func (t *SimpleTable[K, R]) write(stmt *sql.Stmt, kind ChangeKind, key K, v R, args ...any) error {
 	t.mu.Lock()
-	_, err := t.stmts.deleteGreaterThan.Exec(key)
+	if kind == 0 {
+		kind = Updated
+		if t.observed() {
+			if keys, err := t.listKeys("id=?", key); err == nil && len(keys) == 0 {
+				kind = Inserted
+			}
+		}
+	}
+	var n int64
+	res, err := stmt.Exec(args...)
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	// text of an unchanged record, e.g. skipped by a version condition, must not be indexed
+	if err == nil && n > 0 {
+		err = t.indexText(key, v)
+	}
 	t.mu.Unlock()
-	return err
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		t.notify(kind, fmt.Sprint(key))
+	}
+	return nil
 }
 
-func (t *SimpleTable[K, R]) DeleteLessThan(key K) error {
+// delete executes stmt which deletes records matched by where, and notifies observers of deleted keys
+func (t *SimpleTable[K, R]) delete(stmt *sql.Stmt, where string, key K) error {
 	t.mu.Lock()
-	_, err := t.stmts.deleteLessThan.Exec(key)
+	var keys []string
+	var err error
+	if t.observed() {
+		keys, err = t.listKeys(where, key)
+	}
+	if err == nil {
+		_, err = stmt.Exec(key)
+	}
+	if err == nil {
+		err = t.unindexDeleted()
+	}
 	t.mu.Unlock()
-	return err
+	if err != nil {
+		return err
+	}
+	t.notify(Deleted, keys...)
+	return nil
+}
+
+func (t *SimpleTable[K, R]) listKeys(where string, args ...any) ([]string, error) {
+	rows, err := t.db.Query(fmt.Sprintf(`SELECT id FROM %s WHERE %s`, t.name, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key K
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, fmt.Sprint(key))
+	}
+	return keys, rows.Err()
 }
 
 func (t *SimpleTable[K, R]) encode(key K, r R) (data []byte, err error) {
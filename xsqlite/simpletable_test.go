@@ -100,6 +100,25 @@ func TestIntTable(t *testing.T) {
 	xtest.Equal(t, true, errors.Is(err, sql.ErrNoRows))
 }
 
+func TestSimpleTable_Update(t *testing.T) {
+	tbl := createTable[string, *StringItem](t, func(item *StringItem) string {
+		return item.ID
+	})
+	item := newStringItem()
+	xtest.NoError(t, tbl.Insert(item))
+	other := newStringItem()
+	xtest.NoError(t, tbl.Insert(other))
+
+	item.Name = "updated"
+	xtest.NoError(t, tbl.Update(item))
+	v, err := tbl.Get(item.ID)
+	xtest.NoError(t, err)
+	xtest.Equal(t, item, v)
+	v, err = tbl.Get(other.ID)
+	xtest.NoError(t, err)
+	xtest.Equal(t, other, v)
+}
+
 func TestStringTable(t *testing.T) {
 	t.Log("TestStringTable")
 
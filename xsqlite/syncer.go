@@ -0,0 +1,586 @@
+package xsqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Change is a created, updated or deleted record of LocalTable
+type Change[R any] struct {
+	LocalID  string
+	Category int
+	Record   R
+
+	// UpdateTime is unix seconds when the record was updated, or deleted if Deleted is true
+	UpdateTime int64
+	Deleted    bool
+
+	// data is the stored data when the change was listed, which detects local changes made during a push
+	data []byte
+}
+
+// RemoteStore is the remote API which LocalTable is synchronized with
+type RemoteStore[R any] interface {
+	// Create saves records created locally
+	// Store may set UpdateTime and Record of changes to values which are saved remotely
+	Create(ctx context.Context, changes []*Change[R]) error
+
+	// Update saves records updated locally. Store may set UpdateTime and Record as Create does
+	Update(ctx context.Context, changes []*Change[R]) error
+
+	// Delete deletes records deleted locally
+	Delete(ctx context.Context, changes []*Change[R]) error
+
+	// Pull returns at most limit changes after cursor in order, and cursor of the last returned change
+	// cursor is empty for the first pull. Fewer than limit changes means there are no more changes
+	Pull(ctx context.Context, cursor string, limit int) (changes []*Change[R], next string, err error)
+}
+
+// ConflictResolver returns the winner of a pending local change and a pulled remote change of the same record
+// The winner is applied if it's remote, otherwise it's kept as a pending local change and pushed later
+// It can also return a new change which merges both
+type ConflictResolver[R any] func(local, remote *Change[R]) *Change[R]
+
+// LastWriterWins is the default ConflictResolver which returns the change with later update time
+// Remote change wins if both changes have the same update time
+func LastWriterWins[R any](local, remote *Change[R]) *Change[R] {
+	if local.UpdateTime > remote.UpdateTime {
+		return local
+	}
+	return remote
+}
+
+type SyncStage string
+
+const (
+	SyncPull          SyncStage = "pull"
+	SyncPushCreations SyncStage = "push_creations"
+	SyncPushUpdates   SyncStage = "push_updates"
+	SyncPushDeletions SyncStage = "push_deletions"
+)
+
+type SyncProgress struct {
+	Stage SyncStage
+	Done  int
+
+	// Total is -1 if it's unknown, e.g. number of remote changes to pull
+	Total int
+}
+
+type SyncerOptions[R any] struct {
+	// Name identifies the persisted cursor if multiple syncers share a database. Default is "default"
+	Name string
+
+	// BatchSize is max number of changes in a remote request. Default is 100
+	BatchSize int
+
+	// MaxRetries is max number of retries of a failed remote request. Default is 3
+	MaxRetries int
+
+	// Backoff is delay before the first retry, and it's doubled for every next retry. Default is 500ms
+	Backoff time.Duration
+
+	// IsRetryable reports whether failed remote request can be retried. Default retries all errors except context errors
+	IsRetryable func(err error) bool
+
+	// Resolver resolves conflicts of pulled changes. Default is LastWriterWins
+	Resolver ConflictResolver[R]
+
+	// Progress is called after every batch of changes is pulled or pushed
+	Progress func(p SyncProgress)
+}
+
+// Syncer synchronizes LocalTable with RemoteStore incrementally
+// Local creations, updates and deletions are pushed, and remote changes since the persisted cursor are pulled
+type Syncer[R any] struct {
+	table   *LocalTable[R]
+	store   RemoteStore[R]
+	options SyncerOptions[R]
+	mu      sync.Mutex
+}
+
+func NewSyncer[R any](table *LocalTable[R], store RemoteStore[R], optFns ...func(*SyncerOptions[R])) *Syncer[R] {
+	s := &Syncer[R]{
+		table: table,
+		store: store,
+		options: SyncerOptions[R]{
+			Name:       "default",
+			BatchSize:  100,
+			MaxRetries: 3,
+			Backoff:    500 * time.Millisecond,
+		},
+	}
+
+	for _, fn := range optFns {
+		fn(&s.options)
+	}
+
+	if s.options.BatchSize <= 0 {
+		s.options.BatchSize = 100
+	}
+	if s.options.IsRetryable == nil {
+		s.options.IsRetryable = func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		}
+	}
+	if s.options.Resolver == nil {
+		s.options.Resolver = LastWriterWins[R]
+	}
+
+	MustGet(table.db.Exec(`CREATE TABLE IF NOT EXISTS sync_cursors(
+    name VARCHAR PRIMARY KEY,
+    value VARCHAR,
+    update_time INTEGER
+)`))
+	return s
+}
+
+// Sync pulls remote changes, then pushes local changes
+func (s *Syncer[R]) Sync(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.pull(ctx); err != nil {
+		return err
+	}
+	return s.push(ctx)
+}
+
+// Pull pulls remote changes since the persisted cursor
+func (s *Syncer[R]) Pull(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pull(ctx)
+}
+
+// Push pushes local creations, updates and deletions
+func (s *Syncer[R]) Push(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.push(ctx)
+}
+
+// Cursor returns the persisted cursor, which is empty if nothing has been pulled
+func (s *Syncer[R]) Cursor(ctx context.Context) (string, error) {
+	var cursor string
+	err := s.table.db.QueryRowContext(ctx, `SELECT value FROM sync_cursors WHERE name=?`, s.options.Name).Scan(&cursor)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("query sync_cursors: %w", err)
+	}
+	return cursor, nil
+}
+
+// ResetCursor clears the persisted cursor, so that all remote changes are pulled again by next sync
+func (s *Syncer[R]) ResetCursor(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.table.db.ExecContext(ctx, `DELETE FROM sync_cursors WHERE name=?`, s.options.Name)
+	if err != nil {
+		return fmt.Errorf("delete sync_cursors: %w", err)
+	}
+	return nil
+}
+
+func (s *Syncer[R]) pull(ctx context.Context) error {
+	cursor, err := s.Cursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	done := 0
+	for {
+		var changes []*Change[R]
+		var next string
+		err = s.retry(ctx, func() error {
+			var err error
+			changes, next, err = s.store.Pull(ctx, cursor, s.options.BatchSize)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("pull: %w", err)
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		if err = s.applyRemoteChanges(ctx, changes, next); err != nil {
+			return fmt.Errorf("apply remote changes: %w", err)
+		}
+		cursor = next
+		done += len(changes)
+		s.report(SyncPull, done, -1)
+		if len(changes) < s.options.BatchSize {
+			return nil
+		}
+	}
+}
+
+// applyRemoteChanges applies changes and saves cursor in one transaction
+func (s *Syncer[R]) applyRemoteChanges(ctx context.Context, changes []*Change[R], cursor string) error {
+	ids := make([]string, len(changes))
+	for i, c := range changes {
+		ids[i] = c.LocalID
+	}
+	pendings, err := s.table.pendingChanges(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("query pending changes: %w", err)
+	}
+	existing := make(map[string]bool)
+	if s.table.observed() {
+		if existing, err = s.table.existingIDs(ctx, ids); err != nil {
+			return fmt.Errorf("query existing ids: %w", err)
+		}
+	}
+
+	// keys of visible changes which are notified after commit
+	events := make(map[ChangeKind][]string)
+	err = s.table.inTx(ctx, ids, func(tx *sql.Tx) error {
+		for _, c := range changes {
+			local := pendings[c.LocalID]
+			winner := c
+			if local != nil {
+				winner = s.options.Resolver(local, c)
+			}
+
+			var err error
+			if winner == c {
+				err = s.table.applyRemote(ctx, tx, c)
+				delete(pendings, c.LocalID)
+			} else {
+				err = s.table.savePending(ctx, tx, winner, !c.Deleted)
+				pendings[c.LocalID] = winner
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", c.LocalID, err)
+			}
+
+			if winner == local {
+				continue
+			}
+			switch {
+			case winner.Deleted && existing[c.LocalID]:
+				events[Deleted] = append(events[Deleted], c.LocalID)
+			case winner.Deleted:
+			case existing[c.LocalID]:
+				events[Updated] = append(events[Updated], c.LocalID)
+			default:
+				events[Inserted] = append(events[Inserted], c.LocalID)
+			}
+			existing[c.LocalID] = !winner.Deleted
+		}
+
+		_, err := tx.ExecContext(ctx, `REPLACE INTO sync_cursors(name, value, update_time) VALUES(?,?,?)`,
+			s.options.Name, cursor, s.table.options.Clock.Now().Unix())
+		if err != nil {
+			return fmt.Errorf("replace into sync_cursors: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, kind := range []ChangeKind{Inserted, Updated, Deleted} {
+		s.table.notify(kind, events[kind]...)
+	}
+	return nil
+}
+
+func (s *Syncer[R]) push(ctx context.Context) error {
+	creations, err := s.table.listChanges(ctx, "locals", "")
+	if err != nil {
+		return fmt.Errorf("list locals: %w", err)
+	}
+	err = s.pushChanges(ctx, SyncPushCreations, creations, s.store.Create, s.table.markCreated)
+	if err != nil {
+		return fmt.Errorf("push creations: %w", err)
+	}
+
+	updates, err := s.table.listChanges(ctx, "remotes", "synced=0")
+	if err != nil {
+		return fmt.Errorf("list updates: %w", err)
+	}
+	err = s.pushChanges(ctx, SyncPushUpdates, updates, s.store.Update, s.table.markUpdated)
+	if err != nil {
+		return fmt.Errorf("push updates: %w", err)
+	}
+
+	deletions, err := s.table.listChanges(ctx, "deletions", "")
+	if err != nil {
+		return fmt.Errorf("list deletions: %w", err)
+	}
+	err = s.pushChanges(ctx, SyncPushDeletions, deletions, s.store.Delete, s.table.markDeleted)
+	if err != nil {
+		return fmt.Errorf("push deletions: %w", err)
+	}
+	return nil
+}
+
+// pushChanges sends changes in batches, and marks every sent batch as synced
+func (s *Syncer[R]) pushChanges(ctx context.Context, stage SyncStage, changes []*Change[R],
+	send func(context.Context, []*Change[R]) error,
+	mark func(context.Context, *sql.Tx, *Change[R]) error) error {
+	for start := 0; start < len(changes); start += s.options.BatchSize {
+		batch := changes[start:min(start+s.options.BatchSize, len(changes))]
+		ids := make([]string, len(batch))
+		for i, c := range batch {
+			ids[i] = c.LocalID
+		}
+
+		if err := s.retry(ctx, func() error { return send(ctx, batch) }); err != nil {
+			return err
+		}
+
+		err := s.table.inTx(ctx, ids, func(tx *sql.Tx) error {
+			for _, c := range batch {
+				if err := mark(ctx, tx, c); err != nil {
+					return fmt.Errorf("%s: %w", c.LocalID, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.report(stage, start+len(batch), len(changes))
+	}
+	return nil
+}
+
+func (s *Syncer[R]) retry(ctx context.Context, fn func() error) error {
+	backoff := s.options.Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.options.MaxRetries || !s.options.IsRetryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *Syncer[R]) report(stage SyncStage, done, total int) {
+	if s.options.Progress != nil {
+		s.options.Progress(SyncProgress{Stage: stage, Done: done, Total: total})
+	}
+}
+
+// listChanges returns changes in table locals, remotes or deletions
+func (t *LocalTable[R]) listChanges(ctx context.Context, tableName string, where string, args ...any) ([]*Change[R], error) {
+	timeColumn := "update_time"
+	if tableName == "deletions" {
+		timeColumn = "delete_time"
+	}
+	if where != "" {
+		where = " WHERE " + where
+	}
+	query := fmt.Sprintf(`SELECT id, category, data, COALESCE(%s, 0) FROM %s%s`, timeColumn, tableName, where)
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %s, %w", query, err)
+	}
+	defer rows.Close()
+
+	var changes []*Change[R]
+	for rows.Next() {
+		c := &Change[R]{Deleted: tableName == "deletions"}
+		if err = rows.Scan(&c.LocalID, &c.Category, &c.data, &c.UpdateTime); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", tableName, err)
+		}
+		if c.Record, err = t.decode(c.LocalID, c.data); err != nil {
+			return nil, fmt.Errorf("decode: %s, %w", c.LocalID, err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// pendingChanges returns unpushed changes of localIDs
+func (t *LocalTable[R]) pendingChanges(ctx context.Context, localIDs []string) (map[string]*Change[R], error) {
+	condition, args := t.getBatchIDsCondition(localIDs...)
+	m := make(map[string]*Change[R])
+	sources := []struct {
+		tableName string
+		where     string
+	}{
+		{"locals", "id IN " + condition},
+		{"remotes", "synced=0 AND id IN " + condition},
+		{"deletions", "id IN " + condition},
+	}
+	for _, src := range sources {
+		changes, err := t.listChanges(ctx, src.tableName, src.where, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			m[c.LocalID] = c
+		}
+	}
+	return m, nil
+}
+
+// applyRemote saves remote change as synced, and discards local changes of the record
+func (t *LocalTable[R]) applyRemote(ctx context.Context, tx *sql.Tx, c *Change[R]) error {
+	if !c.Deleted {
+		data, err := t.encode(c.LocalID, c.Record)
+		if err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+		placeholders, indexArgs := t.indexInsert(c.Record)
+		_, err = tx.ExecContext(ctx, `REPLACE INTO remotes(id, category, data, update_time, synced`+t.indexColumns()+`) VALUES(?,?,?,?,1`+placeholders+`)`,
+			append([]any{c.LocalID, c.Category, data, c.UpdateTime}, indexArgs...)...)
+		if err != nil {
+			return fmt.Errorf("replace into remotes: %w", err)
+		}
+	} else if _, err := tx.ExecContext(ctx, `DELETE FROM remotes WHERE id=?`, c.LocalID); err != nil {
+		return fmt.Errorf("delete remotes: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM locals WHERE id=?`, c.LocalID); err != nil {
+		return fmt.Errorf("delete locals: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM deletions WHERE id=?`, c.LocalID); err != nil {
+		return fmt.Errorf("delete deletions: %w", err)
+	}
+	if c.Deleted {
+		return t.unindexText(ctx, tx, c.LocalID)
+	}
+	return t.indexText(ctx, tx, c.LocalID, c.Record)
+}
+
+// savePending saves c as a pending local change which is pushed by next sync
+// existsRemotely determines whether it's pushed as a creation, or an update or deletion
+func (t *LocalTable[R]) savePending(ctx context.Context, tx *sql.Tx, c *Change[R], existsRemotely bool) error {
+	data, err := t.encode(c.LocalID, c.Record)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	// the record is removed from tables other than the one keeping the pending change
+	keep := "locals"
+	switch {
+	case c.Deleted && !existsRemotely:
+		keep = ""
+	case c.Deleted:
+		keep = "deletions"
+	case existsRemotely:
+		keep = "remotes"
+	}
+	for _, name := range []string{"locals", "remotes", "deletions"} {
+		if name == keep {
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, `DELETE FROM `+name+` WHERE id=?`, c.LocalID); err != nil {
+			return fmt.Errorf("delete %s: %w", name, err)
+		}
+	}
+
+	placeholders, indexArgs := t.indexInsert(c.Record)
+	args := append([]any{c.LocalID, c.Category, data, c.UpdateTime}, indexArgs...)
+	switch keep {
+	case "locals":
+		_, err = tx.ExecContext(ctx, `REPLACE INTO locals(id, category, data, update_time`+t.indexColumns()+`) VALUES(?,?,?,?`+placeholders+`)`,
+			args...)
+	case "remotes":
+		_, err = tx.ExecContext(ctx, `REPLACE INTO remotes(id, category, data, update_time, synced`+t.indexColumns()+`) VALUES(?,?,?,?,0`+placeholders+`)`,
+			args...)
+	case "deletions":
+		_, err = tx.ExecContext(ctx, `REPLACE INTO deletions(id, category, data, delete_time) VALUES(?,?,?,?)`,
+			c.LocalID, c.Category, data, c.UpdateTime)
+	}
+	if err != nil {
+		return fmt.Errorf("replace into %s: %w", keep, err)
+	}
+	if c.Deleted {
+		return t.unindexText(ctx, tx, c.LocalID)
+	}
+	return t.indexText(ctx, tx, c.LocalID, c.Record)
+}
+
+// markCreated moves pushed creation from locals to remotes
+// If the record was changed after it was listed, the change is kept pending as an update or deletion
+// Changes are detected by data rather than update_time, which cannot tell edits made in the same second
+func (t *LocalTable[R]) markCreated(ctx context.Context, tx *sql.Tx, c *Change[R]) error {
+	res, err := tx.ExecContext(ctx, `DELETE FROM locals WHERE id=? AND data=?`, c.LocalID, c.data)
+	if err != nil {
+		return fmt.Errorf("delete locals: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return t.applyRemote(ctx, tx, c)
+	}
+
+	columns := t.indexColumns()
+	res, err = tx.ExecContext(ctx, `REPLACE INTO remotes(id, category, data, update_time, synced`+columns+`)
+SELECT id, category, data, update_time, 0`+columns+` FROM locals WHERE id=?`, c.LocalID)
+	if err != nil {
+		return fmt.Errorf("replace into remotes: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// it was deleted locally, but Delete didn't record the deletion as it wasn't remote yet
+		return t.savePending(ctx, tx, &Change[R]{
+			LocalID:    c.LocalID,
+			Category:   c.Category,
+			Record:     c.Record,
+			UpdateTime: t.options.Clock.Now().Unix(),
+			Deleted:    true,
+		}, true)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM locals WHERE id=?`, c.LocalID)
+	return err
+}
+
+// markUpdated marks pushed update as synced unless the record was changed after it was listed
+func (t *LocalTable[R]) markUpdated(ctx context.Context, tx *sql.Tx, c *Change[R]) error {
+	data, err := t.encode(c.LocalID, c.Record)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	sets, indexArgs := t.indexUpdate(c.Record)
+	args := append(append([]any{data, c.UpdateTime}, indexArgs...), c.LocalID, c.data)
+	res, err := tx.ExecContext(ctx, `UPDATE remotes SET data=?, update_time=?, synced=1`+sets+` WHERE id=? AND data=? AND synced=0`,
+		args...)
+	if err != nil {
+		return fmt.Errorf("update remotes: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	return t.indexText(ctx, tx, c.LocalID, c.Record)
+}
+
+// markDeleted removes pushed deletion
+func (t *LocalTable[R]) markDeleted(ctx context.Context, tx *sql.Tx, c *Change[R]) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM deletions WHERE id=? AND data=?`, c.LocalID, c.data)
+	if err != nil {
+		return fmt.Errorf("delete deletions: %w", err)
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, and evicts cached records of localIDs which fn may change
+func (t *LocalTable[R]) inTx(ctx context.Context, localIDs []string, fn func(tx *sql.Tx) error) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	defer t.forget(localIDs...)
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *LocalTable[R]) forget(localIDs ...string) {
+	for _, id := range localIDs {
+		t.localCache.Remove(id)
+		t.remoteCache.Remove(id)
+		t.deletionCache.Remove(id)
+	}
+}
@@ -0,0 +1,279 @@
+package xsqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.olapie.com/x/xtest"
+)
+
+// memoryStore is a RemoteStore whose cursor is the index in its change log
+type memoryStore struct {
+	mu       sync.Mutex
+	records  map[string]*Change[*localTableItem]
+	log      []*Change[*localTableItem]
+	failures int
+	requests int
+
+	// sending is called with changes being created or updated, e.g. to change records locally during a push
+	sending func(changes []*Change[*localTableItem])
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[string]*Change[*localTableItem])}
+}
+
+func (s *memoryStore) fail() error {
+	s.requests++
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func (s *memoryStore) put(c *Change[*localTableItem]) {
+	copied := *c
+	s.records[c.LocalID] = &copied
+	s.log = append(s.log, &copied)
+}
+
+func (s *memoryStore) Create(ctx context.Context, changes []*Change[*localTableItem]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail(); err != nil {
+		return err
+	}
+	if s.sending != nil {
+		s.sending(changes)
+	}
+	for _, c := range changes {
+		s.put(c)
+	}
+	return nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, changes []*Change[*localTableItem]) error {
+	return s.Create(ctx, changes)
+}
+
+func (s *memoryStore) Delete(ctx context.Context, changes []*Change[*localTableItem]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail(); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		delete(s.records, c.LocalID)
+		s.log = append(s.log, &Change[*localTableItem]{LocalID: c.LocalID, UpdateTime: c.UpdateTime, Deleted: true})
+	}
+	return nil
+}
+
+func (s *memoryStore) Pull(ctx context.Context, cursor string, limit int) ([]*Change[*localTableItem], string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail(); err != nil {
+		return nil, "", err
+	}
+	var start int
+	if cursor != "" {
+		fmt.Sscan(cursor, &start)
+	}
+	end := min(start+limit, len(s.log))
+	var changes []*Change[*localTableItem]
+	for _, c := range s.log[start:end] {
+		copied := *c
+		changes = append(changes, &copied)
+	}
+	return changes, fmt.Sprint(end), nil
+}
+
+func setupSyncer(t *testing.T, store *memoryStore, optFns ...func(*SyncerOptions[*localTableItem])) (*LocalTable[*localTableItem], *Syncer[*localTableItem]) {
+	table := setupLocalTable(t)
+	optFns = append([]func(*SyncerOptions[*localTableItem]){func(options *SyncerOptions[*localTableItem]) {
+		options.BatchSize = 2
+		options.Backoff = time.Millisecond
+	}}, optFns...)
+	return table, NewSyncer[*localTableItem](table, store, optFns...)
+}
+
+func TestSyncer_Push(t *testing.T) {
+	ctx := context.TODO()
+	store := newMemoryStore()
+	var progresses []SyncProgress
+	table, syncer := setupSyncer(t, store, func(options *SyncerOptions[*localTableItem]) {
+		options.Progress = func(p SyncProgress) {
+			progresses = append(progresses, p)
+		}
+	})
+
+	ids := make([]string, 3)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+		xtest.NoError(t, table.SaveLocal(ctx, ids[i], 0, newLocalTableItem()))
+	}
+	store.failures = 2
+	xtest.NoError(t, syncer.Push(ctx))
+	xtest.Equal(t, 3, len(store.records))
+	xtest.Equal(t, []SyncProgress{{SyncPushCreations, 2, 3}, {SyncPushCreations, 3, 3}}, progresses)
+
+	locals, err := table.ListLocals(ctx)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 0, len(locals))
+	remotes, err := table.ListRemotes(ctx)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 3, len(remotes))
+
+	item := newLocalTableItem()
+	xtest.NoError(t, table.Update(ctx, ids[0], item))
+	xtest.NoError(t, table.Delete(ctx, ids[1]))
+	xtest.NoError(t, syncer.Push(ctx))
+	xtest.Equal(t, item, store.records[ids[0]].Record)
+	xtest.Equal(t, 2, len(store.records))
+
+	updates, err := table.ListUpdates(ctx)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 0, len(updates))
+	deletions, err := table.ListDeletions(ctx)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 0, len(deletions))
+
+	// changes made during a push in the same second as the pushed ones are kept pending
+	t.Run("ChangedWhilePushing", func(t *testing.T) {
+		edited := newLocalTableItem()
+		store.sending = func(changes []*Change[*localTableItem]) {
+			for _, c := range changes {
+				xtest.NoError(t, table.Update(ctx, c.LocalID, edited))
+			}
+		}
+		defer func() {
+			store.sending = nil
+		}()
+
+		created := uuid.NewString()
+		xtest.NoError(t, table.SaveLocal(ctx, created, 0, newLocalTableItem()))
+		xtest.NoError(t, table.Update(ctx, ids[0], newLocalTableItem()))
+		xtest.NoError(t, syncer.Push(ctx))
+		for _, id := range []string{created, ids[0]} {
+			record, err := table.Get(ctx, id)
+			xtest.NoError(t, err)
+			xtest.Equal(t, edited, record)
+		}
+		updates, err := table.ListUpdates(ctx)
+		xtest.NoError(t, err)
+		xtest.Equal(t, 2, len(updates))
+
+		store.sending = nil
+		xtest.NoError(t, syncer.Push(ctx))
+		xtest.Equal(t, edited, store.records[created].Record)
+		xtest.Equal(t, edited, store.records[ids[0]].Record)
+		updates, err = table.ListUpdates(ctx)
+		xtest.NoError(t, err)
+		xtest.Equal(t, 0, len(updates))
+	})
+
+	t.Run("RetryExhausted", func(t *testing.T) {
+		xtest.NoError(t, table.SaveLocal(ctx, uuid.NewString(), 0, newLocalTableItem()))
+		store.failures = 4
+		xtest.Error(t, syncer.Push(ctx))
+		locals, err := table.ListLocals(ctx)
+		xtest.NoError(t, err)
+		xtest.Equal(t, 1, len(locals))
+	})
+}
+
+func TestSyncer_Pull(t *testing.T) {
+	ctx := context.TODO()
+	store := newMemoryStore()
+	now := time.Now().Unix()
+	for i := 0; i < 5; i++ {
+		store.put(&Change[*localTableItem]{LocalID: uuid.NewString(), Record: newLocalTableItem(), UpdateTime: now})
+	}
+	deleted := store.log[0].LocalID
+	xtest.NoError(t, store.Delete(ctx, []*Change[*localTableItem]{{LocalID: deleted, UpdateTime: now}}))
+
+	table, syncer := setupSyncer(t, store)
+	xtest.NoError(t, syncer.Pull(ctx))
+	remotes, err := table.ListRemotes(ctx)
+	xtest.NoError(t, err)
+	xtest.Equal(t, 4, len(remotes))
+	_, err = table.Get(ctx, deleted)
+	xtest.Error(t, err)
+
+	cursor, err := syncer.Cursor(ctx)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "6", cursor)
+
+	// a new syncer resumes from the persisted cursor
+	requests := store.requests
+	syncer = NewSyncer[*localTableItem](table, store)
+	xtest.NoError(t, syncer.Pull(ctx))
+	xtest.Equal(t, requests+1, store.requests)
+
+	xtest.NoError(t, syncer.ResetCursor(ctx))
+	cursor, err = syncer.Cursor(ctx)
+	xtest.NoError(t, err)
+	xtest.Equal(t, "", cursor)
+}
+
+func TestSyncer_Conflict(t *testing.T) {
+	ctx := context.TODO()
+	t.Run("LastWriterWins", func(t *testing.T) {
+		store := newMemoryStore()
+		table, syncer := setupSyncer(t, store)
+		older, newer := uuid.NewString(), uuid.NewString()
+		for _, id := range []string{older, newer} {
+			xtest.NoError(t, table.SaveRemote(ctx, id, 0, newLocalTableItem(), 1))
+		}
+		localItem := newLocalTableItem()
+		xtest.NoError(t, table.Update(ctx, older, localItem))
+		xtest.NoError(t, table.Update(ctx, newer, localItem))
+
+		remoteItem := newLocalTableItem()
+		future := time.Now().Add(time.Hour).Unix()
+		store.put(&Change[*localTableItem]{LocalID: older, Record: remoteItem, UpdateTime: 2})
+		store.put(&Change[*localTableItem]{LocalID: newer, Record: remoteItem, UpdateTime: future})
+
+		xtest.NoError(t, syncer.Sync(ctx))
+		got, err := table.Get(ctx, older)
+		xtest.NoError(t, err)
+		xtest.Equal(t, localItem, got)
+		xtest.Equal(t, localItem, store.records[older].Record)
+
+		got, err = table.Get(ctx, newer)
+		xtest.NoError(t, err)
+		xtest.Equal(t, remoteItem, got)
+		xtest.Equal(t, remoteItem, store.records[newer].Record)
+	})
+
+	t.Run("Resolver", func(t *testing.T) {
+		store := newMemoryStore()
+		merged := newLocalTableItem()
+		table, syncer := setupSyncer(t, store, func(options *SyncerOptions[*localTableItem]) {
+			options.Resolver = func(local, remote *Change[*localTableItem]) *Change[*localTableItem] {
+				c := *remote
+				c.Record = merged
+				return &c
+			}
+		})
+		id := uuid.NewString()
+		xtest.NoError(t, table.SaveRemote(ctx, id, 0, newLocalTableItem(), 1))
+		xtest.NoError(t, table.Delete(ctx, id))
+		store.put(&Change[*localTableItem]{LocalID: id, Record: newLocalTableItem(), UpdateTime: 2})
+
+		xtest.NoError(t, syncer.Sync(ctx))
+		got, err := table.Get(ctx, id)
+		xtest.NoError(t, err)
+		xtest.Equal(t, merged, got)
+		xtest.Equal(t, merged, store.records[id].Record)
+		deletions, err := table.ListDeletions(ctx)
+		xtest.NoError(t, err)
+		xtest.Equal(t, 0, len(deletions))
+	})
+}